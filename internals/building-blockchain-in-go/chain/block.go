@@ -0,0 +1,252 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/xav-b/blockchain/encoding"
+	"github.com/xav-b/blockchain/merkle"
+	"github.com/xav-b/blockchain/pow"
+)
+
+// BLOCK_VERSION is the version of the blocks signalling no deployment, see
+// versionbits.go. It is part of the hash of the block, like in Bitcoin.
+const BLOCK_VERSION = VERSIONBITS_TOP_BITS
+
+// Block is a simplified implementation of what is described in Bitcoin
+type Block struct {
+	// Block version number
+	Version int
+	// Timestamp is when the block is created
+	Timestamp int64
+
+	Transactions []*Transaction
+
+	PrevBlockHash []byte
+	// Hash is the succcessful hash computed by the PoW
+	Hash []byte
+	// we also save the nonce so it's possible to verify the PoW
+	Nonce int64
+	// Height is the position of the block in the chain, genesis being 0
+	Height int
+	// a proof of authority block is signed by one of the signers of the
+	// chain, and a proof of stake block by its proposer, Signer being its
+	// public key
+	Signer    []byte
+	Signature []byte
+
+	// a pruned block was loaded from its header only: it has no transactions
+	// but remembers their merkle root
+	pruned     bool
+	merkleRoot []byte
+}
+
+// IsPruned tells whether the transactions of the block were pruned
+func (b *Block) IsPruned() bool {
+	return b.pruned
+}
+
+// MineBlock computes the proof of work of a block of the given version with
+// the transactions, timestamped at the given Unix time, using the function of
+// the chain.
+//
+// At a high enough difficulty, none of the nonces may give a hash below the
+// target. The miner then refreshes the timestamp and increments an extra
+// nonce in the coinbase, which changes the merkle root and so gives a whole
+// new range of nonces to try.
+func MineBlock(function pow.Function, version int, transactions []*Transaction, prevBlockHash []byte, height int, timestamp int64) *Block {
+	block := newBlock(transactions, prevBlockHash, height, timestamp)
+	block.Version = version
+	mineBlock(block, function, pow.MAX_NONCE)
+
+	return block
+}
+
+// newBlock returns the block before it is sealed
+func newBlock(transactions []*Transaction, prevBlockHash []byte, height int, timestamp int64) *Block {
+	return &Block{
+		Version:       BLOCK_VERSION,
+		Timestamp:     timestamp,
+		Transactions:  transactions,
+		PrevBlockHash: prevBlockHash,
+		Hash:          []byte{},
+		Nonce:         0,
+		Height:        height,
+	}
+}
+
+// mineBlock looks for the proof of work of the block, trying nonces up to
+// maxNonce before rolling the extra nonce and the timestamp
+func mineBlock(block *Block, function pow.Function, maxNonce int64) {
+	// the coinbase of the caller, left alone while we roll copies of it
+	var coinbase *Transaction
+
+	for extraNonce := 1; ; extraNonce++ {
+		nonce, hash, err := block.ProofOfWork(function).MineUpTo(maxNonce)
+		if err == nil {
+			block.Hash = hash
+			block.Nonce = nonce
+
+			return
+		}
+
+		if now := AdjustedTime(); now > block.Timestamp {
+			block.Timestamp = now
+		}
+
+		// without a coinbase to roll, we can only wait for the clock
+		if len(block.Transactions) == 0 {
+			continue
+		}
+		if _, err := block.Transactions[0].CoinbaseHeight(); err != nil {
+			continue
+		}
+		if coinbase == nil {
+			coinbase = block.Transactions[0]
+		}
+		rolled := coinbase.WithExtraNonce(encoding.IntToHex(int64(extraNonce)))
+		block.Transactions = append([]*Transaction{rolled}, block.Transactions[1:]...)
+	}
+}
+
+// ProofOfWork returns the proof of work committing to the header of the block
+func (b *Block) ProofOfWork(function pow.Function) *pow.ProofOfWork {
+	return function.ProofOfWork(b.powHeader())
+}
+
+// ShareProofOfWork returns the proof of work of the block against the easier
+// target of a mining pool share
+func (b *Block) ShareProofOfWork(function pow.Function, shareBits int) *pow.ProofOfWork {
+	return function.ShareProofOfWork(b.powHeader(), shareBits)
+}
+
+func (b *Block) powHeader() pow.Header {
+	return pow.Header{
+		Version:       b.hashedVersion(),
+		PrevBlockHash: b.PrevBlockHash,
+		MerkleRoot:    b.HashTransactions(),
+		Timestamp:     b.Timestamp,
+		Nonce:         b.Nonce,
+	}
+}
+
+// hashedVersion returns the version the hash of the block commits to, 0 for
+// none: the blocks mined before version bits, of version 1, keep the hash
+// they had without it
+func (b *Block) hashedVersion() int {
+	if !usesVersionBits(b.Version) {
+		return 0
+	}
+
+	return b.Version
+}
+
+// Serialize translates all block information into a format easy to store or
+// transfer
+func (b *Block) Serialize() []byte {
+	var result bytes.Buffer
+	// we pick the `gob` library as it is part of the standard library and does
+	// the work good enough for our pet implementation. Valid alternatives could
+	// be JSON, protocol buffers, ...
+	encoder := gob.NewEncoder(&result)
+
+	// encoding to memory only fails on unsupported types
+	if err := encoder.Encode(b); err != nil {
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// DeserializeBlock decodes a block, failing on corrupted data
+func DeserializeBlock(d []byte) (*Block, error) {
+	var block Block
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&block)
+
+	return &block, err
+}
+
+// // HashTransactions returns a hash of the transactions in the block
+// Bitcoin represents all transactions containing in a block as a Merkle tree
+// and uses the root hash of the tree in the Proof-of-Work system. This approach
+// allows to quickly check if a block contains certain transaction, having only
+// just the root hash and without downloading all the transactions.
+func (b *Block) HashTransactions() []byte {
+	var transactions [][]byte
+
+	if b.pruned {
+		return b.merkleRoot
+	}
+
+	// aggregate the serialization of all transactions, the witnesses aside:
+	// the coinbase commits to them
+	for _, tx := range b.Transactions {
+		transactions = append(transactions, tx.serializeNoWitness())
+	}
+	// create a Merkle Tree. All the transactions are the bottom level of the
+	// tree, and they are hashed by pairs up to one root node, and therefore one
+	// hash that guarantees their consistency
+	mTree := merkle.NewMerkleTree(transactions)
+
+	// that is this root hash that we return
+	return mTree.RootNode.Data
+}
+
+// BlockHeader is what is left of a block once pruned: everything but the
+// transactions, summarized by their merkle root
+type BlockHeader struct {
+	Version       int
+	Timestamp     int64
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Hash          []byte
+	Nonce         int64
+	Height        int
+	Signer        []byte
+	Signature     []byte
+}
+
+// NewBlockHeader extracts the header of a block
+func NewBlockHeader(b *Block) BlockHeader {
+	return BlockHeader{b.Version, b.Timestamp, b.PrevBlockHash, b.HashTransactions(), b.Hash, b.Nonce, b.Height, b.Signer, b.Signature}
+}
+
+// Block returns a pruned block out of the header
+func (h BlockHeader) Block() *Block {
+	return &Block{
+		Version:       h.Version,
+		Timestamp:     h.Timestamp,
+		PrevBlockHash: h.PrevBlockHash,
+		Hash:          h.Hash,
+		Nonce:         h.Nonce,
+		Height:        h.Height,
+		Signer:        h.Signer,
+		Signature:     h.Signature,
+		pruned:        true,
+		merkleRoot:    h.MerkleRoot,
+	}
+}
+
+// Serialize serializes the header
+func (h BlockHeader) Serialize() []byte {
+	var result bytes.Buffer
+
+	encoder := gob.NewEncoder(&result)
+	if err := encoder.Encode(h); err != nil {
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// DeserializeBlockHeader deserializes a header
+func DeserializeBlockHeader(d []byte) (BlockHeader, error) {
+	var header BlockHeader
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&header)
+
+	return header, err
+}
@@ -0,0 +1,80 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/encoding"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestMineBlockRollsExtraNonce(t *testing.T) {
+	_, address := newWallets(t)
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	coinbase := newCoinbase(t, address, 1)
+	coinbaseID := coinbase.ID
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	block := &Block{
+		Version:       BLOCK_VERSION,
+		Timestamp:     nextTimestamp(mtp),
+		Transactions:  []*Transaction{coinbase},
+		PrevBlockHash: bc.Tip(),
+		Height:        1,
+	}
+
+	// a single nonce to try, the extra nonce has to roll until it works
+	mineBlock(block, pow.Default, 0)
+	assert.Equal(t, int64(0), block.Nonce)
+	assert.True(t, block.ProofOfWork(pow.Default).Validate())
+	assert.True(t, bytes.Equal(block.ProofOfWork(pow.Default).Hash(), block.Hash))
+
+	assert.Equal(t, coinbaseID, coinbase.ID, "Coinbase of the caller is left alone")
+	height, err := block.Transactions[0].CoinbaseHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, height, "Extra nonce comes after the height")
+	assert.Nil(t, bc.ConnectBlock(block))
+}
+
+func TestBlockVersionCommitment(t *testing.T) {
+	_, address := newWallets(t)
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	// blocks mined before version bits are of version 1, which they did not
+	// commit to
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	legacy := newBlock([]*Transaction{newCoinbase(t, address, 1)}, bc.Tip(), 1, nextTimestamp(mtp))
+	legacy.Version = 1
+	mineBlock(legacy, pow.Default, pow.MAX_NONCE)
+	data := bytes.Join([][]byte{
+		legacy.PrevBlockHash,
+		legacy.HashTransactions(),
+		encoding.IntToHex(legacy.Timestamp),
+		encoding.IntToHex(int64(pow.Default.Bits)),
+		encoding.IntToHex(legacy.Nonce),
+	}, []byte{})
+	hash := sha256.Sum256(data)
+	assert.Equal(t, hash[:], legacy.Hash)
+
+	// but the version bits are committed to
+	signalling := *legacy
+	signalling.Version = BLOCK_VERSION
+	assert.NotEqual(t, legacy.Hash, signalling.ProofOfWork(pow.Default).Hash())
+
+	assert.Nil(t, bc.ConnectBlock(legacy))
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,754 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xav-b/blockchain/storage"
+)
+
+const (
+	DB_FILE = "blockchain.db"
+	// how long to wait for the lock on the database file
+	DB_OPEN_TIMEOUT = 1 * time.Second
+	// bitcoin (for exmaple) stores 4 different entites but at this stage blocks
+	// are the only bits of data to be persisted
+	BLOCKS_BUCKET = "blocks"
+
+	// actual first Bitcoin message wthin the first transaction
+	// check: https://www.blockchain.com/btc/tx/4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b?show_adv=true
+	// tutorial: https://medium.com/geekculture/decoding-bitcoins-first-block-coinbase-transaction-aeefe87ceec0
+	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
+)
+
+var (
+	// ErrDBLocked is returned when another process, most likely a `bc serve`
+	// daemon, holds the database
+	ErrDBLocked = fmt.Errorf("%s is locked by another process, is `bc serve` running?", DB_FILE)
+	// ErrInvalidTransaction is returned when mining transactions that break
+	// the rules
+	ErrInvalidTransaction = errors.New("invalid transaction")
+)
+
+// Blockchain Iterator lets us go through the saved blockchain, in a way wich is
+// ordered (by the chain of blocks) and efficient (without loading all blocks in
+// memory)
+type BlockchainIterator struct {
+	// currentHash is the pointer to the current block in the iteration
+	currentHash []byte
+	db          storage.Store
+}
+
+// Next yields the next block in the blockchain
+func (i *BlockchainIterator) Next() (*Block, error) {
+	var block *Block
+
+	err := i.db.View(func(tx storage.Tx) error {
+		var err error
+
+		// pruned blocks are yielded too, as their header, to keep walking
+		block, err = getBlock(tx, i.currentHash)
+		if err == ErrBlockPruned {
+			return nil
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// point at the next (older) block in the chain
+	i.currentHash = block.PrevBlockHash
+
+	return block, nil
+}
+
+// Blockchain is safe for concurrent use: any number of readers, and one
+// writer at a time changing the chain (connecting, disconnecting or pruning
+// blocks, or rebuilding the UTXO set).
+type Blockchain struct {
+	// hash of the latest block. It is only changed with both locks held, so
+	// the writer can read it without taking mu.
+	tip []byte
+	mu  sync.RWMutex
+	// serializes the writers, the database alone only makes each of their
+	// transactions atomic
+	writer sync.Mutex
+	// blocks DB
+	db storage.Store
+	// consensus parameters and their engine, guarded by mu like the tip
+	params Params
+	engine ConsensusEngine
+	// the states of the deployments, by window
+	versionBitsCache *versionBitsCache
+
+	subscribers   map[*Subscription]struct{}
+	subscribersMu sync.Mutex
+}
+
+func newBlockchain(tip []byte, db storage.Store, params Params) (*Blockchain, error) {
+	engine, err := NewEngine(params)
+	if err != nil {
+		return nil, err
+	}
+
+	bc := &Blockchain{
+		tip:              tip,
+		db:               db,
+		params:           params,
+		engine:           engine,
+		versionBitsCache: newVersionBitsCache(),
+		subscribers:      make(map[*Subscription]struct{}),
+	}
+	bindEngine(engine, bc)
+
+	return bc, nil
+}
+
+func (bc *Blockchain) Iterator() *BlockchainIterator {
+	// start at the tip and walk toward the oldest block
+	//
+	// Note that a valid blockchain is defined as the longest one. Therefore
+	// picking the tip is like `voting` for what we considere to be the valid
+	// blockchain, and not some (hopefully temporary) forks
+	return &BlockchainIterator{bc.Tip(), bc.db}
+}
+
+// Tip returns the hash of the latest block, nil for an empty chain
+func (bc *Blockchain) Tip() []byte {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.tip
+}
+
+// setTip moves the tip, the writer lock must be held
+func (bc *Blockchain) setTip(hash []byte) {
+	bc.mu.Lock()
+	bc.tip = hash
+	bc.mu.Unlock()
+}
+
+// Close releases the database
+func (bc *Blockchain) Close() error {
+	return bc.db.Close()
+}
+
+// AddBlock seals a block with the transactions on top of the tip, mining it
+// on a proof of work chain, and connects it like ConnectBlock does
+func (bc *Blockchain) AddBlock(transactions []*Transaction) (*Block, error) {
+	var lastHash []byte
+	var lastHeight int
+	var mtp int64
+	var version int
+	var rules Rules
+
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	// open a read-only transaction
+	err := bc.db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(BLOCKS_BUCKET))
+		// get latest block hash
+		lastHash = append([]byte{}, b.Get([]byte("l"))...)
+		lastBlock, err := getBlock(tx, lastHash)
+		if err != nil && err != ErrBlockPruned {
+			// the tip of a chain loaded from a UTXO snapshot is a header
+			return err
+		}
+		lastHeight = lastBlock.Height
+
+		if mtp, err = medianTimePast(tx, lastHash); err != nil {
+			return err
+		}
+		version, rules, err = bc.versionBits(tx, lastHash)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the rules are those of blocks received from elsewhere, minus the seal
+	// we are about to compute
+	transactions = CommitWitnesses(transactions)
+	height := lastHeight + 1
+	block := newBlock(transactions, lastHash, height, nextTimestamp(mtp))
+	block.Version = version
+	if err := bc.engine.Prepare(block); err != nil {
+		return nil, err
+	}
+	candidate := candidateBlock(transactions, lastHash, height)
+	if err := checkBlockLimits(candidate, bc.params); err != nil {
+		return nil, err
+	}
+	if err := checkTransactions(candidate, UTXOSet{bc}.FindCoin, bc.params, rules); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTransaction, err)
+	}
+
+	if err := bc.engine.Seal(block); err != nil {
+		return nil, err
+	}
+
+	return block, bc.connectBlock(block, transactions[1:])
+}
+
+// NewCoinbase creates the coinbase of the block to add on top of the tip,
+// paying its subsidy to `to`
+func (bc *Blockchain) NewCoinbase(to string) (*Transaction, error) {
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewCoinbaseTX(to, "", bestHeight+1, bc.Params().Subsidy(bestHeight+1))
+}
+
+// NewBlochain loads or initialises a blockchain stored in db, which it closes
+// on Close. The address given will receive the award of the geneis block, and
+// params are the rules of a new chain: an existing one keeps its own.
+func NewBlockchain(db storage.Store, address string, params Params) (*Blockchain, error) {
+	// tip of the blockchain
+	var tip []byte
+
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+
+	// start a read/write transaction
+	err := db.Update(func(tx storage.Tx) error {
+		// load the blocks bucket within the blockchain database
+		b := tx.Bucket([]byte(BLOCKS_BUCKET))
+
+		if b == nil {
+			// no blocks saved in this blockchain db
+			// let's initialise a new blockchain, and therefore mine the Genesis block
+			cbtx, err := NewCoinbaseTX(address, genesisCoinbaseData, 0, params.Subsidy(0))
+			if err != nil {
+				return err
+			}
+			if params.Consensus == CONSENSUS_POS {
+				// somebody has to propose the first blocks: half of the
+				// reward is staked, the rest pays for the first transactions
+				outputs := cbtx.Vout
+				stake, err := NewStakeOutput(outputs[0].Value-outputs[0].Value/2, address)
+				if err != nil {
+					return err
+				}
+				outputs[0].Value /= 2
+				if outputs[0].Value == 0 {
+					outputs = nil
+				}
+				cbtx = NewPayoutCoinbaseTX(genesisCoinbaseData, 0, append([]TXOutput{*stake}, outputs...))
+			}
+			engine, err := NewEngine(params)
+			if err != nil {
+				return err
+			}
+			genesis := newBlock([]*Transaction{cbtx}, []byte{}, 0, AdjustedTime())
+			if err := engine.Prepare(genesis); err != nil {
+				return err
+			}
+			if err := engine.Seal(genesis); err != nil {
+				return err
+			}
+
+			// initialise the DB and store our first block
+			if err := putParams(tx, params); err != nil {
+				return err
+			}
+			b, err := tx.CreateBucket([]byte(BLOCKS_BUCKET))
+			if err != nil {
+				return err
+			}
+			// store the serialized block, indexed at his hash
+			if err := b.Put(genesis.Hash, genesis.Serialize()); err != nil {
+				return err
+			}
+			// store the tip of the blockchain
+			if err := b.Put([]byte("l"), genesis.Hash); err != nil {
+				return err
+			}
+			tip = genesis.Hash
+		} else {
+			// found an existing blockchain, set the tip of it. The value
+			// returned by the store is only valid within the transaction, hence the copy
+			tip = append([]byte{}, b.Get([]byte("l"))...)
+
+			var err error
+			if params, err = getParams(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockchain(tip, db, params)
+}
+
+// OpenBlockchain loads the blockchain without creating a genesis block when
+// there is none, in which case the tip is nil and ConnectBlock expects one.
+// An empty chain has the parameters of the chains created before they were
+// saved until SetParams, or until it loads a bootstrap file or a snapshot
+// carrying its own.
+func OpenBlockchain(db storage.Store) (*Blockchain, error) {
+	var tip []byte
+	var params Params
+
+	err := db.View(func(tx storage.Tx) error {
+		if b := tx.Bucket([]byte(BLOCKS_BUCKET)); b != nil {
+			if last := b.Get([]byte("l")); last != nil {
+				tip = append([]byte{}, last...)
+			}
+		}
+
+		var err error
+		params, err = getParams(tx)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newBlockchain(tip, db, params)
+}
+
+// OpenDB opens the bolt database of the node in dataDir, created if needed
+func OpenDB(dataDir string) (storage.Store, error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dataDir, DB_FILE)
+	// bolt only allows one process to hold the file, so rather than hanging
+	// forever when a `bc serve` daemon owns it we give up quickly
+	db, err := storage.OpenBolt(path, DB_OPEN_TIMEOUT)
+	if err == storage.ErrLocked {
+		return nil, ErrDBLocked
+	}
+
+	return db, err
+}
+
+// HasBlock tells whether the block is stored in the database
+func (bc *Blockchain) HasBlock(blockHash []byte) bool {
+	found := false
+
+	_ = bc.db.View(func(tx storage.Tx) error {
+		if b := tx.Bucket([]byte(BLOCKS_BUCKET)); b != nil {
+			found = b.Get(blockHash) != nil
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// ConnectBlock validates a block received from elsewhere and appends it to
+// the tip. The block and its changes to the UTXO set are written at once, so
+// an interruption never leaves them out of sync.
+func (bc *Blockchain) ConnectBlock(block *Block) error {
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	if err := bc.checkBlock(block); err != nil {
+		return err
+	}
+
+	return bc.connectBlock(block, nil)
+}
+
+// connectBlock stores a valid block as the new tip, the writer lock must be
+// held. The transactions accepted to mine it are announced along with it.
+func (bc *Blockchain) connectBlock(block *Block, accepted []*Transaction) error {
+	err := bc.db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(BLOCKS_BUCKET))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(UTXO_BUCKET)); err != nil {
+			return err
+		}
+
+		if err := b.Put(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("l"), block.Hash); err != nil {
+			return err
+		}
+
+		return UTXOSet{bc}.update(tx, block)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.setTip(block.Hash)
+	for _, tx := range accepted {
+		bc.publish(Event{Type: TxAccepted, Tx: tx})
+	}
+	bc.publish(Event{Type: BlockConnected, Block: block})
+
+	_, err = bc.prune()
+
+	return err
+}
+
+// checkBlock makes sure the block extends our tip and follows the rules, the
+// writer lock must be held
+func (bc *Blockchain) checkBlock(block *Block) error {
+	if bc.tip == nil {
+		if block.Height != 0 || len(block.PrevBlockHash) != 0 {
+			return fmt.Errorf("block %x: chain must start with a genesis block", block.Hash)
+		}
+	} else {
+		if !bytes.Equal(block.PrevBlockHash, bc.tip) {
+			return fmt.Errorf("block %x: does not extend the tip %x", block.Hash, bc.tip)
+		}
+		bestHeight, err := bc.GetBestHeight()
+		if err != nil {
+			return err
+		}
+		if block.Height != bestHeight+1 {
+			return fmt.Errorf("block %x: wrong height %d", block.Hash, block.Height)
+		}
+	}
+
+	if err := VerifyHeader(bc.engine, block); err != nil {
+		return fmt.Errorf("block %x: %s", block.Hash, err)
+	}
+	if pos, ok := bc.engine.(*ProofOfStake); ok {
+		if err := pos.VerifyProposer(block); err != nil {
+			return fmt.Errorf("block %x: %s", block.Hash, err)
+		}
+	}
+
+	var rules Rules
+	err := bc.db.View(func(tx storage.Tx) error {
+		if err := checkMedianTimePast(tx, block); err != nil {
+			return err
+		}
+		var err error
+		_, rules, err = bc.versionBits(tx, block.PrevBlockHash)
+
+		return err
+	})
+	if err == nil {
+		err = checkFutureTime(block)
+	}
+	if err != nil {
+		return fmt.Errorf("block %x: %s", block.Hash, err)
+	}
+
+	if err := checkBlockLimits(block, bc.params); err != nil {
+		return fmt.Errorf("block %x: %w", block.Hash, err)
+	}
+	if err := checkTransactions(block, UTXOSet{bc}.FindCoin, bc.params, rules); err != nil {
+		return fmt.Errorf("block %x: %s", block.Hash, err)
+	}
+
+	return nil
+}
+
+// checkTransactions checks the transactions of a block against a view of the
+// outputs they spend and the rules of the block, and that its coinbase claims
+// no more than it is owed and commits to the witnesses
+func checkTransactions(block *Block, findCoin func(txid []byte, vout int) (Coin, bool, error), params Params, rules Rules) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction must be the coinbase")
+	}
+	if err := checkWitnessCommitment(block); err != nil {
+		return err
+	}
+
+	fees := 0
+	txids := map[string]bool{hex.EncodeToString(block.Transactions[0].ID): true}
+	for _, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase() {
+			return errors.New("more than one coinbase")
+		}
+		// the unspent outputs of the block are only known once it is connected
+		txid := hex.EncodeToString(tx.ID)
+		if txids[txid] {
+			return fmt.Errorf("transaction %x is in the block twice", tx.ID)
+		}
+		txids[txid] = true
+		fee, err := checkTransaction(tx, findCoin, block.Height, params, rules)
+		if err != nil {
+			return err
+		}
+		fees += fee
+	}
+
+	return checkCoinbase(block.Transactions[0], block.Height, params.Subsidy(block.Height)+fees)
+}
+
+// checkCoinbase makes sure the coinbase of the block at height commits to it,
+// and pays at most reward: the subsidy and the fees of the block
+func checkCoinbase(coinbase *Transaction, height, reward int) error {
+	if err := coinbase.checkID(); err != nil {
+		return err
+	}
+	if err := coinbase.checkWitness(); err != nil {
+		return err
+	}
+	coinbaseHeight, err := coinbase.CoinbaseHeight()
+	if err != nil {
+		return err
+	}
+	if coinbaseHeight != height {
+		return fmt.Errorf("coinbase is for height %d", coinbaseHeight)
+	}
+
+	paid := 0
+	for _, out := range coinbase.Vout {
+		paid += out.Value
+	}
+	if paid > reward {
+		return fmt.Errorf("coinbase pays %d but the block only earns %d", paid, reward)
+	}
+
+	return nil
+}
+
+// GetBestHeight returns the height of the latest block
+func (bc *Blockchain) GetBestHeight() (int, error) {
+	var lastBlock *Block
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+
+		lastBlock, err = getBlock(tx, bc.Tip())
+		if err == ErrBlockPruned {
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return lastBlock.Height, nil
+}
+
+// GetBlock finds a block by its hash
+// The block is returned along with ErrBlockPruned when only its header is
+// left.
+func (bc *Blockchain) GetBlock(blockHash []byte) (Block, error) {
+	var block Block
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		b, err := getBlock(tx, blockHash)
+		if b != nil {
+			block = *b
+		}
+
+		return err
+	})
+
+	return block, err
+}
+
+// getBlock loads a block within an ongoing transaction, falling back on
+// its header when it was pruned
+func getBlock(tx storage.Tx, blockHash []byte) (*Block, error) {
+	b := tx.Bucket([]byte(BLOCKS_BUCKET))
+
+	if blockData := b.Get(blockHash); blockData != nil {
+		return DeserializeBlock(blockData)
+	}
+
+	if headers := tx.Bucket([]byte(HEADERS_BUCKET)); headers != nil {
+		if headerData := headers.Get(blockHash); headerData != nil {
+			header, err := DeserializeBlockHeader(headerData)
+			if err != nil {
+				return nil, err
+			}
+
+			return header.Block(), ErrBlockPruned
+		}
+	}
+
+	return nil, errors.New("Block is not found")
+}
+
+// GetBlockHash returns the hash of the block at the given height of the
+// main chain
+func (bc *Blockchain) GetBlockHash(height int) ([]byte, error) {
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	if height < 0 || height > bestHeight {
+		return nil, errors.New("Block height out of range")
+	}
+
+	// blocks only link to their parent so we walk down from the tip
+	bci := bc.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		if block.Height == height {
+			return block.Hash, nil
+		}
+	}
+}
+
+// FindUTXO finds all unspent transaction outputs and returns transactions with spent outputs removed
+func (bc *Blockchain) FindUTXO() (map[string]TXOutputs, error) {
+	UTXO := make(map[string]TXOutputs)
+	spentTXOs := make(map[string][]int)
+	bci := bc.Iterator()
+
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		if block.IsPruned() {
+			return nil, fmt.Errorf("cannot rebuild the UTXO set, block %d: %w", block.Height, ErrBlockPruned)
+		}
+
+		for _, tx := range block.Transactions {
+			txID := hex.EncodeToString(tx.ID)
+
+		Outputs:
+			for outIdx, out := range tx.Vout {
+				// Was the output spent?
+				if spentTXOs[txID] != nil {
+					for _, spentOutIdx := range spentTXOs[txID] {
+						if spentOutIdx == outIdx {
+							continue Outputs
+						}
+					}
+				}
+
+				outs := UTXO[txID]
+				outs.Height, outs.Coinbase = block.Height, tx.IsCoinbase()
+				outs.Outputs = append(outs.Outputs, out)
+				outs.Indexes = append(outs.Indexes, outIdx)
+				UTXO[txID] = outs
+			}
+
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Vin {
+					inTxID := hex.EncodeToString(in.Txid)
+					spentTXOs[inTxID] = append(spentTXOs[inTxID], in.Vout)
+				}
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return UTXO, nil
+}
+
+// FindTransaction finds a transaction by its ID
+func (bc *Blockchain) FindTransaction(ID []byte) (Transaction, error) {
+	bci := bc.Iterator()
+
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return Transaction{}, err
+		}
+		if block.IsPruned() {
+			// blocks are pruned from the oldest, so are all the next ones
+			return Transaction{}, fmt.Errorf("Transaction %x not found, older blocks are pruned: %w", ID, ErrBlockPruned)
+		}
+
+		for _, tx := range block.Transactions {
+			if bytes.Equal(tx.ID, ID) {
+				return *tx, nil
+			}
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return Transaction{}, errors.New("Transaction not found")
+}
+
+// SignTransaction signs the inputs of the transaction, looking up the outputs
+// they spend
+func (bc *Blockchain) SignTransaction(tx *Transaction, privKey ecdsa.PrivateKey) error {
+	prevTXs, err := bc.findPrevTXs(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Sign(privKey, prevTXs)
+}
+
+func (bc *Blockchain) VerifyTransaction(tx *Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+
+	prevTXs, err := bc.findPrevTXs(tx)
+	if err != nil {
+		// spending outputs we can't find is not valid either
+		return false
+	}
+
+	return tx.Verify(prevTXs)
+}
+
+// findPrevTXs reads all the input's transaction id and fetch the
+// corresponding transaction. Unspent outputs are taken from the UTXO set,
+// which is faster and keeps working once old blocks are pruned.
+func (bc *Blockchain) findPrevTXs(tx *Transaction) (map[string]Transaction, error) {
+	prevTXs := make(map[string]Transaction)
+	var prevOuts []PrevOutput
+	UTXOSet := UTXOSet{bc}
+
+	for _, vin := range tx.Vin {
+		out, ok, err := UTXOSet.FindOutput(vin.Txid, vin.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			prevOuts = append(prevOuts, PrevOutput{vin.Txid, vin.Vout, out})
+			continue
+		}
+
+		prevTX, err := bc.FindTransaction(vin.Txid)
+		if err != nil {
+			return nil, err
+		}
+		prevTXs[hex.EncodeToString(prevTX.ID)] = prevTX
+	}
+
+	// the full transactions found in blocks already hold every output
+	for txID, prevTX := range PrevTXsFromOutputs(prevOuts) {
+		if _, ok := prevTXs[txID]; !ok {
+			prevTXs[txID] = prevTX
+		}
+	}
+
+	return prevTXs, nil
+}
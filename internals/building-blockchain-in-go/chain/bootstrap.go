@@ -0,0 +1,180 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/xav-b/blockchain/encoding"
+)
+
+// A bootstrap file holds the blocks of the main chain in height order, so a
+// new node can be seeded without copying blockchain.db (which bolt locks while
+// in use). The parameters of the chain come first, framed with PARAMS_MAGIC
+// (files written before have none), then each block is framed as:
+//
+//   magic    4 bytes   BOOTSTRAP_MAGIC
+//   length   4 bytes   big endian length of the payload
+//   checksum 4 bytes   first bytes of SHA256(SHA256(payload)), like addresses
+//   payload            the serialized block
+//
+// Blocks are validated and connected one at a time on import, so an
+// interrupted import resumes by skipping the blocks we already have.
+
+var BOOTSTRAP_MAGIC = []byte{0xf9, 0xbe, 0xb4, 0xd9}
+
+// MAX_FRAME_SIZE bounds what a corrupted length makes us allocate, like
+// bitcoind's MAX_SIZE for messages. Blocks are much smaller anyway.
+const MAX_FRAME_SIZE = 32 << 20
+
+// ExportChain writes the main chain to w, genesis first
+func ExportChain(bc *Blockchain, w io.Writer, progress func(height, bestHeight int)) error {
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return err
+	}
+	if pruneHeight > 0 {
+		return fmt.Errorf("cannot export a pruned chain, blocks below height %d are gone: %w", pruneHeight, ErrBlockPruned)
+	}
+
+	// the iterator walks from the tip, only keep the hashes to write the
+	// blocks in the other direction
+	var hashes [][]byte
+	bci := bc.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return err
+		}
+		hashes = append(hashes, block.Hash)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	if err := writeFrame(buf, PARAMS_MAGIC, bc.Params().Serialize()); err != nil {
+		return err
+	}
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := bc.GetBlock(hashes[i])
+		if err != nil {
+			return err
+		}
+
+		if err := writeFrame(buf, BOOTSTRAP_MAGIC, block.Serialize()); err != nil {
+			return err
+		}
+		progress(block.Height, len(hashes)-1)
+	}
+
+	return buf.Flush()
+}
+
+// ImportChain validates and connects the blocks read from r, updating the
+// UTXO set as it goes. It returns how many blocks were connected.
+// An empty chain takes the parameters of the file, a chain with blocks must
+// have them already. On a chain loaded from a UTXO snapshot, the blocks up to
+// the snapshot are used to verify it.
+func ImportChain(bc *Blockchain, r io.Reader, progress func(block *Block)) (int, error) {
+	buf := bufio.NewReader(r)
+	imported := 0
+	params, err := readParams(buf)
+	if err != nil {
+		return 0, err
+	}
+	if err := bc.loadParams(params); err != nil {
+		return 0, err
+	}
+	validator, err := newSnapshotValidator(bc)
+	if err != nil {
+		return 0, err
+	}
+
+	for {
+		payload, err := readFrame(buf, BOOTSTRAP_MAGIC)
+		if err == io.EOF {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("after %d blocks: %s", imported, err)
+		}
+
+		block, err := DeserializeBlock(payload)
+		if err != nil {
+			return imported, fmt.Errorf("after %d blocks: %s", imported, err)
+		}
+		if validator != nil && block.Height <= validator.snapshot.Height {
+			// the history is replayed from the genesis block every time, the
+			// UTXO set it rebuilds is not saved
+			done, err := validator.connect(block)
+			if err != nil {
+				return imported, err
+			}
+			if done {
+				validator = nil
+			}
+			imported++
+			progress(block)
+			continue
+		}
+
+		if bc.HasBlock(block.Hash) {
+			// connected by a previous, interrupted, import
+			progress(block)
+			continue
+		}
+
+		if err := bc.ConnectBlock(block); err != nil {
+			return imported, err
+		}
+		imported++
+		progress(block)
+	}
+}
+
+func writeFrame(w io.Writer, magic, payload []byte) error {
+	header := make([]byte, 12)
+	copy(header[0:4], magic)
+	binary.BigEndian.PutUint32(header[4:8], uint32(len(payload)))
+	copy(header[8:12], encoding.Checksum(payload))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+
+	return err
+}
+
+// readFrame returns io.EOF only when the file ends cleanly between two frames
+func readFrame(r io.Reader, magic []byte) ([]byte, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, fmt.Errorf("truncated frame header: %s", err)
+	}
+
+	if !bytes.Equal(header[0:4], magic) {
+		return nil, fmt.Errorf("bad magic %x, expected %x", header[0:4], magic)
+	}
+
+	length := binary.BigEndian.Uint32(header[4:8])
+	if length > MAX_FRAME_SIZE {
+		return nil, fmt.Errorf("frame of %d bytes is over the %d bytes limit", length, MAX_FRAME_SIZE)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("truncated payload: %s", err)
+	}
+
+	if !bytes.Equal(header[8:12], encoding.Checksum(payload)) {
+		return nil, fmt.Errorf("checksum mismatch, the file is corrupted")
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,153 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+)
+
+// exportChain returns the bootstrap file of bc
+func exportChain(t *testing.T, bc *Blockchain) []byte {
+	var file bytes.Buffer
+	assert.Nil(t, ExportChain(bc, &file, func(int, int) {}))
+
+	return file.Bytes()
+}
+
+// importChain imports file into bc
+func importChain(bc *Blockchain, file []byte) (int, error) {
+	return ImportChain(bc, bytes.NewReader(file), func(*Block) {})
+}
+
+func TestExportImportChain(t *testing.T) {
+	_, address := newWallets(t)
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	// a file written at height 1, and another once the chain grew
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, address, 1)})
+	assert.Nil(t, err)
+	partial := exportChain(t, bc)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, address, 2)})
+	assert.Nil(t, err)
+	full := exportChain(t, bc)
+
+	imported, err := OpenBlockchain(storage.NewMemory())
+	assert.Nil(t, err)
+	defer imported.Close()
+	n, err := importChain(imported, partial)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	// an interrupted import resumes, skipping the blocks already there
+	n, err = importChain(imported, full)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	n, err = importChain(imported, full)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	assert.Equal(t, bc.Tip(), imported.Tip())
+	assert.Equal(t, bc.Params(), imported.Params())
+	want, err := UTXOSet{bc}.Info()
+	assert.Nil(t, err)
+	got, err := UTXOSet{imported}.Info()
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	// a file written before the parameters were, follows the chain's
+	r := bufio.NewReader(bytes.NewReader(full))
+	_, err = readFrame(r, PARAMS_MAGIC)
+	assert.Nil(t, err)
+	var legacy bytes.Buffer
+	_, _ = r.WriteTo(&legacy)
+	fresh, err := OpenBlockchain(storage.NewMemory())
+	assert.Nil(t, err)
+	defer fresh.Close()
+	n, err = importChain(fresh, legacy.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, bc.Tip(), fresh.Tip())
+
+	// but blocks of a chain with other parameters are refused
+	params := DefaultParams
+	params.InitialSubsidy = 2 * INITIAL_SUBSIDY
+	other, err := NewBlockchain(storage.NewMemory(), address, params)
+	assert.Nil(t, err)
+	defer other.Close()
+	_, err = importChain(imported, exportChain(t, other))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "other parameters")
+}
+
+func TestImportCorruptedChain(t *testing.T) {
+	_, address := newWallets(t)
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, address, 1)})
+	assert.Nil(t, err)
+	file := exportChain(t, bc)
+
+	// where the frame of the last block starts
+	r := bufio.NewReader(bytes.NewReader(file))
+	params, err := readFrame(r, PARAMS_MAGIC)
+	assert.Nil(t, err)
+	genesis, err := readFrame(r, BOOTSTRAP_MAGIC)
+	assert.Nil(t, err)
+	last := 2*12 + len(params) + len(genesis)
+
+	corrupt := func(change func(file []byte) []byte) []byte {
+		return change(append([]byte{}, file...))
+	}
+	for name, test := range map[string]struct {
+		file []byte
+		err  string
+	}{
+		"checksum": {corrupt(func(f []byte) []byte {
+			f[len(f)-1] ^= 0xff
+			return f
+		}), "checksum mismatch"},
+		"magic": {corrupt(func(f []byte) []byte {
+			f[last] ^= 0xff
+			return f
+		}), "bad magic"},
+		"length": {corrupt(func(f []byte) []byte {
+			binary.BigEndian.PutUint32(f[last+4:last+8], MAX_FRAME_SIZE+1)
+			return f
+		}), "over the"},
+		"header":  {file[:last+6], "truncated frame header"},
+		"payload": {file[:len(file)-1], "truncated payload"},
+	} {
+		imported, err := OpenBlockchain(storage.NewMemory())
+		assert.Nil(t, err)
+
+		n, err := importChain(imported, test.file)
+		assert.NotNil(t, err, name)
+		if err != nil {
+			assert.Contains(t, err.Error(), test.err, name)
+			assert.Contains(t, err.Error(), "after 1 blocks", name)
+		}
+		assert.Equal(t, 1, n, "The genesis block is imported")
+		imported.Close()
+	}
+
+	// the parameters are checked too
+	corrupted := corrupt(func(f []byte) []byte {
+		f[12] ^= 0xff
+		return f
+	})
+	imported, err := OpenBlockchain(storage.NewMemory())
+	assert.Nil(t, err)
+	defer imported.Close()
+	_, err = importChain(imported, corrupted)
+	assert.NotNil(t, err)
+	assert.Nil(t, imported.Tip())
+}
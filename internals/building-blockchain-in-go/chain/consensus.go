@@ -0,0 +1,143 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/xav-b/blockchain/pow"
+)
+
+// The consensus engine decides who may add a block, and how everybody else
+// checks they were allowed to. Bitcoin relies on the proof of work, but the
+// rest of the chain does not care, like go-ethereum's consensus engines let
+// the same node run a PoW mainnet or a proof of authority test network.
+
+const (
+	// CONSENSUS_POW seals blocks with a proof of work
+	CONSENSUS_POW = "pow"
+	// CONSENSUS_POA has a fixed set of signers taking turns to sign blocks
+	CONSENSUS_POA = "poa"
+	// CONSENSUS_POS has validators drawn by stake to propose blocks
+	CONSENSUS_POS = "pos"
+)
+
+// ConsensusEngine seals new blocks and verifies the seal of the others
+type ConsensusEngine interface {
+	// Name is the consensus of Params, CONSENSUS_POW, CONSENSUS_POA or
+	// CONSENSUS_POS
+	Name() string
+	// Prepare sets the consensus fields of a new block, failing early when
+	// the node cannot seal it
+	Prepare(block *Block) error
+	// Seal finds the proof of a prepared block, and sets its hash
+	Seal(block *Block) error
+	// Hash recomputes the hash of the block from its header
+	Hash(block *Block) []byte
+	// VerifySeal checks the proof of the block hash, not the hash itself
+	VerifySeal(block *Block) error
+	// Weight is how much the block counts for the chain to be chosen over
+	// others: the best chain is the one with the most total weight, not the
+	// most blocks
+	Weight(block *Block) *big.Int
+}
+
+// Authorizer is an engine signing blocks, with the keys it is given
+type Authorizer interface {
+	// Authorize lets the engine sign with the key, returning false when the
+	// key may not sign any block
+	Authorize(key ecdsa.PrivateKey) bool
+}
+
+// NewEngine returns the consensus engine of the parameters
+func NewEngine(params Params) (ConsensusEngine, error) {
+	switch params.Consensus {
+	case CONSENSUS_POW:
+		function, err := params.PowFunction()
+		if err != nil {
+			return nil, err
+		}
+
+		return ProofOfWork{function}, nil
+	case CONSENSUS_POA:
+		return NewProofOfAuthority(params.Signers)
+	case CONSENSUS_POS:
+		return NewProofOfStake(), nil
+	}
+
+	return nil, fmt.Errorf("unknown consensus %q", params.Consensus)
+}
+
+// VerifyHeader makes sure the block hashes to its hash, and that the hash is
+// sealed
+func VerifyHeader(engine ConsensusEngine, block *Block) error {
+	if !bytes.Equal(engine.Hash(block), block.Hash) {
+		return errors.New("header does not hash to the block hash")
+	}
+
+	return engine.VerifySeal(block)
+}
+
+// ProofOfWork is the Hashcash-like proof of work of the pow package, with
+// the hash function and difficulty of the chain
+type ProofOfWork struct {
+	Function pow.Function
+}
+
+func (ProofOfWork) Name() string {
+	return CONSENSUS_POW
+}
+
+// Prepare has nothing to set, any node can mine
+func (ProofOfWork) Prepare(block *Block) error {
+	block.Nonce = 0
+
+	return nil
+}
+
+func (p ProofOfWork) Seal(block *Block) error {
+	mineBlock(block, p.Function, pow.MAX_NONCE)
+
+	return nil
+}
+
+func (p ProofOfWork) Hash(block *Block) []byte {
+	return block.ProofOfWork(p.Function).Hash()
+}
+
+func (p ProofOfWork) VerifySeal(block *Block) error {
+	if !p.Function.MeetsTarget(block.Hash) {
+		return errors.New("invalid proof of work, hash is above the target")
+	}
+
+	return nil
+}
+
+// Weight is the number of hashes it takes on average to mine the block
+func (p ProofOfWork) Weight(block *Block) *big.Int {
+	return p.Function.Work()
+}
+
+// ChainWork returns the total weight of the chain, from the genesis block to
+// the tip
+func (bc *Blockchain) ChainWork() (*big.Int, error) {
+	engine := bc.Engine()
+	work := new(big.Int)
+
+	bci := bc.Iterator()
+	for bc.Tip() != nil {
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		work.Add(work, engine.Weight(block))
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return work, nil
+}
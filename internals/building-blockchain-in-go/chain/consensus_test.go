@@ -0,0 +1,191 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+func TestProofOfAuthority(t *testing.T) {
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+
+	params := DefaultParams
+	params.Consensus = CONSENSUS_POA
+	params.Signers = []string{alice, bob}
+	bc, err := NewBlockchain(storage.NewMemory(), alice, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	poa := bc.Engine().(*ProofOfAuthority)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+	assert.True(t, poa.Authorize(aliceWallet.PrivateKey))
+
+	// block 1 is bob's turn
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 1)})
+	assert.True(t, errors.Is(err, ErrNotAuthorized))
+
+	bobWallet, err := wallets.GetWallet(bob)
+	assert.Nil(t, err)
+	assert.True(t, poa.Authorize(bobWallet.PrivateKey))
+	for height := 1; height <= 4; height++ {
+		block, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, height)})
+		assert.Nil(t, err)
+		assert.Nil(t, VerifyHeader(poa, block))
+	}
+
+	// only the signer in turn may sign, and nobody can mine instead
+	aliceOnly, err := NewProofOfAuthority([]string{alice})
+	assert.Nil(t, err)
+	aliceOnly.Authorize(aliceWallet.PrivateKey)
+	outOfTurn := newBlock([]*Transaction{newCoinbase(t, alice, 5)}, bc.Tip(), 5, AdjustedTime())
+	assert.Nil(t, aliceOnly.Prepare(outOfTurn))
+	assert.Nil(t, aliceOnly.Seal(outOfTurn))
+	assert.NotNil(t, bc.ConnectBlock(outOfTurn), "Block 5 is bob's")
+	mined := MineBlock(pow.Default, BLOCK_VERSION, []*Transaction{newCoinbase(t, alice, 5)}, bc.Tip(), 5, AdjustedTime())
+	assert.NotNil(t, bc.ConnectBlock(mined), "No signature")
+
+	work, err := bc.ChainWork()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), work.Int64(), "Every block weighs the same")
+
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
+
+func TestProofOfStake(t *testing.T) {
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+	bobWallet, err := wallets.GetWallet(bob)
+	assert.Nil(t, err)
+
+	params := DefaultParams
+	params.Consensus = CONSENSUS_POS
+	params.StakeLock = 2
+	bc, err := NewBlockchain(storage.NewMemory(), alice, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+
+	pos := bc.Engine().(*ProofOfStake)
+	pos.Authorize(aliceWallet.PrivateKey)
+	pos.Authorize(bobWallet.PrivateKey)
+	stakes, err := utxo.Stakes()
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY/2, stakes.Total(), "Half of the genesis coinbase is staked")
+
+	// bob gets coins from alice, and stakes them
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 1)})
+	assert.Nil(t, err)
+	tx, err := NewUTXOTransaction(aliceWallet, bob, 5, &utxo)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 2), tx})
+	assert.Nil(t, err)
+	stakeTx, err := NewStakeTX(bobWallet, 5, &utxo)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 3), stakeTx})
+	assert.Nil(t, err)
+	stakes, err = utxo.Stakes()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(stakes))
+
+	_, err = NewUnstakeTX(bobWallet, &utxo)
+	assert.NotNil(t, err, "The stake is locked")
+
+	// only the drawn proposer may sign the block
+	block := newBlock([]*Transaction{newCoinbase(t, alice, 4)}, bc.Tip(), 4, AdjustedTime())
+	proposer, err := pos.Proposer(block)
+	assert.Nil(t, err)
+	other := aliceWallet
+	if proposer == alice {
+		other = bobWallet
+	}
+	block.Signer = encodePoint(other.PrivateKey.PublicKey.X, other.PrivateKey.PublicKey.Y)
+	assert.Nil(t, pos.Seal(block))
+	assert.Nil(t, VerifyHeader(pos, block))
+	assert.NotNil(t, bc.ConnectBlock(block), "Not the proposer")
+	for height := 4; height <= 6; height++ {
+		block, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, height)})
+		assert.Nil(t, err)
+		assert.Nil(t, pos.VerifyProposer(&Block{PrevBlockHash: block.PrevBlockHash, Height: height, Signer: block.Signer}))
+	}
+
+	// bob signs two blocks at height 7
+	var headers []BlockHeader
+	for i := 0; i < 2; i++ {
+		block := newBlock([]*Transaction{newCoinbase(t, bob, 7)}, bc.Tip(), 7, AdjustedTime())
+		block.Signer = encodePoint(bobWallet.PrivateKey.PublicKey.X, bobWallet.PrivateKey.PublicKey.Y)
+		assert.Nil(t, pos.Seal(block))
+		headers = append(headers, NewBlockHeader(block))
+	}
+	_, err = Equivocation{headers[0], headers[0]}.Verify()
+	assert.NotNil(t, err, "A single block is no equivocation")
+	slashed, err := utxo.Slash(Equivocation{headers[0], headers[1]}, alice)
+	assert.Nil(t, err)
+	stakes, err = utxo.Stakes()
+	assert.Nil(t, err)
+	assert.Equal(t, Stakes{{wallet.HashPubKey(aliceWallet.PublicKey), INITIAL_SUBSIDY / 2}}, stakes, "Bob's stake is burned")
+	supply, err := bc.Supply()
+	assert.Nil(t, err)
+	assert.Equal(t, supply.Issued-5, supply.Unspent)
+
+	// a reorg gives it back
+	_, err = bc.DisconnectTip()
+	assert.Nil(t, err)
+	stakes, err = utxo.Stakes()
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY/2+5, stakes.Total())
+	assert.Nil(t, bc.ConnectBlock(slashed))
+
+	// alice takes her stake back, leaving nobody to propose blocks
+	unstakeTx, err := NewUnstakeTX(aliceWallet, &utxo)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 8), unstakeTx})
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 9)})
+	assert.NotNil(t, err)
+
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
+
+func TestMemoryHardProofOfWork(t *testing.T) {
+	_, alice := newWallets(t)
+
+	params := DefaultParams
+	params.PowHash = pow.HASH_SCRYPT
+	params.PowBits = 4
+	bc, err := NewBlockchain(storage.NewMemory(), alice, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	block, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, 1)})
+	assert.Nil(t, err)
+	assert.True(t, block.ProofOfWork(bc.Engine().(ProofOfWork).Function).Validate())
+
+	// a SHA-256 block does not hash to its hash with scrypt
+	mined := MineBlock(pow.Default, BLOCK_VERSION, []*Transaction{newCoinbase(t, alice, 2)}, bc.Tip(), 2, AdjustedTime())
+	assert.NotNil(t, bc.ConnectBlock(mined))
+
+	work, err := bc.ChainWork()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2*16), work.Int64(), "Blocks of 4 bits")
+
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+
+	params.PowHash = "md5"
+	assert.NotNil(t, params.Validate())
+}
@@ -0,0 +1,137 @@
+package chain
+
+import "sync"
+
+// Rather than polling the tip, the wallet, indexers or the miner subscribe to
+// the changes of the chain, like bitcoind's validation interface (or its ZMQ
+// notifications). Events are queued per subscriber, in the order they
+// happened, so a slow one neither blocks the chain nor misses any.
+
+// EventType tells what happened to the chain
+type EventType int
+
+const (
+	// BlockConnected is sent once a block is the new tip and the UTXO set
+	// is updated
+	BlockConnected EventType = iota
+	// BlockDisconnected is sent once the tip was removed from the main chain
+	BlockDisconnected
+	// TxAccepted is sent when a transaction is mined by the node. There is
+	// no mempool so it is sent once its block is connected, right before the
+	// BlockConnected.
+	TxAccepted
+)
+
+func (t EventType) String() string {
+	switch t {
+	case BlockConnected:
+		return "BlockConnected"
+	case BlockDisconnected:
+		return "BlockDisconnected"
+	case TxAccepted:
+		return "TxAccepted"
+	}
+
+	return "Unknown"
+}
+
+// Event is a change of the chain. Block is set for the block events, Tx for
+// TxAccepted.
+type Event struct {
+	Type  EventType
+	Block *Block
+	Tx    *Transaction
+}
+
+// Subscription delivers the events of the chain on C, from the time it was
+// created until Unsubscribe
+type Subscription struct {
+	C <-chan Event
+
+	bc     *Blockchain
+	mu     sync.Mutex
+	queue  []Event
+	notify chan struct{}
+	done   chan struct{}
+}
+
+// Subscribe returns a new subscription to the events of the chain. It must be
+// ended with Unsubscribe.
+func (bc *Blockchain) Subscribe() *Subscription {
+	c := make(chan Event)
+	s := &Subscription{
+		C:      c,
+		bc:     bc,
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+
+	bc.subscribersMu.Lock()
+	bc.subscribers[s] = struct{}{}
+	bc.subscribersMu.Unlock()
+
+	go s.deliver(c)
+
+	return s
+}
+
+// Unsubscribe stops the delivery of events and closes C. Events still queued
+// are dropped.
+func (s *Subscription) Unsubscribe() {
+	s.bc.subscribersMu.Lock()
+	defer s.bc.subscribersMu.Unlock()
+
+	if _, ok := s.bc.subscribers[s]; ok {
+		delete(s.bc.subscribers, s)
+		close(s.done)
+	}
+}
+
+// push queues an event without waiting for the subscriber
+func (s *Subscription) push(event Event) {
+	s.mu.Lock()
+	s.queue = append(s.queue, event)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+		// already notified
+	}
+}
+
+// deliver sends the queued events on c until unsubscribed
+func (s *Subscription) deliver(c chan<- Event) {
+	defer close(c)
+
+	for {
+		s.mu.Lock()
+		queue := s.queue
+		s.queue = nil
+		s.mu.Unlock()
+
+		for _, event := range queue {
+			select {
+			case c <- event:
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case <-s.notify:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// publish sends an event to every subscriber
+func (bc *Blockchain) publish(event Event) {
+	bc.subscribersMu.Lock()
+	defer bc.subscribersMu.Unlock()
+
+	for s := range bc.subscribers {
+		s.push(event)
+	}
+}
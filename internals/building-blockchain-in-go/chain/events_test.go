@@ -0,0 +1,61 @@
+package chain
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestSubscribe(t *testing.T) {
+	wallets, address := newWallets(t)
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	events := bc.Subscribe()
+
+	// readers keep going while blocks are added
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for j := 0; j < 20; j++ {
+				_, err := bc.GetBestHeight()
+				assert.Nil(t, err)
+			}
+		}()
+	}
+
+	sender, _ := wallets.GetWallet(address)
+	tx, err := NewUTXOTransaction(sender, address, 3, &UTXOSet{bc})
+	assert.Nil(t, err)
+	// nothing is announced of a block failing to connect
+	doubleSpend, err := NewUTXOTransaction(sender, address, 4, &UTXOSet{bc})
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, address, 1), tx, doubleSpend})
+	assert.NotNil(t, err)
+	block, err := bc.AddBlock([]*Transaction{newCoinbase(t, address, 1), tx})
+	assert.Nil(t, err)
+	_, err = bc.DisconnectTip()
+	assert.Nil(t, err)
+	readers.Wait()
+
+	accepted := <-events.C
+	assert.Equal(t, TxAccepted, accepted.Type)
+	assert.Equal(t, tx.ID, accepted.Tx.ID)
+	connected := <-events.C
+	assert.Equal(t, Event{Type: BlockConnected, Block: block}, connected)
+	disconnected := <-events.C
+	assert.Equal(t, BlockDisconnected, disconnected.Type)
+	assert.Equal(t, block.Hash, disconnected.Block.Hash)
+
+	events.Unsubscribe()
+	_, ok := <-events.C
+	assert.False(t, ok, "Channel is closed once unsubscribed")
+	events.Unsubscribe()
+}
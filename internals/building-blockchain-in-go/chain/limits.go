@@ -0,0 +1,109 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+
+	"github.com/xav-b/blockchain/encoding"
+)
+
+// Without limits a single block could hold as many transactions as a miner
+// cares to put in it, and every node would have to decode and verify them
+// all. Like Bitcoin, blocks are therefore capped by their serialized size and
+// by the number of signature operations (sigops) they require, the most
+// expensive part of the verification. Each input is checked against one
+// signature, so it costs one sigop, and the coinbase none.
+//
+// We also cap the number of transactions, which bounds the merkle tree and
+// the per transaction work independently of their size.
+
+const (
+	// Bitcoin's limit before segwit
+	MAX_BLOCK_SIZE = 1000000
+	// Bitcoin has MAX_BLOCK_SIZE / 50
+	MAX_BLOCK_SIGOPS = 20000
+	// a transaction takes at least a couple hundred bytes
+	MAX_BLOCK_TXS = 5000
+)
+
+// BlockLimitError reports a block going over one of the limits of the chain
+type BlockLimitError struct {
+	// "size", "sigops" or "transactions"
+	Limit string
+	Value int
+	Max   int
+}
+
+func (e *BlockLimitError) Error() string {
+	return fmt.Sprintf("block exceeds the %s limit: %d > %d", e.Limit, e.Value, e.Max)
+}
+
+// Size returns the size of the serialized transaction
+func (tx Transaction) Size() int {
+	return len(tx.Serialize())
+}
+
+// SigOps returns the number of signature checks the transaction requires
+func (tx Transaction) SigOps() int {
+	if tx.IsCoinbase() {
+		return 0
+	}
+
+	return len(tx.Vin)
+}
+
+// Size returns the size of the serialized block
+func (b *Block) Size() int {
+	return len(b.Serialize())
+}
+
+// SigOps returns the number of signature checks the block requires
+func (b *Block) SigOps() int {
+	sigOps := 0
+	for _, tx := range b.Transactions {
+		sigOps += tx.SigOps()
+	}
+
+	return sigOps
+}
+
+// checkBlockLimits makes sure the block stays within the limits of params
+func checkBlockLimits(block *Block, params Params) error {
+	if n := len(block.Transactions); n > params.MaxBlockTxs {
+		return &BlockLimitError{"transactions", n, params.MaxBlockTxs}
+	}
+	if sigOps := block.SigOps(); sigOps > params.MaxBlockSigOps {
+		return &BlockLimitError{"sigops", sigOps, params.MaxBlockSigOps}
+	}
+	if size := block.Size(); size > params.MaxBlockSize {
+		return &BlockLimitError{"size", size, params.MaxBlockSize}
+	}
+
+	return nil
+}
+
+// candidateBlock is the block about to be mined with the transactions, as
+// large as it can get: the timestamp, hash, nonce, extra nonce and signature
+// it will get once sealed are not known yet, so they take their largest
+// encoding
+func candidateBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
+	if len(transactions) > 0 {
+		if _, err := transactions[0].CoinbaseHeight(); err == nil {
+			coinbase := transactions[0].WithExtraNonce(encoding.IntToHex(math.MaxInt64))
+			transactions = append([]*Transaction{coinbase}, transactions[1:]...)
+		}
+	}
+
+	return &Block{
+		Version:       BLOCK_VERSION,
+		Timestamp:     math.MaxInt64,
+		Transactions:  transactions,
+		PrevBlockHash: prevBlockHash,
+		Hash:          make([]byte, sha256.Size),
+		Nonce:         math.MaxInt64,
+		Height:        height,
+		Signer:        make([]byte, 2*POINT_SIZE),
+		Signature:     make([]byte, 2*POINT_SIZE),
+	}
+}
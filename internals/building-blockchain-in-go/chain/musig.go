@@ -0,0 +1,197 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/schnorr"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// An input whose public key is x-only, PUBKEY_SIZE bytes, is unlocked by a
+// Schnorr signature instead of an ECDSA one. Its output may be locked by the
+// key of a single wallet, or by the aggregate of the keys of several: spending
+// it then takes all of them, but only one signature, with MuSig2. See the
+// schnorr package.
+//
+// A MuSigSession carries what the signers need to agree on: the transaction,
+// the input they sign and their keys. Each signer then shares a public nonce,
+// and once they have all the nonces, a partial signature. Any of them can
+// then combine the partial signatures into the signature of the input.
+
+// AggregateAddress returns the address of the output locked by the aggregate
+// of the x-only keys, in that order
+func AggregateAddress(pubKeys [][]byte) (string, error) {
+	aggregate, err := schnorr.AggregateKeys(pubKeys)
+	if err != nil {
+		return "", err
+	}
+
+	return wallet.PubKeyHashToAddress(wallet.HashPubKey(aggregate)), nil
+}
+
+// SignInputSchnorr signs input inID with the x-only key of privKey, like
+// SignInput does with ECDSA
+func (tx *Transaction) SignInputSchnorr(inID int, privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, hashType SigHashType) error {
+	vin := tx.Vin[inID]
+
+	prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+	if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+		return ErrMissingPrevTx
+	}
+	hash, err := tx.SignatureHash(inID, prevTx.Vout[vin.Vout].PubKeyHash, hashType)
+	if err != nil {
+		return err
+	}
+
+	signature, err := schnorr.Sign(&privKey, hash)
+	if err != nil {
+		return err
+	}
+	tx.unlockInput(inID, append(signature, byte(hashType)), schnorr.PublicKey(&privKey))
+
+	return nil
+}
+
+// unlockInput sets the signature and public key of input inID, in the
+// witness if the transaction has one
+func (tx *Transaction) unlockInput(inID int, signature, pubKey []byte) {
+	if tx.HasWitness() {
+		tx.Witness[inID] = TXWitness{signature, pubKey}
+	} else {
+		tx.Vin[inID].Signature = signature
+		tx.Vin[inID].PubKey = pubKey
+	}
+}
+
+// MuSigSession is the signature of an input by the keys aggregated in the
+// one its output is locked by
+type MuSigSession struct {
+	// Tx is the transaction to sign, it is never modified
+	Tx         Transaction
+	Input      int
+	PrevOutput TXOutput
+	// PubKeys are the x-only keys of the signers, in the order of their
+	// aggregation
+	PubKeys  [][]byte
+	HashType SigHashType
+}
+
+// NewMuSigSession starts the signature of input inID of tx by the signers of
+// the keys, making sure the output it spends is locked by their aggregate
+func NewMuSigSession(tx *Transaction, inID int, prevTXs map[string]Transaction, pubKeys [][]byte, hashType SigHashType) (*MuSigSession, error) {
+	if inID < 0 || inID >= len(tx.Vin) {
+		return nil, fmt.Errorf("transaction %x has no input %d", tx.ID, inID)
+	}
+	if !hashType.Valid() {
+		return nil, fmt.Errorf("unknown sighash type %#x", byte(hashType))
+	}
+	vin := tx.Vin[inID]
+	prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+	if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+		return nil, fmt.Errorf("previous output %s is missing", Outpoint(vin.Txid, vin.Vout))
+	}
+	prevOutput := prevTx.Vout[vin.Vout]
+
+	address, err := AggregateAddress(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+	if address != wallet.PubKeyHashToAddress(prevOutput.PubKeyHash) {
+		return nil, fmt.Errorf("output %s is not locked by the aggregate key %s", Outpoint(vin.Txid, vin.Vout), address)
+	}
+
+	session := &MuSigSession{*tx, inID, prevOutput, pubKeys, hashType}
+	if _, err := session.Message(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Message returns the signature hash of the input, what the signers sign
+func (s *MuSigSession) Message() ([]byte, error) {
+	return s.Tx.SignatureHash(s.Input, s.PrevOutput.PubKeyHash, s.HashType)
+}
+
+// PartialSign returns the share of the signature of the signer holding
+// privKey, out of its secret nonce and the public nonces of all the signers,
+// in the order of their keys
+func (s *MuSigSession) PartialSign(privKey ecdsa.PrivateKey, secNonce []byte, pubNonces [][]byte) ([]byte, error) {
+	msg, err := s.Message()
+	if err != nil {
+		return nil, err
+	}
+
+	return schnorr.PartialSign(&privKey, secNonce, s.PubKeys, pubNonces, msg)
+}
+
+// Finalize combines the partial signatures of all the signers into the
+// signature of the input, and returns the transaction with it
+func (s *MuSigSession) Finalize(pubNonces, partialSigs [][]byte) (*Transaction, error) {
+	msg, err := s.Message()
+	if err != nil {
+		return nil, err
+	}
+	signature, err := schnorr.AggregateSignatures(partialSigs, s.PubKeys, pubNonces, msg)
+	if err != nil {
+		return nil, err
+	}
+	aggregate, err := schnorr.AggregateKeys(s.PubKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := s.Tx
+	tx.Vin = append([]TXInput{}, s.Tx.Vin...)
+	tx.Witness = append([]TXWitness{}, s.Tx.Witness...)
+	tx.unlockInput(s.Input, append(signature, byte(s.HashType)), aggregate)
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// Serialize serializes the session
+func (s *MuSigSession) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	enc := gob.NewEncoder(&encoded)
+	// encoding to memory only fails on unsupported types
+	if err := enc.Encode(s); err != nil {
+		panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// EncodeMuSigSession returns the base64 encoding of the session
+func EncodeMuSigSession(s *MuSigSession) string {
+	return base64.StdEncoding.EncodeToString(s.Serialize())
+}
+
+// DecodeMuSigSession decodes the base64 encoding of a session
+func DecodeMuSigSession(encoded string) (*MuSigSession, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MuSig session: %s", err)
+	}
+
+	var session MuSigSession
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&session); err != nil {
+		return nil, fmt.Errorf("invalid MuSig session: %s", err)
+	}
+	if session.Input < 0 || session.Input >= len(session.Tx.Vin) {
+		return nil, errors.New("invalid MuSig session: no such input")
+	}
+	if err := session.Tx.checkWitness(); err != nil {
+		return nil, fmt.Errorf("invalid MuSig session: %s", err)
+	}
+
+	return &session, nil
+}
@@ -0,0 +1,105 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/schnorr"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+func TestMuSig(t *testing.T) {
+	wallets, alice := newWallets(t)
+	var signers []*wallet.Wallet
+	var pubKeys [][]byte
+	for i := 0; i < 3; i++ {
+		address, err := wallets.CreateWallet()
+		assert.Nil(t, err)
+		w, err := wallets.GetWallet(address)
+		assert.Nil(t, err)
+		signers = append(signers, w)
+		pubKeys = append(pubKeys, schnorr.PublicKey(&w.PrivateKey))
+	}
+	aggregate, err := schnorr.AggregateKeys(pubKeys)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+
+	funding := NewPayoutCoinbaseTX("", 1, []TXOutput{{INITIAL_SUBSIDY, wallet.HashPubKey(aggregate)}})
+	_, err = bc.AddBlock([]*Transaction{funding})
+	assert.Nil(t, err)
+	prevTXs := PrevTXsFromOutputs([]PrevOutput{{funding.ID, 0, funding.Vout[0]}})
+
+	// the three signers spend the output of their aggregate key together
+	tx := NewRawTransaction([]TXInput{{funding.ID, 0, nil, nil}}, []TXOutput{{INITIAL_SUBSIDY, wallet.HashPubKey(pubKeys[0])}})
+	tx.Witness = make([]TXWitness, 1)
+	session, err := NewMuSigSession(tx, 0, prevTXs, pubKeys, SIGHASH_ALL)
+	assert.Nil(t, err)
+	session, err = DecodeMuSigSession(EncodeMuSigSession(session))
+	assert.Nil(t, err)
+
+	var secNonces, pubNonces, partialSigs [][]byte
+	for range signers {
+		secNonce, pubNonce, err := schnorr.NewNonce()
+		assert.Nil(t, err)
+		secNonces = append(secNonces, secNonce)
+		pubNonces = append(pubNonces, pubNonce)
+	}
+	for i, signer := range signers {
+		partialSig, err := session.PartialSign(signer.PrivateKey, secNonces[i], pubNonces)
+		assert.Nil(t, err)
+		partialSigs = append(partialSigs, partialSig)
+	}
+
+	// a signer cannot sign for another
+	_, err = session.PartialSign(signers[0].PrivateKey, secNonces[1], pubNonces)
+	assert.NotNil(t, err)
+
+	// a missing share leaves the signature incomplete
+	_, err = session.Finalize(pubNonces, partialSigs[:2])
+	assert.NotNil(t, err)
+
+	signed, err := session.Finalize(pubNonces, partialSigs)
+	assert.Nil(t, err)
+	assert.Nil(t, tx.Witness[0].Signature, "Session transaction is left untouched")
+	assert.True(t, signed.Verify(prevTXs))
+	assert.Nil(t, utxo.CheckTransaction(signed))
+
+	// two of the keys do not lock the output
+	_, err = NewMuSigSession(tx, 0, prevTXs, pubKeys[:2], SIGHASH_ALL)
+	assert.NotNil(t, err)
+
+	// changing the outputs breaks the aggregate signature
+	tampered := *signed
+	tampered.Vout = []TXOutput{newOutput(t, INITIAL_SUBSIDY, alice)}
+	assert.False(t, tampered.Verify(prevTXs))
+
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 2), signed})
+	assert.Nil(t, err)
+
+	// a single wallet spends its x-only key alone
+	prevOuts := []PrevOutput{{signed.ID, 0, signed.Vout[0]}}
+	prevTXs = PrevTXsFromOutputs(prevOuts)
+	spend := NewRawTransaction([]TXInput{{signed.ID, 0, nil, nil}}, []TXOutput{newOutput(t, INITIAL_SUBSIDY, alice)})
+	assert.Nil(t, spend.SignInputSchnorr(0, signers[1].PrivateKey, prevTXs, SIGHASH_ALL))
+	assert.NotNil(t, checkPubKeyHashes(spend, prevOuts), "Key does not match the output")
+	assert.Nil(t, spend.SignInputSchnorr(0, signers[0].PrivateKey, prevTXs, SIGHASH_ALL))
+	spend.ID = spend.Hash()
+	assert.Nil(t, utxo.CheckTransaction(spend))
+
+	// an x-only key never takes an ECDSA signature
+	ecdsaSpend := NewRawTransaction([]TXInput{{signed.ID, 0, nil, nil}}, []TXOutput{newOutput(t, INITIAL_SUBSIDY, alice)})
+	assert.Nil(t, ecdsaSpend.SignInput(0, signers[0].PrivateKey, prevTXs, SIGHASH_ALL))
+	ecdsaSpend.Vin[0].PubKey = pubKeys[0]
+	assert.False(t, ecdsaSpend.Verify(prevTXs))
+
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 3), spend})
+	assert.Nil(t, err)
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
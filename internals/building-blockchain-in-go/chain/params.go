@@ -0,0 +1,347 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+)
+
+// Like Bitcoin, new coins are created by the coinbase of every block, and the
+// amount (the subsidy) halves every HALVING_INTERVAL blocks until it rounds
+// down to nothing. The total supply is therefore capped, at about twice the
+// coins created before the first halving.
+//
+// The schedule is part of the consensus rules, so it is chosen when the chain
+// is created and saved along with it, like bitcoind's chain parameters differ
+// between mainnet and regtest.
+//
+// So is the coinbase maturity: the number of blocks that must be mined on top
+// of a coinbase before its outputs can be spent. Bitcoin uses 100, so that a
+// reorg making a coinbase vanish does not take its spends along. It is 0 by
+// default here as blocks are only mined when sending coins, which a new chain
+// with a maturity of 100 would never manage to do.
+//
+// The consensus engine sealing the blocks is a parameter too, with the
+// signers of a proof of authority and the lock of the stakes of a proof of
+// stake, see consensus.go. A proof of work picks its hash function and
+// difficulty: a memory-hard function is much slower, and needs fewer bits for
+// blocks to come as often.
+//
+// The soft forks rolled out with version bits and the window their state is
+// evaluated on are parameters as well, see versionbits.go.
+
+const (
+	INITIAL_SUBSIDY = 10
+	// Bitcoin halves every 210,000 blocks, about 4 years
+	HALVING_INTERVAL = 210000
+	// Bitcoin requires 100 blocks
+	COINBASE_MATURITY = 0
+	// blocks before a validator can take its stake back, leaving time to
+	// slash it
+	STAKE_LOCK = 10
+)
+
+// Params are the consensus parameters of a chain
+type Params struct {
+	// subsidy of the blocks before the first halving
+	InitialSubsidy int
+	// number of blocks between two halvings
+	HalvingInterval int
+	// number of blocks between a coinbase and the first block spending it
+	CoinbaseMaturity int
+	// limits of a block, see limits.go
+	MaxBlockSize   int
+	MaxBlockSigOps int
+	MaxBlockTxs    int
+	// engine sealing the blocks, CONSENSUS_POW, CONSENSUS_POA or
+	// CONSENSUS_POS
+	Consensus string
+	// addresses of the signers of a proof of authority, in turn order
+	Signers []string
+	// number of blocks between a stake output and the first block spending
+	// it, but to slash it
+	StakeLock int
+	// hash function of the proof of work, see pow.HashFunctions, and leading
+	// zero bits of the block hashes
+	PowHash string
+	PowBits int
+	// soft forks signalled with version bits, and the number of blocks
+	// between two changes of their state
+	Deployments      []Deployment
+	DeploymentWindow int
+}
+
+// DefaultParams are used by chains created without parameters
+var DefaultParams = Params{
+	InitialSubsidy:   INITIAL_SUBSIDY,
+	HalvingInterval:  HALVING_INTERVAL,
+	CoinbaseMaturity: COINBASE_MATURITY,
+	MaxBlockSize:     MAX_BLOCK_SIZE,
+	MaxBlockSigOps:   MAX_BLOCK_SIGOPS,
+	MaxBlockTxs:      MAX_BLOCK_TXS,
+	Consensus:        CONSENSUS_POW,
+	StakeLock:        STAKE_LOCK,
+	PowHash:          pow.HASH_SHA256,
+	PowBits:          pow.TargetBits,
+	Deployments:      DefaultDeployments,
+	DeploymentWindow: DEPLOYMENT_WINDOW,
+}
+
+// Validate makes sure the parameters make a working chain
+func (p Params) Validate() error {
+	if p.InitialSubsidy <= 0 {
+		return errors.New("the initial subsidy must be positive")
+	}
+	if p.HalvingInterval <= 0 {
+		return errors.New("the halving interval must be positive")
+	}
+	if p.CoinbaseMaturity < 0 {
+		return errors.New("the coinbase maturity cannot be negative")
+	}
+	if p.StakeLock < 0 {
+		return errors.New("the stake lock cannot be negative")
+	}
+	if p.MaxBlockSize <= 0 || p.MaxBlockSigOps <= 0 || p.MaxBlockTxs <= 0 {
+		return errors.New("the block limits must be positive")
+	}
+	if err := validateDeployments(p.Deployments, p.DeploymentWindow); err != nil {
+		return err
+	}
+	if _, err := NewEngine(p); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PowFunction returns the proof of work of the parameters, whatever the
+// consensus
+func (p Params) PowFunction() (pow.Function, error) {
+	return pow.NewFunction(p.PowHash, p.PowBits)
+}
+
+// Subsidy returns the coins created by the block at the given height
+func (p Params) Subsidy(height int) int {
+	halvings := height / p.HalvingInterval
+
+	// integer division, the subsidy ends up rounded down to 0
+	return p.InitialSubsidy >> uint(halvings)
+}
+
+// IssuedAt returns the coins created by the blocks up to height, included
+func (p Params) IssuedAt(height int) int {
+	issued := 0
+
+	for start := 0; start <= height; start += p.HalvingInterval {
+		subsidy := p.Subsidy(start)
+		if subsidy == 0 {
+			break
+		}
+
+		blocks := p.HalvingInterval
+		if height-start+1 < blocks {
+			blocks = height - start + 1
+		}
+		issued += blocks * subsidy
+	}
+
+	return issued
+}
+
+// MaxSupply returns the coins there will ever be, once the subsidy is 0
+func (p Params) MaxSupply() int {
+	supply := 0
+
+	for halvings := 0; p.Subsidy(halvings*p.HalvingInterval) > 0; halvings++ {
+		supply += p.HalvingInterval * p.Subsidy(halvings*p.HalvingInterval)
+	}
+
+	return supply
+}
+
+// putParams saves the parameters of a new chain
+func putParams(tx storage.Tx, params Params) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(META_BUCKET))
+	if err != nil {
+		return err
+	}
+
+	return b.Put([]byte("params"), params.Serialize())
+}
+
+// getParams loads the parameters of the chain
+func getParams(tx storage.Tx) (Params, error) {
+	var data []byte
+	if b := tx.Bucket([]byte(META_BUCKET)); b != nil {
+		data = b.Get([]byte("params"))
+	}
+
+	return DeserializeParams(data)
+}
+
+// Serialize serializes the parameters
+func (p Params) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	// encoding to memory only fails on unsupported types
+	if err := gob.NewEncoder(&encoded).Encode(p); err != nil {
+		panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// DeserializeParams decodes parameters, the default ones for chains created
+// before they were saved (no data). Parameters added since keep their
+// default, but for the deployments: a chain saved without them rolls them
+// out.
+func DeserializeParams(data []byte) (Params, error) {
+	params := DefaultParams
+	// a saved window is positive, a zero one was never saved
+	params.Deployments = nil
+	params.DeploymentWindow = 0
+
+	if data != nil {
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&params); err != nil {
+			return params, err
+		}
+	}
+	if params.DeploymentWindow == 0 {
+		params.Deployments = append([]Deployment{}, UpgradeDeployments...)
+		params.DeploymentWindow = DEPLOYMENT_WINDOW
+	}
+
+	return params, nil
+}
+
+// PARAMS_MAGIC frames the parameters of the chain at the start of bootstrap
+// files and snapshots, for the node loading them to follow the same rules.
+// Those written before start right away with a block or the summary of the
+// UTXO set.
+var PARAMS_MAGIC = []byte{'p', 'r', 'm', 's'}
+
+// readParams reads the parameters at the start of a file, nil when it has
+// none
+func readParams(r *bufio.Reader) (*Params, error) {
+	if magic, err := r.Peek(len(PARAMS_MAGIC)); err != nil || !bytes.Equal(magic, PARAMS_MAGIC) {
+		// whatever is there is left to the caller
+		return nil, nil
+	}
+
+	payload, err := readFrame(r, PARAMS_MAGIC)
+	if err != nil {
+		return nil, fmt.Errorf("parameters: %s", err)
+	}
+	params, err := DeserializeParams(payload)
+	if err != nil {
+		return nil, fmt.Errorf("parameters: %s", err)
+	}
+
+	return &params, nil
+}
+
+// loadParams makes an empty chain follow the parameters of a file it loads,
+// and a chain with blocks make sure it already does
+func (bc *Blockchain) loadParams(params *Params) error {
+	if params == nil {
+		return nil
+	}
+	if bc.Tip() == nil {
+		return bc.SetParams(*params)
+	}
+	if !bytes.Equal(params.Serialize(), bc.Params().Serialize()) {
+		return errors.New("the file is of a chain with other parameters")
+	}
+
+	return nil
+}
+
+// Params returns the consensus parameters of the chain
+func (bc *Blockchain) Params() Params {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.params
+}
+
+// Engine returns the consensus engine of the chain
+func (bc *Blockchain) Engine() ConsensusEngine {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.engine
+}
+
+// SetParams changes the consensus parameters of an empty chain, before a
+// bootstrap file or a snapshot of a chain using them is loaded
+func (bc *Blockchain) SetParams(params Params) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	if bc.tip != nil {
+		return errors.New("the parameters of a chain are fixed once it has blocks")
+	}
+
+	engine, err := NewEngine(params)
+	if err != nil {
+		return err
+	}
+
+	err = bc.db.Update(func(tx storage.Tx) error {
+		return putParams(tx, params)
+	})
+	if err != nil {
+		return err
+	}
+
+	bc.mu.Lock()
+	bc.params = params
+	bc.engine = engine
+	bc.mu.Unlock()
+	bindEngine(engine, bc)
+	bc.versionBitsCache = newVersionBitsCache()
+
+	return nil
+}
+
+// Supply summarizes the coins issued so far and to come
+type Supply struct {
+	Height int
+	// created by the blocks up to the tip, following the schedule
+	Issued int
+	// held by the UTXO set: less than issued when coinbases claimed less
+	// than they could
+	Unspent int
+	// of the next block
+	Subsidy     int
+	NextHalving int
+	MaxSupply   int
+}
+
+// Supply returns the coins issued up to the tip
+func (bc *Blockchain) Supply() (Supply, error) {
+	info, err := UTXOSet{bc}.Info()
+	if err != nil {
+		return Supply{}, err
+	}
+
+	params := bc.Params()
+
+	return Supply{
+		Height:      info.Height,
+		Issued:      params.IssuedAt(info.Height),
+		Unspent:     info.TotalAmount,
+		Subsidy:     params.Subsidy(info.Height + 1),
+		NextHalving: (info.Height/params.HalvingInterval + 1) * params.HalvingInterval,
+		MaxSupply:   params.MaxSupply(),
+	}, nil
+}
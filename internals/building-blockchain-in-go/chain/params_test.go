@@ -0,0 +1,123 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+func TestSubsidy(t *testing.T) {
+	params := Params{InitialSubsidy: 50, HalvingInterval: 10}
+
+	assert.Equal(t, 50, params.Subsidy(0))
+	assert.Equal(t, 50, params.Subsidy(9))
+	assert.Equal(t, 25, params.Subsidy(10))
+	assert.Equal(t, 12, params.Subsidy(20), "Rounded down")
+	assert.Equal(t, 0, params.Subsidy(60))
+	assert.Equal(t, 0, params.Subsidy(10000))
+
+	assert.Equal(t, 50, params.IssuedAt(0))
+	assert.Equal(t, 500+25, params.IssuedAt(10))
+	// 50 + 25 + 12 + 6 + 3 + 1
+	assert.Equal(t, 970, params.MaxSupply())
+	assert.Equal(t, params.MaxSupply(), params.IssuedAt(1000))
+	assert.Equal(t, 18*HALVING_INTERVAL, DefaultParams.MaxSupply())
+
+	assert.NotNil(t, Params{InitialSubsidy: 10}.Validate())
+}
+
+func TestCoinbaseRules(t *testing.T) {
+	_, address := newWallets(t)
+	params := DefaultParams
+	params.InitialSubsidy, params.HalvingInterval = 8, 1
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	greedy, _ := NewCoinbaseTX(address, "", 1, 8)
+	_, err = bc.AddBlock([]*Transaction{greedy})
+	assert.True(t, errors.Is(err, ErrInvalidTransaction), "Subsidy is halved at height 1")
+
+	early, _ := NewCoinbaseTX(address, "", 0, 4)
+	_, err = bc.AddBlock([]*Transaction{early})
+	assert.True(t, errors.Is(err, ErrInvalidTransaction), "Coinbase commits to another height")
+
+	coinbase, err := bc.NewCoinbase(address)
+	assert.Nil(t, err)
+	block, err := bc.AddBlock([]*Transaction{coinbase})
+	assert.Nil(t, err)
+	height, _ := block.Transactions[0].CoinbaseHeight()
+	assert.Equal(t, 1, height)
+	assert.Equal(t, 4, block.Transactions[0].Vout[0].Value)
+
+	// the parameters are saved with the chain
+	reopened, err := OpenBlockchain(bc.db)
+	assert.Nil(t, err)
+	assert.Equal(t, params, reopened.Params())
+	assert.NotNil(t, reopened.SetParams(DefaultParams), "Chain has blocks already")
+}
+
+func TestCoinbaseMaturity(t *testing.T) {
+	wallets, address := newWallets(t)
+	sender, _ := wallets.GetWallet(address)
+	params := DefaultParams
+	params.CoinbaseMaturity = 2
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	UTXOSet := UTXOSet{bc}
+	assert.Nil(t, UTXOSet.Reindex())
+
+	pubKeyHash := wallet.HashPubKey(sender.PublicKey)
+	spendable, immature, err := UTXOSet.Balance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{0, 10}, []int{spendable, immature}, "Genesis coinbase cannot be spent at height 1")
+	_, err = NewUTXOTransaction(sender, address, 3, &UTXOSet)
+	assert.Equal(t, ErrNotEnoughFunds, err)
+
+	// a signed transaction spending it anyway is refused by the mempool and
+	// in blocks
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+	tx := NewRawTransaction([]TXInput{{coinbase.ID, 0, nil, nil}}, []TXOutput{newOutput(t, 10, address)})
+	prevTXs := PrevTXsFromOutputs([]PrevOutput{{coinbase.ID, 0, coinbase.Vout[0]}})
+	complete, err := SignRawTransaction(tx, wallets, prevTXs, SIGHASH_ALL)
+	assert.Nil(t, err)
+	assert.True(t, complete)
+	assert.NotNil(t, UTXOSet.CheckTransaction(tx))
+	reward, err := bc.NewCoinbase(address)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{reward, tx})
+	assert.True(t, errors.Is(err, ErrInvalidTransaction))
+
+	// one block later, it is
+	reward, err = bc.NewCoinbase(address)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{reward})
+	assert.Nil(t, err)
+	spendable, immature, err = UTXOSet.Balance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, []int{10, 10}, []int{spendable, immature})
+	assert.Nil(t, UTXOSet.CheckTransaction(tx))
+
+	// undoing the block restores where the outputs come from
+	reward, err = bc.NewCoinbase(address)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{reward, tx})
+	assert.Nil(t, err)
+	_, err = bc.DisconnectTip()
+	assert.Nil(t, err)
+	coin, ok, err := UTXOSet.FindCoin(coinbase.ID, 0)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Coin{coinbase.Vout[0], 0, true}, coin)
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,235 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/xav-b/blockchain/encoding"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// With a proof of authority, like go-ethereum's Clique, blocks are not mined
+// but signed by a fixed set of signers, listed by address in the parameters of
+// the chain. They take turns: the block at height h is signed by the signer
+// h % len(signers), and nobody else. Blocks come as fast as the signers want,
+// which makes for quick test networks.
+//
+// The genesis block is not signed: the chain is defined by it and by its
+// parameters, which every node has to agree on anyway.
+
+// POINT_SIZE is the size of the numbers of a P-256 public key or signature.
+// They are padded to it, like in the transactions, so that a number with
+// leading zeros cannot shift the other.
+const POINT_SIZE = 32
+
+// ErrNotAuthorized is returned when sealing a block the node holds no key for
+var ErrNotAuthorized = errors.New("not authorized to sign the block")
+
+// ProofOfAuthority is the consensus engine of the chains signed in rotation
+type ProofOfAuthority struct {
+	signers []string
+
+	// the keys of the signers we are, by address
+	mu   sync.RWMutex
+	keys map[string]ecdsa.PrivateKey
+}
+
+// NewProofOfAuthority returns the engine of the signers, by address
+func NewProofOfAuthority(signers []string) (*ProofOfAuthority, error) {
+	if len(signers) == 0 {
+		return nil, errors.New("a proof of authority needs signers")
+	}
+	for _, signer := range signers {
+		if !wallet.ValidateAddress(signer) {
+			return nil, fmt.Errorf("invalid signer address %s", signer)
+		}
+	}
+
+	return &ProofOfAuthority{signers: signers, keys: map[string]ecdsa.PrivateKey{}}, nil
+}
+
+// Authorize lets the engine sign the blocks of the signer owning the key. It
+// returns false when the key is not one of the signers.
+func (p *ProofOfAuthority) Authorize(key ecdsa.PrivateKey) bool {
+	address := signerAddress(encodePoint(key.PublicKey.X, key.PublicKey.Y))
+
+	for _, signer := range p.signers {
+		if signer == address {
+			p.mu.Lock()
+			p.keys[address] = key
+			p.mu.Unlock()
+
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *ProofOfAuthority) Name() string {
+	return CONSENSUS_POA
+}
+
+// InTurn returns the address of the signer of the block at height
+func (p *ProofOfAuthority) InTurn(height int) string {
+	return p.signers[height%len(p.signers)]
+}
+
+// Prepare sets the public key of the signer whose turn it is
+func (p *ProofOfAuthority) Prepare(block *Block) error {
+	block.Nonce = 0
+	block.Signer = nil
+	block.Signature = nil
+	if block.Height == 0 {
+		return nil
+	}
+
+	signer := p.InTurn(block.Height)
+	p.mu.RLock()
+	key, ok := p.keys[signer]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: block %d is for %s", ErrNotAuthorized, block.Height, signer)
+	}
+	block.Signer = encodePoint(key.PublicKey.X, key.PublicKey.Y)
+
+	return nil
+}
+
+// Seal signs the hash of the block
+func (p *ProofOfAuthority) Seal(block *Block) error {
+	block.Hash = p.Hash(block)
+	if block.Height == 0 {
+		return nil
+	}
+
+	if len(block.Signer) != 2*POINT_SIZE {
+		return errors.New("the block must be prepared first")
+	}
+	signer := signerAddress(block.Signer)
+	p.mu.RLock()
+	key, ok := p.keys[signer]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: no key for %s", ErrNotAuthorized, signer)
+	}
+
+	return signBlock(block, key)
+}
+
+// Hash commits to the header, signer included
+func (p *ProofOfAuthority) Hash(block *Block) []byte {
+	return signedHash(block)
+}
+
+// VerifySeal checks the block is signed by the signer whose turn it is
+func (p *ProofOfAuthority) VerifySeal(block *Block) error {
+	if block.Height == 0 {
+		return verifyUnsigned(block)
+	}
+
+	signer := p.InTurn(block.Height)
+	if len(block.Signer) != 2*POINT_SIZE || signerAddress(block.Signer) != signer {
+		return fmt.Errorf("block %d must be signed by %s", block.Height, signer)
+	}
+
+	return verifySignature(block)
+}
+
+// Weight is the same for every block, there is no work to compare: the best
+// chain is the longest
+func (p *ProofOfAuthority) Weight(block *Block) *big.Int {
+	return big.NewInt(1)
+}
+
+// signedHash hashes the header of a signed block, signer included
+func signedHash(block *Block) []byte {
+	var version [][]byte
+	if v := block.hashedVersion(); v != 0 {
+		version = append(version, encoding.IntToHex(int64(v)))
+	}
+
+	data := bytes.Join(
+		append(version,
+			block.PrevBlockHash,
+			block.HashTransactions(),
+			encoding.IntToHex(block.Timestamp),
+			encoding.IntToHex(int64(block.Height)),
+			block.Signer,
+		),
+		[]byte{},
+	)
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// signBlock signs the hash of a block prepared for the key
+func signBlock(block *Block, key ecdsa.PrivateKey) error {
+	r, s, err := ecdsa.Sign(rand.Reader, &key, block.Hash)
+	if err != nil {
+		return err
+	}
+	block.Signature = encodePoint(r, s)
+
+	return nil
+}
+
+// verifySignature checks the block hash is signed by its signer
+func verifySignature(block *Block) error {
+	if len(block.Signer) != 2*POINT_SIZE {
+		return errors.New("invalid signer")
+	}
+	signer := signerAddress(block.Signer)
+	if len(block.Signature) != 2*POINT_SIZE {
+		return fmt.Errorf("invalid signature of %s", signer)
+	}
+
+	x, y := decodePoint(block.Signer)
+	r, s := decodePoint(block.Signature)
+	pubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !ecdsa.Verify(&pubKey, block.Hash, r, s) {
+		return fmt.Errorf("invalid signature of %s", signer)
+	}
+
+	return nil
+}
+
+// verifyUnsigned checks the genesis block of a signed chain is not signed
+func verifyUnsigned(block *Block) error {
+	if len(block.Signer) != 0 || len(block.Signature) != 0 {
+		return errors.New("the genesis block is not signed")
+	}
+
+	return nil
+}
+
+// encodePoint concatenates a pair of numbers, a public key or a signature
+func encodePoint(a, b *big.Int) []byte {
+	data := make([]byte, 2*POINT_SIZE)
+	a.FillBytes(data[:POINT_SIZE])
+	b.FillBytes(data[POINT_SIZE:])
+
+	return data
+}
+
+func decodePoint(data []byte) (*big.Int, *big.Int) {
+	return new(big.Int).SetBytes(data[:POINT_SIZE]), new(big.Int).SetBytes(data[POINT_SIZE:])
+}
+
+// signerPubKeyHash hashes a padded public key like the wallet does
+func signerPubKeyHash(signer []byte) []byte {
+	return wallet.HashPubKey(signer)
+}
+
+// signerAddress returns the address of a padded public key
+func signerAddress(signer []byte) string {
+	return wallet.PubKeyHashToAddress(signerPubKeyHash(signer))
+}
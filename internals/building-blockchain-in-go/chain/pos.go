@@ -0,0 +1,464 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+
+	"github.com/xav-b/blockchain/encoding"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// With a proof of stake, blocks are neither mined nor signed by a fixed set of
+// signers. Anybody holding coins can become a validator by locking them in
+// stake outputs, and the proposer of each block is drawn among the
+// validators, weighted by their stake: owning a tenth of the stake gives a
+// tenth of the blocks, like owning a tenth of the hash rate would.
+//
+// Each height is a slot with a single proposer, drawn from the hash of the
+// parent block and the height, so that every node draws the same one. There
+// are no empty slots: an absent proposer stalls the chain.
+//
+// The stakes are those of the UTXO set the block extends, which is only known
+// when connecting it: VerifySeal only checks the signature, and the proposer
+// is checked by ConnectBlock.
+//
+// A stake output stays locked StakeLock blocks after it is created. A
+// validator signing two blocks at the same height (equivocating, to split
+// the network) can be punished meanwhile: anybody holding both headers can
+// include them as evidence in a slashing transaction, which burns the stake.
+//
+// The genesis block is not signed, half of its reward is the stake of the
+// first validator.
+
+// Stake is the total of the stake outputs of a validator
+type Stake struct {
+	PubKeyHash []byte
+	Value      int
+}
+
+// Stakes are those of every validator, ordered by public key hash
+type Stakes []Stake
+
+// Total returns the coins staked by the validators
+func (s Stakes) Total() int {
+	total := 0
+	for _, stake := range s {
+		total += stake.Value
+	}
+
+	return total
+}
+
+// Proposer draws the public key hash of the validator proposing the block at
+// height on top of prevBlockHash
+func (s Stakes) Proposer(prevBlockHash []byte, height int) ([]byte, error) {
+	total := s.Total()
+	if total == 0 {
+		return nil, errors.New("nothing is staked, no validator can propose blocks")
+	}
+
+	seed := sha256.Sum256(bytes.Join([][]byte{prevBlockHash, encoding.IntToHex(int64(height))}, []byte{}))
+	draw := new(big.Int).Mod(new(big.Int).SetBytes(seed[:]), big.NewInt(int64(total))).Int64()
+	for _, stake := range s {
+		if draw < int64(stake.Value) {
+			return stake.PubKeyHash, nil
+		}
+		draw -= int64(stake.Value)
+	}
+
+	// the draw is below the total
+	panic("unreachable")
+}
+
+// Stakes returns the stakes of the validators of the UTXO set
+func (u UTXOSet) Stakes() (Stakes, error) {
+	byOwner := make(map[string]int)
+
+	err := u.Blockchain.db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(UTXO_BUCKET))
+		if b == nil {
+			// not indexed yet
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+			addStakes(byOwner, outs)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newStakes(byOwner), nil
+}
+
+// addStakes adds the stake outputs among outs to the stakes of their owner,
+// by hex public key hash
+func addStakes(byOwner map[string]int, outs TXOutputs) {
+	for _, out := range outs.Outputs {
+		if owner, ok := out.StakeOwner(); ok {
+			byOwner[hex.EncodeToString(owner)] += out.Value
+		}
+	}
+}
+
+// newStakes sorts the stakes added up by addStakes
+func newStakes(byOwner map[string]int) Stakes {
+	owners := make([]string, 0, len(byOwner))
+	for owner := range byOwner {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+
+	stakes := make(Stakes, 0, len(owners))
+	for _, owner := range owners {
+		// encoded by addStakes
+		pubKeyHash, _ := hex.DecodeString(owner)
+		stakes = append(stakes, Stake{pubKeyHash, byOwner[owner]})
+	}
+
+	return stakes
+}
+
+// IsUnlocked tells whether the coin can be spent by its owner in a block at
+// height: a stake is locked stakeLock blocks
+func (c Coin) IsUnlocked(height, stakeLock int) bool {
+	_, staked := c.Output.StakeOwner()
+
+	return !staked || height-c.Height >= stakeLock
+}
+
+// NewUnstakeTX creates a transaction giving the wallet its unlocked stake
+// back
+func NewUnstakeTX(from *wallet.Wallet, UTXOSet *UTXOSet) (*Transaction, error) {
+	bestHeight, err := UTXOSet.Blockchain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	stakeLock := UTXOSet.Blockchain.Params().StakeLock
+
+	stakes, err := UTXOSet.FindUnspent(StakePubKeyHash(wallet.HashPubKey(from.PublicKey)))
+	if err != nil {
+		return nil, err
+	}
+
+	var inputs []TXInput
+	var witness []TXWitness
+	amount := 0
+	for _, stake := range stakes {
+		coin, ok, err := UTXOSet.FindCoin(stake.Txid, stake.Vout)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !coin.IsUnlocked(bestHeight+1, stakeLock) {
+			continue
+		}
+		inputs = append(inputs, TXInput{stake.Txid, stake.Vout, nil, nil})
+		witness = append(witness, TXWitness{nil, from.PublicKey})
+		amount += stake.Output.Value
+	}
+	if len(inputs) == 0 {
+		return nil, errors.New("no unlocked stake")
+	}
+
+	output, err := NewTXOutput(amount, string(from.Address()))
+	if err != nil {
+		return nil, err
+	}
+
+	tx := Transaction{nil, inputs, []TXOutput{*output}, witness}
+	tx.ID = tx.Hash()
+	if err := UTXOSet.Blockchain.SignTransaction(&tx, from.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// ProofOfStake is the consensus engine of the chains whose blocks are
+// proposed by validators drawn by stake
+type ProofOfStake struct {
+	// stakes returns those of the UTXO set of the chain, before the block
+	// being sealed or connected
+	stakes func() (Stakes, error)
+
+	// the keys of the validators we are, by address
+	mu   sync.RWMutex
+	keys map[string]ecdsa.PrivateKey
+}
+
+// NewProofOfStake returns an engine to bind to a chain
+func NewProofOfStake() *ProofOfStake {
+	return &ProofOfStake{keys: map[string]ecdsa.PrivateKey{}}
+}
+
+// bindEngine lets a proof of stake read the stakes from the UTXO set of the
+// chain
+func bindEngine(engine ConsensusEngine, bc *Blockchain) {
+	if pos, ok := engine.(*ProofOfStake); ok {
+		pos.stakes = UTXOSet{bc}.Stakes
+	}
+}
+
+// Authorize lets the engine propose the blocks drawn for the owner of the
+// key. Anybody can stake, so any key is accepted.
+func (p *ProofOfStake) Authorize(key ecdsa.PrivateKey) bool {
+	p.mu.Lock()
+	p.keys[signerAddress(encodePoint(key.PublicKey.X, key.PublicKey.Y))] = key
+	p.mu.Unlock()
+
+	return true
+}
+
+func (p *ProofOfStake) Name() string {
+	return CONSENSUS_POS
+}
+
+// Proposer returns the address of the validator drawn to propose the block,
+// which must extend the tip of the chain
+func (p *ProofOfStake) Proposer(block *Block) (string, error) {
+	if p.stakes == nil {
+		return "", errors.New("the proof of stake is not bound to a chain")
+	}
+
+	stakes, err := p.stakes()
+	if err != nil {
+		return "", err
+	}
+	pubKeyHash, err := stakes.Proposer(block.PrevBlockHash, block.Height)
+	if err != nil {
+		return "", err
+	}
+
+	return wallet.PubKeyHashToAddress(pubKeyHash), nil
+}
+
+// Prepare sets the public key of the proposer of the block
+func (p *ProofOfStake) Prepare(block *Block) error {
+	block.Nonce = 0
+	block.Signer = nil
+	block.Signature = nil
+	if block.Height == 0 {
+		return nil
+	}
+
+	proposer, err := p.Proposer(block)
+	if err != nil {
+		return err
+	}
+	p.mu.RLock()
+	key, ok := p.keys[proposer]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: block %d is for %s", ErrNotAuthorized, block.Height, proposer)
+	}
+	block.Signer = encodePoint(key.PublicKey.X, key.PublicKey.Y)
+
+	return nil
+}
+
+// Seal signs the hash of the block
+func (p *ProofOfStake) Seal(block *Block) error {
+	block.Hash = p.Hash(block)
+	if block.Height == 0 {
+		return nil
+	}
+
+	if len(block.Signer) != 2*POINT_SIZE {
+		return errors.New("the block must be prepared first")
+	}
+	signer := signerAddress(block.Signer)
+	p.mu.RLock()
+	key, ok := p.keys[signer]
+	p.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: no key for %s", ErrNotAuthorized, signer)
+	}
+
+	return signBlock(block, key)
+}
+
+// Hash commits to the header, proposer included
+func (p *ProofOfStake) Hash(block *Block) []byte {
+	return signedHash(block)
+}
+
+// VerifySeal checks the block is signed by its signer, VerifyProposer that
+// it was its turn
+func (p *ProofOfStake) VerifySeal(block *Block) error {
+	if block.Height == 0 {
+		return verifyUnsigned(block)
+	}
+
+	return verifySignature(block)
+}
+
+// VerifyProposer checks the block, extending the tip of the chain, is signed
+// by the validator drawn to propose it
+func (p *ProofOfStake) VerifyProposer(block *Block) error {
+	if block.Height == 0 {
+		return nil
+	}
+
+	proposer, err := p.Proposer(block)
+	if err != nil {
+		return err
+	}
+
+	return verifyProposer(block, proposer)
+}
+
+// verifyProposer checks the block is signed by the address of the proposer
+func verifyProposer(block *Block, proposer string) error {
+	if len(block.Signer) != 2*POINT_SIZE || signerAddress(block.Signer) != proposer {
+		return fmt.Errorf("block %d must be proposed by %s", block.Height, proposer)
+	}
+
+	return nil
+}
+
+// Weight is the same for every block, like with a proof of authority
+func (p *ProofOfStake) Weight(block *Block) *big.Int {
+	return big.NewInt(1)
+}
+
+// Equivocation is the evidence of a validator signing two blocks at the same
+// height
+type Equivocation struct {
+	A BlockHeader
+	B BlockHeader
+}
+
+// Serialize serializes the evidence
+func (e Equivocation) Serialize() []byte {
+	var result bytes.Buffer
+
+	encoder := gob.NewEncoder(&result)
+	if err := encoder.Encode(e); err != nil {
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// DeserializeEquivocation deserializes evidence
+func DeserializeEquivocation(d []byte) (Equivocation, error) {
+	var evidence Equivocation
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&evidence)
+
+	return evidence, err
+}
+
+// Verify checks the evidence, returning the public key hash of the validator
+// who equivocated. The blocks do not have to be part of the chain: signing
+// both is the offense.
+func (e Equivocation) Verify() ([]byte, error) {
+	a, b := e.A.Block(), e.B.Block()
+	if a.Height != b.Height || a.Height == 0 {
+		return nil, errors.New("the blocks are not at the same height")
+	}
+	if bytes.Equal(a.Hash, b.Hash) {
+		return nil, errors.New("the blocks are the same")
+	}
+	if !bytes.Equal(a.Signer, b.Signer) {
+		return nil, errors.New("the blocks have different signers")
+	}
+
+	for _, block := range []*Block{a, b} {
+		if !bytes.Equal(signedHash(block), block.Hash) {
+			return nil, errors.New("header does not hash to the block hash")
+		}
+		if err := verifySignature(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return signerPubKeyHash(a.Signer), nil
+}
+
+// NewSlashingTX burns the stake outputs of the validator caught equivocating
+// by the evidence. The evidence takes the place of the signature of every
+// input, and the transaction has no outputs.
+func NewSlashingTX(evidence Equivocation, stakes []UTXO) (*Transaction, error) {
+	if len(stakes) == 0 {
+		return nil, errors.New("nothing to slash")
+	}
+
+	var inputs []TXInput
+	data := evidence.Serialize()
+	for _, stake := range stakes {
+		inputs = append(inputs, TXInput{stake.Txid, stake.Vout, data, nil})
+	}
+
+	tx := Transaction{nil, inputs, nil, nil}
+	tx.ID = tx.Hash()
+
+	return &tx, nil
+}
+
+// IsSlashing tells whether the transaction burns stake outputs, the only
+// transactions without outputs
+func (tx Transaction) IsSlashing() bool {
+	return !tx.IsCoinbase() && len(tx.Vin) > 0 && len(tx.Vout) == 0
+}
+
+// checkSlashing makes sure a slashing transaction only spends the stake
+// outputs (coins) of a validator its evidence proves equivocated
+func checkSlashing(tx *Transaction, coins []Coin) error {
+	evidence, err := DeserializeEquivocation(tx.Vin[0].Signature)
+	if err != nil {
+		return fmt.Errorf("slashing transaction %x has malformed evidence", tx.ID)
+	}
+	offender, err := evidence.Verify()
+	if err != nil {
+		return fmt.Errorf("slashing transaction %x has invalid evidence: %s", tx.ID, err)
+	}
+
+	for i, vin := range tx.Vin {
+		if !bytes.Equal(vin.Signature, tx.Vin[0].Signature) {
+			return fmt.Errorf("slashing transaction %x carries different evidence in its inputs", tx.ID)
+		}
+		owner, ok := coins[i].Output.StakeOwner()
+		if !ok || !bytes.Equal(owner, offender) {
+			return fmt.Errorf("output %s is not a stake of the offender", Outpoint(vin.Txid, vin.Vout))
+		}
+	}
+
+	return nil
+}
+
+// Slash burns the stake of the validator caught by the evidence, in a block
+// whose reward goes to rewardAddress
+func (u UTXOSet) Slash(evidence Equivocation, rewardAddress string) (*Block, error) {
+	offender, err := evidence.Verify()
+	if err != nil {
+		return nil, err
+	}
+	stakes, err := u.FindUnspent(StakePubKeyHash(offender))
+	if err != nil {
+		return nil, err
+	}
+	tx, err := NewSlashingTX(evidence, stakes)
+	if err != nil {
+		return nil, err
+	}
+
+	return u.SendRawTransaction(tx, rewardAddress)
+}
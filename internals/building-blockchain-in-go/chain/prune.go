@@ -0,0 +1,163 @@
+package chain
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/encoding"
+	"github.com/xav-b/blockchain/storage"
+)
+
+// Once the UTXO set is built, old blocks are only needed to browse history.
+// In prune mode we only keep the full blocks of the last N blocks below the
+// tip: older ones are reduced to their header (still enough to walk the chain
+// and check its proof of work) and their undo data is dropped, since they are
+// too deep to ever be disconnected.
+//
+// The mode is saved in the database: once blocks are deleted, the only way
+// back to a full node is to import the chain again.
+
+const (
+	HEADERS_BUCKET = "headers"
+	META_BUCKET    = "meta"
+	// Keep at least that many blocks, it is the deepest reorg we can undo
+	MIN_PRUNE_DEPTH = 10
+)
+
+// ErrBlockPruned is returned when a block body needed was deleted by pruning
+var ErrBlockPruned = errors.New("block data pruned")
+
+// PruneDepth returns how many full blocks are kept below the tip, 0 meaning
+// pruning is disabled
+func (bc *Blockchain) PruneDepth() int {
+	depth := 0
+
+	_ = bc.db.View(func(tx storage.Tx) error {
+		if b := tx.Bucket([]byte(META_BUCKET)); b != nil {
+			if value := b.Get([]byte("prune")); value != nil {
+				depth = int(binary.BigEndian.Uint64(value))
+			}
+		}
+
+		return nil
+	})
+
+	return depth
+}
+
+// SetPruneDepth enables prune mode, keeping the last `depth` blocks. Blocks
+// already deeper are pruned right away.
+func (bc *Blockchain) SetPruneDepth(depth int) error {
+	if depth < MIN_PRUNE_DEPTH {
+		return fmt.Errorf("prune depth must be at least %d blocks", MIN_PRUNE_DEPTH)
+	}
+
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	err := bc.db.Update(func(tx storage.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(META_BUCKET))
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte("prune"), encoding.IntToHex(int64(depth)))
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = bc.prune()
+
+	return err
+}
+
+// Prune deletes the blocks deeper than the prune depth, and returns how many
+func (bc *Blockchain) Prune() (int, error) {
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	return bc.prune()
+}
+
+// prune implements Prune, the writer lock must be held
+func (bc *Blockchain) prune() (int, error) {
+	depth := bc.PruneDepth()
+	if depth == 0 || bc.tip == nil {
+		return 0, nil
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := bestHeight - depth
+	pruned := 0
+
+	bci := bc.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return pruned, err
+		}
+		if block.IsPruned() {
+			// blocks are pruned from the oldest, so were all the next ones
+			break
+		}
+
+		if block.Height < cutoff {
+			if err := bc.pruneBlock(block); err != nil {
+				return pruned, err
+			}
+			pruned++
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	return pruned, nil
+}
+
+// PruneHeight returns the height of the oldest block still fully stored
+func (bc *Blockchain) PruneHeight() (int, error) {
+	bci := bc.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return 0, err
+		}
+		if block.IsPruned() {
+			return block.Height + 1, nil
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			return 0, nil
+		}
+	}
+}
+
+func (bc *Blockchain) pruneBlock(block *Block) error {
+	return bc.db.Update(func(tx storage.Tx) error {
+		headers, err := tx.CreateBucketIfNotExists([]byte(HEADERS_BUCKET))
+		if err != nil {
+			return err
+		}
+
+		// the header goes first, so the chain can always be walked
+		if err := headers.Put(block.Hash, NewBlockHeader(block).Serialize()); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(BLOCKS_BUCKET)).Delete(block.Hash); err != nil {
+			return err
+		}
+
+		if undo := tx.Bucket([]byte(UNDO_BUCKET)); undo != nil {
+			return undo.Delete(block.Hash)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestPrunedBlocks(t *testing.T) {
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+	bobWallet, err := wallets.GetWallet(bob)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+
+	// bob is paid in block 1, which is pruned once 13 blocks follow it
+	tx, err := NewUTXOTransaction(aliceWallet, bob, 3, &utxo)
+	assert.Nil(t, err)
+	blocks := []*Block{&genesis}
+	addBlock := func(transactions ...*Transaction) {
+		block, err := bc.AddBlock(append([]*Transaction{newCoinbase(t, alice, len(blocks))}, transactions...))
+		assert.Nil(t, err)
+		blocks = append(blocks, block)
+	}
+	addBlock(tx)
+	for height := 2; height <= MIN_PRUNE_DEPTH+4; height++ {
+		addBlock()
+	}
+	recent := blocks[len(blocks)-1].Transactions[0]
+
+	assert.Nil(t, bc.SetPruneDepth(MIN_PRUNE_DEPTH))
+	pruneHeight, err := bc.PruneHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 4, pruneHeight)
+
+	// the header of a pruned block is still there
+	header, err := bc.GetBlock(blocks[1].Hash)
+	assert.Equal(t, ErrBlockPruned, err)
+	assert.True(t, header.IsPruned())
+	assert.Equal(t, 1, header.Height)
+	assert.Equal(t, blocks[1].Hash, header.Hash)
+	assert.Equal(t, blocks[1].PrevBlockHash, header.PrevBlockHash)
+	assert.Equal(t, blocks[1].Timestamp, header.Timestamp)
+	assert.Empty(t, header.Transactions)
+
+	// but not its transactions
+	_, err = bc.FindTransaction(tx.ID)
+	assert.True(t, errors.Is(err, ErrBlockPruned))
+	found, err := bc.FindTransaction(recent.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, recent.ID, found.ID)
+	assert.True(t, errors.Is(ExportChain(bc, &bytes.Buffer{}, func(int, int) {}), ErrBlockPruned))
+
+	// the UTXO set still has the outputs they created
+	spend, err := NewUTXOTransaction(bobWallet, alice, 2, &utxo)
+	assert.Nil(t, err)
+	addBlock(spend)
+
+	checked, err := bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, len(blocks), checked, "Pruned blocks are checked down to their headers")
+
+	// once the tip is disconnected, the median time past reaches the pruned
+	// headers
+	_, err = bc.DisconnectTip()
+	assert.Nil(t, err)
+	_, err = bc.DisconnectTip()
+	assert.Nil(t, err)
+	bestHeight, err := bc.GetBestHeight()
+	assert.Nil(t, err)
+	pruneHeight, err = bc.PruneHeight()
+	assert.Nil(t, err)
+	first := bestHeight - MEDIAN_TIME_SPAN + 1
+	assert.True(t, first < pruneHeight)
+
+	var timestamps []int64
+	for _, block := range blocks[first : bestHeight+1] {
+		timestamps = append(timestamps, block.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	assert.Equal(t, timestamps[len(timestamps)/2], mtp)
+}
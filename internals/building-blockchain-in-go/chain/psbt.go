@@ -0,0 +1,210 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// A partially signed transaction (PSBT, see BIP 174 for the Bitcoin version)
+// carries everything needed to sign a transaction without access to the
+// chain: the unsigned transaction, the outputs it spends and the signatures
+// collected so far. Several parties or devices can then each sign their
+// inputs, combine their copies, and finalize it into a regular transaction.
+//
+// It is exchanged base64 encoded, to not be mistaken for a raw transaction.
+
+// PSBTInput holds what is known about an input of the transaction
+type PSBTInput struct {
+	// PrevOutput is the output spent by the input
+	PrevOutput TXOutput
+	// PartialSigs maps hex encoded public keys to their signature
+	PartialSigs map[string][]byte
+}
+
+// PSBT is a partially signed transaction
+type PSBT struct {
+	// Tx is the unsigned transaction, it is never modified
+	Tx     Transaction
+	Inputs []PSBTInput
+}
+
+// NewPSBT wraps an unsigned transaction along with the outputs it spends
+func NewPSBT(tx *Transaction, prevTXs map[string]Transaction) (*PSBT, error) {
+	psbt := PSBT{Tx: tx.TrimmedCopy()}
+	psbt.Tx.ID = psbt.Tx.Hash()
+
+	for _, vin := range tx.Vin {
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return nil, fmt.Errorf("previous output %s is missing", Outpoint(vin.Txid, vin.Vout))
+		}
+
+		psbt.Inputs = append(psbt.Inputs, PSBTInput{prevTx.Vout[vin.Vout], make(map[string][]byte)})
+	}
+
+	return &psbt, nil
+}
+
+// prevTXs returns the previous transactions as expected by Transaction.Sign
+func (p *PSBT) prevTXs() map[string]Transaction {
+	var prevOuts []PrevOutput
+
+	for inID, vin := range p.Tx.Vin {
+		prevOuts = append(prevOuts, PrevOutput{vin.Txid, vin.Vout, p.Inputs[inID].PrevOutput})
+	}
+
+	return PrevTXsFromOutputs(prevOuts)
+}
+
+// Sign adds the signatures of the inputs locked by one of the keys of the
+// wallets, and returns how many were added
+func (p *PSBT) Sign(wallets *wallet.Wallets) (int, error) {
+	prevTXs := p.prevTXs()
+	signed := 0
+
+	for inID := range p.Tx.Vin {
+		address := wallet.PubKeyHashToAddress(p.Inputs[inID].PrevOutput.PubKeyHash)
+		w, ok := wallets.Wallets[address]
+		if !ok {
+			continue
+		}
+
+		// sign a copy to leave the unsigned transaction untouched
+		txCopy := p.Tx.TrimmedCopy()
+		if err := txCopy.SignInput(inID, w.PrivateKey, prevTXs, SIGHASH_ALL); err != nil {
+			return signed, err
+		}
+
+		p.Inputs[inID].PartialSigs[hex.EncodeToString(w.PublicKey)] = txCopy.Vin[inID].Signature
+		signed++
+	}
+
+	return signed, nil
+}
+
+// CombinePSBTs merges the signatures of several copies of the same PSBT into
+// a new one, leaving them untouched
+func CombinePSBTs(psbts []*PSBT) (*PSBT, error) {
+	if len(psbts) == 0 {
+		return nil, errors.New("nothing to combine")
+	}
+
+	// the IDs come with the PSBTs, only their hashes tell the transactions
+	// are the same
+	txID := psbts[0].Tx.Hash()
+	combined := &PSBT{Tx: psbts[0].Tx}
+	combined.Tx.ID = txID
+	for _, input := range psbts[0].Inputs {
+		sigs := make(map[string][]byte)
+		for pubKey, signature := range input.PartialSigs {
+			sigs[pubKey] = signature
+		}
+		combined.Inputs = append(combined.Inputs, PSBTInput{input.PrevOutput, sigs})
+	}
+	if len(combined.Inputs) != len(combined.Tx.Vin) {
+		return nil, errors.New("inputs don't match the transaction")
+	}
+
+	for _, psbt := range psbts[1:] {
+		if hash := psbt.Tx.Hash(); !bytes.Equal(hash, txID) {
+			return nil, fmt.Errorf("PSBTs are for different transactions: %x and %x", txID, hash)
+		}
+		if len(psbt.Inputs) != len(combined.Inputs) {
+			return nil, errors.New("inputs don't match the transaction")
+		}
+
+		for inID, input := range psbt.Inputs {
+			for pubKey, signature := range input.PartialSigs {
+				combined.Inputs[inID].PartialSigs[pubKey] = signature
+			}
+		}
+	}
+
+	return combined, nil
+}
+
+// Finalize builds the signed transaction out of the collected signatures. It
+// fails if an input is not signed yet or a signature is invalid. They go in
+// the witness, leaving the ID the one of the unsigned transaction.
+func (p *PSBT) Finalize() (*Transaction, error) {
+	tx := p.Tx.TrimmedCopy()
+	tx.Witness = make([]TXWitness, len(tx.Vin))
+
+	for inID, input := range p.Inputs {
+		for pubKey, signature := range input.PartialSigs {
+			rawPubKey, err := hex.DecodeString(pubKey)
+			if err != nil {
+				return nil, err
+			}
+
+			// inputs are locked by a single key, the one matching the hash
+			if bytes.Equal(wallet.HashPubKey(rawPubKey), input.PrevOutput.PubKeyHash) {
+				tx.Witness[inID] = TXWitness{signature, rawPubKey}
+			}
+		}
+
+		if tx.Witness[inID].Signature == nil {
+			return nil, fmt.Errorf("input %d is not signed", inID)
+		}
+	}
+
+	tx.ID = tx.Hash()
+	if !tx.Verify(p.prevTXs()) {
+		return nil, errors.New("invalid signature")
+	}
+
+	return &tx, nil
+}
+
+// Serialize serializes the PSBT
+func (p *PSBT) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	enc := gob.NewEncoder(&encoded)
+	// encoding to memory only fails on unsupported types
+	if err := enc.Encode(p); err != nil {
+		panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// EncodePSBT returns the base64 encoding of the PSBT
+func EncodePSBT(p *PSBT) string {
+	return base64.StdEncoding.EncodeToString(p.Serialize())
+}
+
+// DecodePSBT decodes the base64 encoding of a PSBT
+func DecodePSBT(encoded string) (*PSBT, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PSBT: %s", err)
+	}
+
+	var psbt PSBT
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&psbt); err != nil {
+		return nil, fmt.Errorf("invalid PSBT: %s", err)
+	}
+
+	if len(psbt.Inputs) != len(psbt.Tx.Vin) {
+		return nil, errors.New("invalid PSBT: inputs don't match the transaction")
+	}
+	for inID := range psbt.Inputs {
+		if psbt.Tx.Vin[inID].Vout < 0 {
+			return nil, fmt.Errorf("invalid PSBT: input %d has a negative output index", inID)
+		}
+		// gob decodes empty maps as nil
+		if psbt.Inputs[inID].PartialSigs == nil {
+			psbt.Inputs[inID].PartialSigs = make(map[string][]byte)
+		}
+	}
+
+	return &psbt, nil
+}
@@ -0,0 +1,65 @@
+package chain
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPSBTTwoSigners(t *testing.T) {
+	alice, aliceAddress := newWallets(t)
+	bob, bobAddress := newWallets(t)
+
+	aliceTx := newCoinbase(t, aliceAddress, 1)
+	bobTx := newCoinbase(t, bobAddress, 1)
+	prevTXs := map[string]Transaction{
+		hex.EncodeToString(aliceTx.ID): *aliceTx,
+		hex.EncodeToString(bobTx.ID):   *bobTx,
+	}
+
+	tx := NewRawTransaction(
+		[]TXInput{{aliceTx.ID, 0, nil, nil}, {bobTx.ID, 0, nil, nil}},
+		[]TXOutput{newOutput(t, 2*INITIAL_SUBSIDY, aliceAddress)},
+	)
+	psbt, err := NewPSBT(tx, prevTXs)
+	assert.Nil(t, err)
+
+	// each party signs its own copy, without the chain
+	aliceCopy, _ := DecodePSBT(EncodePSBT(psbt))
+	bobCopy, _ := DecodePSBT(EncodePSBT(psbt))
+	signed, err := aliceCopy.Sign(alice)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, signed, "Alice signs her input")
+	signed, err = bobCopy.Sign(bob)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, signed, "Bob signs his input")
+
+	_, err = aliceCopy.Finalize()
+	assert.NotNil(t, err, "Cannot finalize with Bob's signature missing")
+
+	// a PSBT of another transaction cannot pass for this one with its ID
+	other, err := NewPSBT(NewRawTransaction(tx.Vin[:1], tx.Vout), prevTXs)
+	assert.Nil(t, err)
+	other.Tx.ID = psbt.Tx.ID
+	_, err = CombinePSBTs([]*PSBT{aliceCopy, other})
+	assert.NotNil(t, err)
+
+	combined, err := CombinePSBTs([]*PSBT{aliceCopy, bobCopy})
+	assert.Nil(t, err)
+	assert.Len(t, aliceCopy.Inputs[1].PartialSigs, 0, "Copies are left untouched")
+
+	final, err := combined.Finalize()
+	assert.Nil(t, err)
+	assert.True(t, final.Verify(prevTXs), "Finalized transaction is valid")
+}
+
+func TestPSBTNegativeVout(t *testing.T) {
+	_, address := newWallets(t)
+	prevTx := newCoinbase(t, address, 1)
+	prevTXs := map[string]Transaction{hex.EncodeToString(prevTx.ID): *prevTx}
+
+	tx := NewRawTransaction([]TXInput{{prevTx.ID, -1, nil, nil}}, []TXOutput{newOutput(t, 1, address)})
+	_, err := NewPSBT(tx, prevTXs)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,258 @@
+package chain
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// NewUTXOTransaction selects the coins, builds the transaction and signs it
+// all at once, with the wallet and the chain on the same machine. Raw
+// transactions split those steps so that the keys can stay offline:
+//
+//   1. createrawtransaction: spend explicit inputs to explicit outputs
+//   2. signrawtransaction: sign with a wallet file, no chain needed as long as
+//      the previous outputs are given
+//   3. sendrawtransaction: validate and mine it on the node
+//
+// The hex encoding is the one of Transaction.Serialize.
+
+// PrevOutput is an output spent by a raw transaction
+type PrevOutput struct {
+	Txid   []byte
+	Vout   int
+	Output TXOutput
+}
+
+// NewRawTransaction creates an unsigned transaction spending exactly the
+// given inputs to the given outputs. Whatever is not spent to the outputs is
+// lost, there is no change computed for us.
+func NewRawTransaction(inputs []TXInput, outputs []TXOutput) *Transaction {
+	tx := Transaction{nil, inputs, outputs, nil}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// DecodeRawTransaction decodes the hex encoding of a serialized transaction
+func DecodeRawTransaction(rawTx string) (*Transaction, error) {
+	data, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %s", err)
+	}
+
+	tx, err := DeserializeTransaction(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw transaction: %s", err)
+	}
+
+	return &tx, nil
+}
+
+// EncodeRawTransaction is the reverse of DecodeRawTransaction
+func EncodeRawTransaction(tx *Transaction) string {
+	return hex.EncodeToString(tx.Serialize())
+}
+
+// PrevTXsFromOutputs builds the map of previous transactions expected by
+// Transaction.Sign and Verify out of the spent outputs only. The transactions
+// are sparse, only the outputs spent are filled in, but that is all signing
+// needs.
+func PrevTXsFromOutputs(prevOuts []PrevOutput) map[string]Transaction {
+	prevTXs := make(map[string]Transaction)
+
+	for _, prevOut := range prevOuts {
+		// no output has a negative index, it is left missing
+		if prevOut.Vout < 0 {
+			continue
+		}
+		txID := hex.EncodeToString(prevOut.Txid)
+		prevTx, ok := prevTXs[txID]
+		if !ok {
+			prevTx = Transaction{ID: prevOut.Txid}
+		}
+
+		for len(prevTx.Vout) <= prevOut.Vout {
+			prevTx.Vout = append(prevTx.Vout, TXOutput{})
+		}
+		prevTx.Vout[prevOut.Vout] = prevOut.Output
+		prevTXs[txID] = prevTx
+	}
+
+	return prevTXs
+}
+
+// SignRawTransaction signs the inputs of tx locked by one of the keys of the
+// wallets with hashType, leaving the others to their owners. It returns
+// whether the transaction is now fully signed.
+func SignRawTransaction(tx *Transaction, wallets *wallet.Wallets, prevTXs map[string]Transaction, hashType SigHashType) (bool, error) {
+	for inID, vin := range tx.Vin {
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false, fmt.Errorf("previous output %s is missing", Outpoint(vin.Txid, vin.Vout))
+		}
+
+		address := wallet.PubKeyHashToAddress(prevTx.Vout[vin.Vout].PubKeyHash)
+		w, ok := wallets.Wallets[address]
+		if !ok {
+			continue
+		}
+
+		if tx.HasWitness() {
+			tx.Witness[inID].PubKey = w.PublicKey
+		} else {
+			tx.Vin[inID].PubKey = w.PublicKey
+		}
+		if err := tx.SignInput(inID, w.PrivateKey, prevTXs, hashType); err != nil {
+			return false, err
+		}
+	}
+
+	tx.ID = tx.Hash()
+
+	return tx.Verify(prevTXs), nil
+}
+
+// CheckTransaction makes sure tx only spends unspent outputs, each of them
+// once, and no more than they hold. Signatures are checked as well.
+// Coinbase outputs must be mature by the next block, and stakes unlocked.
+func (u UTXOSet) CheckTransaction(tx *Transaction) error {
+	height, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	_, rules, err := u.Blockchain.nextVersionBits()
+	if err != nil {
+		return err
+	}
+
+	_, err = checkTransaction(tx, u.FindCoin, height+1, u.Blockchain.Params(), rules)
+
+	return err
+}
+
+// checkTransaction implements CheckTransaction for any view of the unspent
+// outputs and a block at height following rules, and returns the fee: what
+// the inputs hold beyond the outputs
+func checkTransaction(tx *Transaction, findCoin func(txid []byte, vout int) (Coin, bool, error), height int, params Params, rules Rules) (int, error) {
+	if tx.IsCoinbase() {
+		return 0, fmt.Errorf("coinbase transaction %x can only be mined", tx.ID)
+	}
+	if err := tx.checkID(); err != nil {
+		return 0, err
+	}
+	if err := tx.checkWitness(); err != nil {
+		return 0, err
+	}
+	slashing := tx.IsSlashing() && params.Consensus == CONSENSUS_POS
+	if len(tx.Vin) == 0 || (len(tx.Vout) == 0 && !slashing) {
+		return 0, fmt.Errorf("transaction %x needs inputs and outputs", tx.ID)
+	}
+	// like BIP30, an ID cannot be reused while the outputs of the transaction
+	// that had it are not all spent, they would be overwritten. The ID being
+	// the hash, the two transactions have the same outputs.
+	for i := range tx.Vout {
+		if _, ok, err := findCoin(tx.ID, i); err != nil {
+			return 0, err
+		} else if ok {
+			return 0, fmt.Errorf("transaction %x already exists with unspent outputs", tx.ID)
+		}
+	}
+
+	var prevOuts []PrevOutput
+	var coins []Coin
+	spent := make(map[string]bool)
+	inputs := 0
+
+	for _, vin := range tx.Vin {
+		key := Outpoint(vin.Txid, vin.Vout)
+		if spent[key] {
+			return 0, fmt.Errorf("output %s is spent twice", key)
+		}
+		spent[key] = true
+
+		coin, ok, err := findCoin(vin.Txid, vin.Vout)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return 0, fmt.Errorf("output %s is unknown or already spent", key)
+		}
+		if !coin.IsMature(height, params.CoinbaseMaturity) {
+			return 0, fmt.Errorf("output %s is an immature coinbase, spendable from height %d", key, coin.Height+params.CoinbaseMaturity)
+		}
+		if !slashing && !coin.IsUnlocked(height, params.StakeLock) {
+			return 0, fmt.Errorf("output %s is a locked stake, spendable from height %d", key, coin.Height+params.StakeLock)
+		}
+
+		inputs += coin.Output.Value
+		prevOuts = append(prevOuts, PrevOutput{vin.Txid, vin.Vout, coin.Output})
+		coins = append(coins, coin)
+	}
+
+	if slashing {
+		// the stake is burned, not left as a fee
+		return 0, checkSlashing(tx, coins)
+	}
+
+	outputs := 0
+	for _, out := range tx.Vout {
+		if out.Value <= 0 {
+			return 0, fmt.Errorf("output values must be positive")
+		}
+		outputs += out.Value
+	}
+	if outputs > inputs {
+		return 0, fmt.Errorf("transaction %x spends %d but its inputs only hold %d", tx.ID, outputs, inputs)
+	}
+
+	if rules[DEPLOYMENT_PUBKEYHASH] {
+		if err := checkPubKeyHashes(tx, prevOuts); err != nil {
+			return 0, err
+		}
+	}
+	if !tx.Verify(PrevTXsFromOutputs(prevOuts)) {
+		return 0, fmt.Errorf("transaction %x is not (fully) signed", tx.ID)
+	}
+
+	return inputs - outputs, nil
+}
+
+// checkPubKeyHashes makes sure every input shows the public key the output
+// it spends is locked with, the owner of the stake of a stake output.
+// Signatures alone only prove the spender holds some key.
+func checkPubKeyHashes(tx *Transaction, prevOuts []PrevOutput) error {
+	for i := range tx.Vin {
+		vin := tx.WitnessedInput(i)
+		pubKeyHash := prevOuts[i].Output.PubKeyHash
+		if owner, ok := prevOuts[i].Output.StakeOwner(); ok {
+			pubKeyHash = owner
+		}
+
+		if !vin.UsesKey(pubKeyHash) {
+			return fmt.Errorf("input %d of transaction %x is not signed by the owner of %s", i, tx.ID, Outpoint(vin.Txid, vin.Vout))
+		}
+	}
+
+	return nil
+}
+
+// SendRawTransaction validates tx and mines it in a new block whose reward,
+// its fee included, goes to rewardAddress. There is no mempool so it is
+// confirmed right away.
+func (u UTXOSet) SendRawTransaction(tx *Transaction, rewardAddress string) (*Block, error) {
+	if err := u.CheckTransaction(tx); err != nil {
+		return nil, err
+	}
+
+	template, err := u.Blockchain.NewBlockTemplate([]*Transaction{tx}, rewardAddress)
+	if err != nil {
+		return nil, err
+	}
+	if err, rejected := template.Rejected[hex.EncodeToString(tx.ID)]; rejected {
+		return nil, err
+	}
+
+	return u.Blockchain.AddBlock(template.Transactions)
+}
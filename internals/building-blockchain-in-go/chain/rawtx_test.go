@@ -0,0 +1,113 @@
+package chain
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+func TestSignRawTransaction(t *testing.T) {
+	owner, ownerAddress := newWallets(t)
+	stranger, strangerAddress := newWallets(t)
+
+	prevTx := newCoinbase(t, ownerAddress, 1)
+	prevOuts := []PrevOutput{{prevTx.ID, 0, prevTx.Vout[0]}}
+
+	tx := NewRawTransaction(
+		[]TXInput{{prevTx.ID, 0, nil, nil}},
+		[]TXOutput{newOutput(t, 4, strangerAddress), newOutput(t, 6, ownerAddress)},
+	)
+
+	// only the owner of the spent output can sign it
+	complete, err := SignRawTransaction(tx, stranger, PrevTXsFromOutputs(prevOuts), SIGHASH_ALL)
+	assert.Nil(t, err)
+	assert.False(t, complete, "Stranger cannot sign")
+	assert.Nil(t, tx.Vin[0].Signature, "Input is left unsigned")
+
+	complete, err = SignRawTransaction(tx, owner, PrevTXsFromOutputs(prevOuts), SIGHASH_ALL)
+	assert.Nil(t, err)
+	assert.True(t, complete, "Owner signs the input")
+
+	// spending an output we know nothing about is an error, not a panic
+	_, err = SignRawTransaction(tx, owner, PrevTXsFromOutputs(nil), SIGHASH_ALL)
+	assert.NotNil(t, err)
+
+	// the signed transaction survives its hex encoding
+	decoded, err := DecodeRawTransaction(EncodeRawTransaction(tx))
+	assert.Nil(t, err)
+	assert.Equal(t, tx.ID, decoded.ID, "Transaction ID is preserved")
+	assert.True(t, decoded.Verify(PrevTXsFromOutputs(prevOuts)), "Decoded transaction is valid")
+}
+
+func TestSigHashTypes(t *testing.T) {
+	alice, aliceAddress := newWallets(t)
+	bob, bobAddress := newWallets(t)
+	_, charity := newWallets(t)
+
+	aliceTx := newCoinbase(t, aliceAddress, 1)
+	bobTx := newCoinbase(t, bobAddress, 2)
+	prevTXs := PrevTXsFromOutputs([]PrevOutput{{aliceTx.ID, 0, aliceTx.Vout[0]}, {bobTx.ID, 0, bobTx.Vout[0]}})
+	sign := func(tx *Transaction, wallets *wallet.Wallets, hashType SigHashType) bool {
+		complete, err := SignRawTransaction(tx, wallets, prevTXs, hashType)
+		assert.Nil(t, err)
+
+		return complete
+	}
+
+	// crowdfunding: each signs their own input only, others can join
+	newPledge := func() *Transaction {
+		return NewRawTransaction([]TXInput{{aliceTx.ID, 0, nil, nil}}, []TXOutput{newOutput(t, 15, charity)})
+	}
+	tx := newPledge()
+	assert.True(t, sign(tx, alice, SIGHASH_ALL|SIGHASH_ANYONECANPAY))
+	tx.Vin = append(tx.Vin, TXInput{bobTx.ID, 0, nil, nil})
+	assert.True(t, sign(tx, bob, SIGHASH_ALL|SIGHASH_ANYONECANPAY))
+	// but not change where the coins go
+	tx.Vout[0].Value = 20
+	assert.False(t, tx.Verify(prevTXs))
+
+	tx = newPledge()
+	assert.True(t, sign(tx, alice, SIGHASH_ALL))
+	tx.Vin = append(tx.Vin, TXInput{bobTx.ID, 0, nil, nil})
+	assert.False(t, sign(tx, bob, SIGHASH_ALL), "Alice signed the inputs too")
+
+	// the outputs are anyone's to choose
+	tx = newPledge()
+	assert.True(t, sign(tx, alice, SIGHASH_NONE))
+	tx.Vout[0] = newOutput(t, 10, bobAddress)
+	assert.True(t, tx.Verify(prevTXs))
+
+	// only the output of the same index is covered
+	tx = NewRawTransaction([]TXInput{{aliceTx.ID, 0, nil, nil}, {bobTx.ID, 0, nil, nil}}, []TXOutput{newOutput(t, 10, bobAddress)})
+	_, err := SignRawTransaction(tx, bob, prevTXs, SIGHASH_SINGLE)
+	assert.NotNil(t, err, "No output for the second input")
+	assert.False(t, sign(tx, alice, SIGHASH_SINGLE))
+	tx.Vout = append(tx.Vout, newOutput(t, 5, aliceAddress))
+	assert.True(t, sign(tx, bob, SIGHASH_SINGLE))
+	tx.Vout[0].Value = 8
+	assert.False(t, tx.Verify(prevTXs))
+
+	// unknown hash types are invalid, untyped signatures cover everything
+	tx = newPledge()
+	assert.True(t, sign(tx, alice, SIGHASH_ALL))
+	signature := tx.Vin[0].Signature
+	assert.Equal(t, TYPED_SIGNATURE_LEN, len(signature))
+	tx.Vin[0].Signature = append(signature[:2*POINT_SIZE:2*POINT_SIZE], 0x04)
+	assert.False(t, tx.Verify(prevTXs))
+
+	key := alice.Wallets[aliceAddress].PrivateKey
+	r, s, err := ecdsa.Sign(rand.Reader, &key, tx.legacySignatureHash(0, aliceTx.Vout[0].PubKeyHash))
+	assert.Nil(t, err)
+	tx.Vin[0].Signature = encodePoint(r, s)
+	assert.True(t, tx.Verify(prevTXs))
+
+	hashType, err := ParseSigHashType("single|anyonecanpay")
+	assert.Nil(t, err)
+	assert.Equal(t, SIGHASH_SINGLE|SIGHASH_ANYONECANPAY, hashType)
+	assert.Equal(t, "SINGLE|ANYONECANPAY", hashType.String())
+	_, err = ParseSigHashType("ANYONECANPAY")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+)
+
+// A signature used to cover the whole transaction, so nobody could change
+// anything once an input was signed. The hash type appended to the signature
+// tells which parts of the transaction it covers instead:
+//
+//   - SIGHASH_ALL: every input and output, the default
+//   - SIGHASH_NONE: the inputs but none of the outputs, which anyone may then
+//     change, the signer does not care where the coins go
+//   - SIGHASH_SINGLE: the inputs and the output of the same index as the
+//     signed input, the others may change
+//
+// and combined with either of them, SIGHASH_ANYONECANPAY only covers the
+// signed input: others can be added later, to crowdfund a fixed output for
+// instance.
+//
+// Signatures from before hash types are SIGHASH_ALL, and are told apart by
+// their length: a typed signature has r and s padded to POINT_SIZE bytes each,
+// and the hash type on a last byte.
+//
+// https://en.bitcoin.it/wiki/OP_CHECKSIG
+
+// SigHashType selects the parts of the transaction a signature covers
+type SigHashType byte
+
+const (
+	SIGHASH_ALL          SigHashType = 0x01
+	SIGHASH_NONE         SigHashType = 0x02
+	SIGHASH_SINGLE       SigHashType = 0x03
+	SIGHASH_ANYONECANPAY SigHashType = 0x80
+
+	// r and s, then the hash type
+	TYPED_SIGNATURE_LEN = 2*POINT_SIZE + 1
+)
+
+// base returns the hash type without its modifier
+func (t SigHashType) base() SigHashType {
+	return t &^ SIGHASH_ANYONECANPAY
+}
+
+// Valid tells whether the hash type is one of those known
+func (t SigHashType) Valid() bool {
+	base := t.base()
+
+	return base == SIGHASH_ALL || base == SIGHASH_NONE || base == SIGHASH_SINGLE
+}
+
+func (t SigHashType) String() string {
+	var name string
+
+	switch t.base() {
+	case SIGHASH_ALL:
+		name = "ALL"
+	case SIGHASH_NONE:
+		name = "NONE"
+	case SIGHASH_SINGLE:
+		name = "SINGLE"
+	default:
+		return fmt.Sprintf("unknown (%#x)", byte(t))
+	}
+	if t&SIGHASH_ANYONECANPAY != 0 {
+		name += "|ANYONECANPAY"
+	}
+
+	return name
+}
+
+// ParseSigHashType parses the name of a hash type, like ALL or
+// SINGLE|ANYONECANPAY
+func ParseSigHashType(name string) (SigHashType, error) {
+	for _, t := range []SigHashType{SIGHASH_ALL, SIGHASH_NONE, SIGHASH_SINGLE} {
+		for _, hashType := range []SigHashType{t, t | SIGHASH_ANYONECANPAY} {
+			if strings.EqualFold(name, hashType.String()) {
+				return hashType, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unknown sighash type %q, expected ALL, NONE or SINGLE, optionally followed by |ANYONECANPAY", name)
+}
+
+// splitSignature returns the signature of an input without its hash type,
+// and the hash type, SIGHASH_ALL for an untyped signature
+func splitSignature(signature []byte) ([]byte, SigHashType, bool) {
+	if len(signature) != TYPED_SIGNATURE_LEN {
+		return signature, SIGHASH_ALL, false
+	}
+
+	return signature[:2*POINT_SIZE], SigHashType(signature[2*POINT_SIZE]), true
+}
+
+// sigHashCopy returns the trimmed copy of the transaction covered by the
+// signature of input inID of hash type hashType, the input holding the
+// pubkey hash of the output it spends
+func (tx *Transaction) sigHashCopy(inID int, pubKeyHash []byte, hashType SigHashType) (Transaction, error) {
+	txCopy := tx.TrimmedCopy()
+	txCopy.Vin[inID].PubKey = pubKeyHash
+
+	switch hashType.base() {
+	case SIGHASH_NONE:
+		txCopy.Vout = nil
+	case SIGHASH_SINGLE:
+		if inID >= len(txCopy.Vout) {
+			return txCopy, fmt.Errorf("input %d has no output to sign with SIGHASH_SINGLE", inID)
+		}
+		// the outputs before keep their place, but not their content
+		for i := 0; i < inID; i++ {
+			txCopy.Vout[i] = TXOutput{-1, nil}
+		}
+		txCopy.Vout = txCopy.Vout[:inID+1]
+	}
+
+	if hashType&SIGHASH_ANYONECANPAY != 0 {
+		txCopy.Vin = []TXInput{txCopy.Vin[inID]}
+	}
+
+	return txCopy, nil
+}
+
+// SignatureHash returns what the signature of input inID of hash type
+// hashType signs: the hash of the parts of the transaction it covers, and of
+// the hash type itself
+func (tx *Transaction) SignatureHash(inID int, pubKeyHash []byte, hashType SigHashType) ([]byte, error) {
+	if !hashType.Valid() {
+		return nil, fmt.Errorf("unknown sighash type %#x", byte(hashType))
+	}
+
+	txCopy, err := tx.sigHashCopy(inID, pubKeyHash, hashType)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(append(txCopy.Hash(), byte(hashType)))
+
+	return hash[:], nil
+}
+
+// legacySignatureHash is what the untyped signature of input inID signs, the
+// hash of the whole trimmed copy
+func (tx *Transaction) legacySignatureHash(inID int, pubKeyHash []byte) []byte {
+	txCopy, _ := tx.sigHashCopy(inID, pubKeyHash, SIGHASH_ALL)
+
+	return txCopy.Hash()
+}
@@ -0,0 +1,556 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// The UTXO set (the chainstate bucket) is all a node needs to validate new
+// blocks. gettxoutsetinfo summarizes it along with a hash of its content, so
+// two nodes can quickly compare their sets at the same height.
+//
+// dumptxoutset writes the set to a snapshot file, that a new node loads
+// instead of replaying the whole chain (Bitcoin Core calls it assumeutxo).
+// The snapshot carries the headers of the chain so the node can still walk it
+// and check its proof of work, as if all its blocks had been pruned. The
+// history can be verified afterwards, while the node is already in use, by
+// importing a bootstrap file: its blocks are replayed in a separate in memory
+// UTXO set, whose hash must match the snapshot once its height is reached.
+//
+// A snapshot is framed like bootstrap files, the parameters of the chain
+// first, then with SNAPSHOT_MAGIC:
+//
+//   TxOutSetInfo   the summary of the set
+//   headers        the BlockHeader of each block, genesis first
+//   outputs        per transaction, its 32 bytes id then its TXOutputs, in
+//                  chainstate order
+
+var SNAPSHOT_MAGIC = []byte{'u', 't', 'x', 'o'}
+
+// TxOutSetInfo summarizes the UTXO set at a given block
+type TxOutSetInfo struct {
+	Height    int
+	BestBlock []byte
+	// Transactions is the number of transactions with unspent outputs
+	Transactions int
+	TxOuts       int
+	// TotalAmount is the sum of the unspent outputs, that is all the coins
+	// in circulation
+	TotalAmount int
+	// Hash commits to the content of the set
+	Hash []byte
+}
+
+// Serialize serializes the summary
+func (info TxOutSetInfo) Serialize() []byte {
+	var result bytes.Buffer
+
+	encoder := gob.NewEncoder(&result)
+	// encoding to memory only fails on unsupported types
+	if err := encoder.Encode(info); err != nil {
+		panic(err)
+	}
+
+	return result.Bytes()
+}
+
+// DeserializeTxOutSetInfo deserializes a summary
+func DeserializeTxOutSetInfo(d []byte) (TxOutSetInfo, error) {
+	var info TxOutSetInfo
+
+	decoder := gob.NewDecoder(bytes.NewReader(d))
+	err := decoder.Decode(&info)
+
+	return info, err
+}
+
+// txOutSetHasher accumulates the summary of the transactions added. They
+// must come in chainstate (byte) order for the hash to be deterministic.
+type txOutSetHasher struct {
+	info TxOutSetInfo
+	hash hash.Hash
+}
+
+func newTxOutSetHasher() *txOutSetHasher {
+	return &txOutSetHasher{hash: sha256.New()}
+}
+
+func (h *txOutSetHasher) add(txid []byte, outs TXOutputs) {
+	h.info.Transactions++
+
+	for i, out := range outs.Outputs {
+		h.info.TxOuts++
+		h.info.TotalAmount += out.Value
+
+		// gob does not promise a stable encoding, so we hash a fixed layout:
+		// txid | vout | height*2 + coinbase | value | length of the pubkey
+		// hash | pubkey hash
+		field := make([]byte, 8)
+		h.hash.Write(txid)
+		binary.BigEndian.PutUint32(field, uint32(outs.Indexes[i]))
+		h.hash.Write(field[:4])
+		code := outs.Height * 2
+		if outs.Coinbase {
+			code++
+		}
+		binary.BigEndian.PutUint32(field, uint32(code))
+		h.hash.Write(field[:4])
+		binary.BigEndian.PutUint64(field, uint64(out.Value))
+		h.hash.Write(field)
+		binary.BigEndian.PutUint32(field, uint32(len(out.PubKeyHash)))
+		h.hash.Write(field[:4])
+		h.hash.Write(out.PubKeyHash)
+	}
+}
+
+func (h *txOutSetHasher) sum(height int, bestBlock []byte) TxOutSetInfo {
+	info := h.info
+	info.Height = height
+	info.BestBlock = bestBlock
+	info.Hash = h.hash.Sum(nil)
+
+	return info
+}
+
+// Info summarizes the UTXO set at the tip of the chain
+func (u UTXOSet) Info() (TxOutSetInfo, error) {
+	var info TxOutSetInfo
+
+	err := u.Blockchain.db.View(func(tx storage.Tx) error {
+		var err error
+		info, err = txOutSetInfo(tx)
+
+		return err
+	})
+
+	return info, err
+}
+
+// txOutSetInfo hashes the chainstate bucket within an ongoing
+// transaction, so the set and the tip it is reported at are consistent
+func txOutSetInfo(tx storage.Tx) (TxOutSetInfo, error) {
+	blocks := tx.Bucket([]byte(BLOCKS_BUCKET))
+	chainstate := tx.Bucket([]byte(UTXO_BUCKET))
+	if blocks == nil || chainstate == nil {
+		return TxOutSetInfo{}, errors.New("no blockchain found, create one first")
+	}
+
+	tip := append([]byte{}, blocks.Get([]byte("l"))...)
+	block, err := getBlock(tx, tip)
+	if err != nil && err != ErrBlockPruned {
+		return TxOutSetInfo{}, err
+	}
+
+	hasher := newTxOutSetHasher()
+	c := chainstate.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		outs, err := DeserializeOutputs(v)
+		if err != nil {
+			return TxOutSetInfo{}, err
+		}
+		hasher.add(k, outs)
+	}
+
+	return hasher.sum(block.Height, tip), nil
+}
+
+// DumpTxOutSet writes a snapshot of the UTXO set at the tip to w
+func DumpTxOutSet(bc *Blockchain, w io.Writer) (TxOutSetInfo, error) {
+	var info TxOutSetInfo
+	buf := bufio.NewWriter(w)
+
+	// a single read transaction, in case the daemon connects a block
+	// meanwhile
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+
+		info, err = txOutSetInfo(tx)
+		if err != nil {
+			return err
+		}
+		if err := writeFrame(buf, PARAMS_MAGIC, bc.Params().Serialize()); err != nil {
+			return err
+		}
+		if err := writeFrame(buf, SNAPSHOT_MAGIC, info.Serialize()); err != nil {
+			return err
+		}
+
+		// headers are linked from the tip, collect them to write genesis first
+		var headers []BlockHeader
+		for hash := info.BestBlock; len(hash) > 0; {
+			block, err := getBlock(tx, hash)
+			if err != nil && err != ErrBlockPruned {
+				return err
+			}
+
+			headers = append(headers, NewBlockHeader(block))
+			hash = block.PrevBlockHash
+		}
+		for i := len(headers) - 1; i >= 0; i-- {
+			if err := writeFrame(buf, SNAPSHOT_MAGIC, headers[i].Serialize()); err != nil {
+				return err
+			}
+		}
+
+		c := tx.Bucket([]byte(UTXO_BUCKET)).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if err := writeFrame(buf, SNAPSHOT_MAGIC, append(append([]byte{}, k...), v...)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return info, err
+	}
+
+	return info, buf.Flush()
+}
+
+// DumpTxOutSetFile dumps the UTXO set to a new file, an existing one is never
+// overwritten
+func DumpTxOutSetFile(bc *Blockchain, path string) (TxOutSetInfo, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return TxOutSetInfo{}, err
+	}
+
+	info, err := DumpTxOutSet(bc, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+
+	return info, err
+}
+
+// LoadTxOutSet starts an empty chain from a snapshot read from r, with the
+// parameters of the snapshot. The headers must form a valid chain up to the
+// snapshot block, and the outputs hash to what the snapshot claims.
+func LoadTxOutSet(bc *Blockchain, r io.Reader) (TxOutSetInfo, error) {
+	if bc.Tip() != nil {
+		return TxOutSetInfo{}, errors.New("a snapshot can only be loaded in an empty chain")
+	}
+
+	buf := bufio.NewReader(r)
+	params, err := readParams(buf)
+	if err != nil {
+		return TxOutSetInfo{}, fmt.Errorf("not a snapshot: %s", err)
+	}
+	if err := bc.loadParams(params); err != nil {
+		return TxOutSetInfo{}, err
+	}
+
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	if bc.tip != nil {
+		return TxOutSetInfo{}, errors.New("a snapshot can only be loaded in an empty chain")
+	}
+
+	payload, err := readFrame(buf, SNAPSHOT_MAGIC)
+	if err != nil {
+		return TxOutSetInfo{}, fmt.Errorf("not a snapshot: %s", err)
+	}
+	info, err := DeserializeTxOutSetInfo(payload)
+	if err != nil {
+		return info, fmt.Errorf("not a snapshot: %s", err)
+	}
+
+	err = bc.db.Update(func(tx storage.Tx) error {
+		buckets := make(map[string]storage.Bucket)
+		for _, name := range []string{BLOCKS_BUCKET, HEADERS_BUCKET, UTXO_BUCKET, META_BUCKET} {
+			b, err := tx.CreateBucketIfNotExists([]byte(name))
+			if err != nil {
+				return err
+			}
+			buckets[name] = b
+		}
+
+		var prev []byte
+		for height := 0; height <= info.Height; height++ {
+			payload, err := readFrame(buf, SNAPSHOT_MAGIC)
+			if err != nil {
+				return fmt.Errorf("header %d: %s", height, err)
+			}
+
+			header, err := DeserializeBlockHeader(payload)
+			if err != nil {
+				return fmt.Errorf("header %d: %s", height, err)
+			}
+			if err := checkHeader(bc.Engine(), header, prev, height); err != nil {
+				return err
+			}
+			if err := buckets[HEADERS_BUCKET].Put(header.Hash, payload); err != nil {
+				return err
+			}
+			prev = header.Hash
+		}
+		if !bytes.Equal(prev, info.BestBlock) {
+			return fmt.Errorf("headers lead to %x instead of the snapshot block %x", prev, info.BestBlock)
+		}
+
+		for i := 0; i < info.Transactions; i++ {
+			payload, err := readFrame(buf, SNAPSHOT_MAGIC)
+			if err != nil {
+				return fmt.Errorf("outputs %d: %s", i, err)
+			}
+			if len(payload) <= 32 {
+				return fmt.Errorf("outputs %d: too short", i)
+			}
+
+			if err := buckets[UTXO_BUCKET].Put(payload[:32], payload[32:]); err != nil {
+				return err
+			}
+		}
+
+		if err := buckets[BLOCKS_BUCKET].Put([]byte("l"), info.BestBlock); err != nil {
+			return err
+		}
+
+		loaded, err := txOutSetInfo(tx)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(loaded.Hash, info.Hash) {
+			return fmt.Errorf("UTXO set hash %x does not match the snapshot %x", loaded.Hash, info.Hash)
+		}
+
+		// remembered until the history is verified
+		return buckets[META_BUCKET].Put([]byte("snapshot"), info.Serialize())
+	})
+	if err != nil {
+		return info, err
+	}
+
+	bc.setTip(info.BestBlock)
+
+	return info, nil
+}
+
+// checkHeader makes sure a header extends prev and is sealed
+func checkHeader(engine ConsensusEngine, header BlockHeader, prev []byte, height int) error {
+	if header.Height != height || !bytes.Equal(header.PrevBlockHash, prev) {
+		return fmt.Errorf("header %x does not extend %x at height %d", header.Hash, prev, height)
+	}
+
+	if err := VerifyHeader(engine, header.Block()); err != nil {
+		return fmt.Errorf("header %x: %s", header.Hash, err)
+	}
+
+	return nil
+}
+
+// Snapshot returns the snapshot the chain was loaded from, or nil when it was
+// built from the genesis block or its history was verified since
+func (bc *Blockchain) Snapshot() (*TxOutSetInfo, error) {
+	var snapshot *TxOutSetInfo
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(META_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		data := b.Get([]byte("snapshot"))
+		if data == nil {
+			return nil
+		}
+
+		info, err := DeserializeTxOutSetInfo(data)
+		snapshot = &info
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// snapshotValidator replays the history of a chain loaded from a snapshot
+type snapshotValidator struct {
+	bc       *Blockchain
+	snapshot *TxOutSetInfo
+	// the UTXO set rebuilt from the blocks connected so far
+	utxo map[string]TXOutputs
+	next int
+}
+
+// newSnapshotValidator returns nil when there is nothing to verify
+func newSnapshotValidator(bc *Blockchain) (*snapshotValidator, error) {
+	snapshot, err := bc.Snapshot()
+	if snapshot == nil || err != nil {
+		return nil, err
+	}
+
+	return &snapshotValidator{bc, snapshot, make(map[string]TXOutputs), 0}, nil
+}
+
+func (v *snapshotValidator) findCoin(txid []byte, vout int) (Coin, bool, error) {
+	coin, found := v.utxo[hex.EncodeToString(txid)].coin(vout)
+
+	return coin, found, nil
+}
+
+// connect validates the next block of the history, and once the snapshot
+// block is reached compares the rebuilt UTXO set to the snapshot. It returns
+// true when done.
+func (v *snapshotValidator) connect(block *Block) (bool, error) {
+	if block.Height != v.next {
+		return false, fmt.Errorf("block %x: expected height %d, got %d", block.Hash, v.next, block.Height)
+	}
+
+	// the headers we got with the snapshot tell which blocks are expected
+	header, err := v.bc.GetBlock(block.Hash)
+	if err != nil && err != ErrBlockPruned {
+		return false, fmt.Errorf("block %x is not part of the snapshot chain", block.Hash)
+	}
+	if header.Height != block.Height || VerifyHeader(v.bc.Engine(), block) != nil {
+		return false, fmt.Errorf("block %x does not match the header of height %d", block.Hash, block.Height)
+	}
+	if err := v.checkProposer(block); err != nil {
+		return false, fmt.Errorf("block %x: %s", block.Hash, err)
+	}
+
+	var rules Rules
+	err = v.bc.db.View(func(tx storage.Tx) error {
+		if err := checkMedianTimePast(tx, block); err != nil {
+			return err
+		}
+		_, rules, err = v.bc.versionBits(tx, block.PrevBlockHash)
+
+		return err
+	})
+	if err == nil {
+		err = checkFutureTime(block)
+	}
+	if err != nil {
+		return false, fmt.Errorf("block %x: %s", block.Hash, err)
+	}
+
+	if err := checkBlockLimits(block, v.bc.Params()); err != nil {
+		return false, fmt.Errorf("block %x: %w", block.Hash, err)
+	}
+	if err := checkTransactions(block, v.findCoin, v.bc.Params(), rules); err != nil {
+		return false, fmt.Errorf("block %x: %s", block.Hash, err)
+	}
+	v.apply(block)
+	v.next++
+
+	if v.bc.PruneDepth() == 0 {
+		if err := v.bc.restoreBlock(block); err != nil {
+			return false, err
+		}
+	}
+
+	if block.Height < v.snapshot.Height {
+		return false, nil
+	}
+
+	if hash := v.hash(); !bytes.Equal(hash, v.snapshot.Hash) {
+		return true, fmt.Errorf("history leads to UTXO set hash %x, the snapshot is invalid (%x)", hash, v.snapshot.Hash)
+	}
+
+	return true, v.bc.db.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte(META_BUCKET)).Delete([]byte("snapshot"))
+	})
+}
+
+func (v *snapshotValidator) apply(block *Block) {
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase() {
+			for _, vin := range tx.Vin {
+				txID := hex.EncodeToString(vin.Txid)
+				outs := v.utxo[txID]
+
+				updatedOuts := outs.without(vin.Vout)
+
+				if len(updatedOuts.Outputs) == 0 {
+					delete(v.utxo, txID)
+				} else {
+					v.utxo[txID] = updatedOuts
+				}
+			}
+		}
+
+		// slashing transactions have no outputs
+		if len(tx.Vout) > 0 {
+			v.utxo[hex.EncodeToString(tx.ID)] = newTXOutputs(tx, block.Height)
+		}
+	}
+}
+
+// checkProposer checks the block of a proof of stake was proposed by the
+// validator drawn from the stakes of the history replayed so far
+func (v *snapshotValidator) checkProposer(block *Block) error {
+	if _, ok := v.bc.Engine().(*ProofOfStake); !ok || block.Height == 0 {
+		return nil
+	}
+
+	byOwner := make(map[string]int)
+	for _, outs := range v.utxo {
+		addStakes(byOwner, outs)
+	}
+	pubKeyHash, err := newStakes(byOwner).Proposer(block.PrevBlockHash, block.Height)
+	if err != nil {
+		return err
+	}
+
+	return verifyProposer(block, wallet.PubKeyHashToAddress(pubKeyHash))
+}
+
+func (v *snapshotValidator) hash() []byte {
+	return hashUTXO(v.utxo)
+}
+
+// hashUTXO hashes a UTXO set held in memory like txOutSetInfo, hex
+// preserving the byte order of the ids
+func hashUTXO(utxo map[string]TXOutputs) []byte {
+	hasher := newTxOutSetHasher()
+	for _, txID := range sortedTxIDs(utxo) {
+		// the ids were hex encoded by us
+		key, _ := hex.DecodeString(txID)
+		hasher.add(key, utxo[txID])
+	}
+
+	return hasher.hash.Sum(nil)
+}
+
+func sortedTxIDs(utxo map[string]TXOutputs) []string {
+	var txIDs []string
+	for txID := range utxo {
+		txIDs = append(txIDs, txID)
+	}
+	sort.Strings(txIDs)
+
+	return txIDs
+}
+
+// restoreBlock stores the full block back in place of its header
+func (bc *Blockchain) restoreBlock(block *Block) error {
+	return bc.db.Update(func(tx storage.Tx) error {
+		if err := tx.Bucket([]byte(BLOCKS_BUCKET)).Put(block.Hash, block.Serialize()); err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(HEADERS_BUCKET)).Delete(block.Hash)
+	})
+}
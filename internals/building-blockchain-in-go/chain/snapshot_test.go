@@ -0,0 +1,105 @@
+package chain
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// newWallets returns in memory wallets holding a single new address
+func newWallets(t *testing.T) (*wallet.Wallets, string) {
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	address, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+
+	return wallets, address
+}
+
+// newOutput is NewTXOutput for addresses known to be valid
+func newOutput(t *testing.T, value int, address string) TXOutput {
+	out, err := NewTXOutput(value, address)
+	assert.Nil(t, err)
+
+	return *out
+}
+
+// newCoinbase is NewCoinbaseTX for addresses known to be valid, paying the
+// initial subsidy
+func newCoinbase(t *testing.T, address string, height int) *Transaction {
+	tx, err := NewCoinbaseTX(address, "", height, INITIAL_SUBSIDY)
+	assert.Nil(t, err)
+
+	return tx
+}
+
+func TestTxOutSetSnapshot(t *testing.T) {
+	_, address := newWallets(t)
+
+	var snapshot, bootstrap bytes.Buffer
+	var info TxOutSetInfo
+
+	// the original node
+	func() {
+		bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+		assert.Nil(t, err)
+		defer bc.Close()
+		assert.Nil(t, UTXOSet{bc}.Reindex())
+		_, err = bc.AddBlock([]*Transaction{newCoinbase(t, address, 1)})
+		assert.Nil(t, err)
+
+		info, err = DumpTxOutSet(bc, &snapshot)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, info.Height)
+		assert.Equal(t, 2*INITIAL_SUBSIDY, info.TotalAmount)
+		assert.Nil(t, ExportChain(bc, &bootstrap, func(int, int) {}))
+	}()
+
+	// a new node, from the snapshot
+	func() {
+		bc, err := OpenBlockchain(storage.NewMemory())
+		assert.Nil(t, err)
+		defer bc.Close()
+
+		loaded, err := LoadTxOutSet(bc, bytes.NewReader(snapshot.Bytes()))
+		assert.Nil(t, err)
+		assert.Equal(t, info, loaded)
+		current, err := UTXOSet{bc}.Info()
+		assert.Nil(t, err)
+		assert.Equal(t, info, current, "Same UTXO set as the original node")
+		pending, _ := bc.Snapshot()
+		assert.NotNil(t, pending, "History is not verified yet")
+
+		_, err = ImportChain(bc, &bootstrap, func(*Block) {})
+		assert.Nil(t, err)
+		pending, _ = bc.Snapshot()
+		assert.Nil(t, pending, "History matches the snapshot")
+	}()
+
+	// a snapshot claiming another hash than its content is refused
+	tampered := info
+	tampered.Hash = make([]byte, 32)
+	var forged bytes.Buffer
+	r := bufio.NewReader(bytes.NewReader(snapshot.Bytes()))
+	params, err := readFrame(r, PARAMS_MAGIC)
+	assert.Nil(t, err)
+	_ = writeFrame(&forged, PARAMS_MAGIC, params)
+	_, err = readFrame(r, SNAPSHOT_MAGIC)
+	assert.Nil(t, err)
+	_ = writeFrame(&forged, SNAPSHOT_MAGIC, tampered.Serialize())
+	_, _ = r.WriteTo(&forged)
+
+	func() {
+		bc, err := OpenBlockchain(storage.NewMemory())
+		assert.Nil(t, err)
+		defer bc.Close()
+
+		_, err = LoadTxOutSet(bc, &forged)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "does not match the snapshot")
+		assert.Nil(t, bc.Tip(), "Chain is left empty")
+	}()
+}
@@ -0,0 +1,228 @@
+package chain
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// A miner fills its next block with the transactions paying it the most for
+// the space they take: their fee rate, the fee per byte. The template builder
+// picks from candidate transactions (there is no mempool, the caller brings
+// them) in decreasing fee rate order, as long as they fit in the block limits.
+//
+// A candidate may spend the outputs of another one, it is then only picked
+// once its parent is in the block. Candidates that are invalid, conflict with
+// a transaction already picked or do not fit are left out.
+
+// BlockTemplate is a block ready to be mined on top of PrevBlockHash
+type BlockTemplate struct {
+	// signalling the deployments started or locked in
+	Version       int
+	Height        int
+	PrevBlockHash []byte
+	// the block must be timestamped from MinTime, Timestamp is our clock
+	MinTime   int64
+	Timestamp int64
+	// the coinbase, paying the subsidy and the fees, then the transactions
+	Transactions []*Transaction
+	// the fee of each transaction, 0 for the coinbase, and their total
+	TxFees []int
+	Fees   int
+	// of the candidate block
+	Size   int
+	SigOps int
+	// why the candidates left out were, by hex transaction id
+	Rejected map[string]error
+	// the deployments the block enforces
+	Rules Rules
+}
+
+// templateTx is a candidate transaction, once its fee is known
+type templateTx struct {
+	tx   *Transaction
+	fee  int
+	size int
+}
+
+// templateView is the UTXO set as it is once the transactions picked so far
+// are connected
+type templateView struct {
+	utxo    UTXOSet
+	height  int
+	created map[string]Coin
+	spent   map[string]bool
+}
+
+func (v *templateView) findCoin(txid []byte, vout int) (Coin, bool, error) {
+	key := Outpoint(txid, vout)
+	if v.spent[key] {
+		return Coin{}, false, nil
+	}
+	if coin, ok := v.created[key]; ok {
+		return coin, true, nil
+	}
+
+	return v.utxo.FindCoin(txid, vout)
+}
+
+// checkConflicts makes sure tx spends none of the outputs already spent
+func (v *templateView) checkConflicts(tx *Transaction) error {
+	for _, vin := range tx.Vin {
+		if v.spent[Outpoint(vin.Txid, vin.Vout)] {
+			return fmt.Errorf("output %s is spent by another transaction of the block", Outpoint(vin.Txid, vin.Vout))
+		}
+	}
+
+	return nil
+}
+
+func (v *templateView) connect(tx *Transaction) {
+	for _, vin := range tx.Vin {
+		v.spent[Outpoint(vin.Txid, vin.Vout)] = true
+	}
+	for i, out := range tx.Vout {
+		v.created[Outpoint(tx.ID, i)] = Coin{out, v.height, false}
+	}
+}
+
+// NewBlockTemplate builds the next block out of the candidates, its coinbase
+// paying rewardAddress
+func (bc *Blockchain) NewBlockTemplate(candidates []*Transaction, rewardAddress string) (*BlockTemplate, error) {
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	mtp, err := bc.MedianTimePast()
+	if err != nil {
+		return nil, err
+	}
+	version, rules, err := bc.nextVersionBits()
+	if err != nil {
+		return nil, err
+	}
+	params := bc.Params()
+
+	template := &BlockTemplate{
+		Version:       version,
+		Height:        bestHeight + 1,
+		PrevBlockHash: bc.Tip(),
+		MinTime:       mtp + 1,
+		Timestamp:     nextTimestamp(mtp),
+		TxFees:        []int{0},
+		Rejected:      make(map[string]error),
+		Rules:         rules,
+	}
+	view := &templateView{UTXOSet{bc}, template.Height, make(map[string]Coin), make(map[string]bool)}
+
+	// the coinbase only grows by the few bytes of the fees, which the size of
+	// the transactions taken on their own more than makes up for: each
+	// carries the gob description of its types, only written once per block
+	coinbase, err := NewCoinbaseTX(rewardAddress, "", template.Height, params.Subsidy(template.Height))
+	if err != nil {
+		return nil, err
+	}
+	// leave room for the witness commitment
+	coinbase = coinbase.WithWitnessCommitment(make([]byte, sha256.Size))
+	template.Size = candidateBlock([]*Transaction{coinbase}, template.PrevBlockHash, template.Height).Size()
+
+	picked := make(map[string]bool)
+	// a pass may pick the parents of transactions left out earlier in it
+	for progress := true; progress; {
+		progress = false
+
+		// the fees are only known once the spent outputs are
+		var priced []templateTx
+		for _, tx := range candidates {
+			txID := hex.EncodeToString(tx.ID)
+			if picked[txID] {
+				continue
+			}
+
+			fee, err := checkTransaction(tx, view.findCoin, template.Height, params, rules)
+			if err != nil {
+				template.Rejected[txID] = err
+				continue
+			}
+			priced = append(priced, templateTx{tx, fee, tx.Size()})
+		}
+		sort.SliceStable(priced, func(i, j int) bool {
+			// fee_i / size_i > fee_j / size_j, without rounding
+			return priced[i].fee*priced[j].size > priced[j].fee*priced[i].size
+		})
+
+		for _, candidate := range priced {
+			txID := hex.EncodeToString(candidate.tx.ID)
+
+			err := view.checkConflicts(candidate.tx)
+			if err == nil {
+				err = template.fits(candidate, params)
+			}
+			if err != nil {
+				template.Rejected[txID] = err
+				continue
+			}
+
+			view.connect(candidate.tx)
+			template.Transactions = append(template.Transactions, candidate.tx)
+			template.TxFees = append(template.TxFees, candidate.fee)
+			template.Fees += candidate.fee
+			template.Size += candidate.size
+			template.SigOps += candidate.tx.SigOps()
+			delete(template.Rejected, txID)
+			picked[txID] = true
+			progress = true
+		}
+	}
+
+	coinbase, err = NewCoinbaseTX(rewardAddress, "", template.Height, params.Subsidy(template.Height)+template.Fees)
+	if err != nil {
+		return nil, err
+	}
+	template.Transactions = CommitWitnesses(append([]*Transaction{coinbase}, template.Transactions...))
+
+	return template, nil
+}
+
+// CheckPendingTransaction checks tx for the next block, after the pending
+// transactions (the valid ones) that it may spend
+func (bc *Blockchain) CheckPendingTransaction(pending []*Transaction, tx *Transaction) error {
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		return err
+	}
+	_, rules, err := bc.nextVersionBits()
+	if err != nil {
+		return err
+	}
+	params := bc.Params()
+
+	view := &templateView{UTXOSet{bc}, bestHeight + 1, make(map[string]Coin), make(map[string]bool)}
+	for _, pendingTx := range pending {
+		if _, err := checkTransaction(pendingTx, view.findCoin, view.height, params, rules); err == nil {
+			view.connect(pendingTx)
+		}
+	}
+
+	_, err = checkTransaction(tx, view.findCoin, view.height, params, rules)
+
+	return err
+}
+
+// fits tells whether the candidate can be added to the template without
+// exceeding the limits of a block
+func (t *BlockTemplate) fits(candidate templateTx, params Params) error {
+	// the coinbase is added last
+	if n := len(t.Transactions) + 2; n > params.MaxBlockTxs {
+		return &BlockLimitError{"transactions", n, params.MaxBlockTxs}
+	}
+	if sigOps := t.SigOps + candidate.tx.SigOps(); sigOps > params.MaxBlockSigOps {
+		return &BlockLimitError{"sigops", sigOps, params.MaxBlockSigOps}
+	}
+	if size := t.Size + candidate.size; size > params.MaxBlockSize {
+		return &BlockLimitError{"size", size, params.MaxBlockSize}
+	}
+
+	return nil
+}
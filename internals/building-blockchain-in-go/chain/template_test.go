@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// newSignedTx spends the outputs of prevTx to an output of value
+func newSignedTx(t *testing.T, wallets *wallet.Wallets, address string, prevTx *Transaction, value int, vouts ...int) *Transaction {
+	var inputs []TXInput
+	var prevOuts []PrevOutput
+	for _, vout := range vouts {
+		inputs = append(inputs, TXInput{prevTx.ID, vout, nil, nil})
+		prevOuts = append(prevOuts, PrevOutput{prevTx.ID, vout, prevTx.Vout[vout]})
+	}
+
+	tx := NewRawTransaction(inputs, []TXOutput{newOutput(t, value, address)})
+	complete, err := SignRawTransaction(tx, wallets, PrevTXsFromOutputs(prevOuts), SIGHASH_ALL)
+	assert.Nil(t, err)
+	assert.True(t, complete)
+
+	return tx
+}
+
+func TestBlockTemplate(t *testing.T) {
+	wallets, address := newWallets(t)
+	params := DefaultParams
+	params.MaxBlockTxs = 3
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	UTXOSet := UTXOSet{bc}
+	assert.Nil(t, UTXOSet.Reindex())
+
+	// split the genesis coinbase in 3 outputs of 3, the fee going to the miner
+	genesis, _ := bc.GetBlock(bc.Tip())
+	coinbase := genesis.Transactions[0]
+	split := NewRawTransaction(
+		[]TXInput{{coinbase.ID, 0, nil, nil}},
+		[]TXOutput{newOutput(t, 3, address), newOutput(t, 3, address), newOutput(t, 3, address)},
+	)
+	_, err = SignRawTransaction(split, wallets, PrevTXsFromOutputs([]PrevOutput{{coinbase.ID, 0, coinbase.Vout[0]}}), SIGHASH_ALL)
+	assert.Nil(t, err)
+	block, err := UTXOSet.SendRawTransaction(split, address)
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY+1, block.Transactions[0].Vout[0].Value, "Fee is paid to the miner")
+
+	low := newSignedTx(t, wallets, address, split, 2, 0)
+	high := newSignedTx(t, wallets, address, split, 1, 1)
+	conflict := newSignedTx(t, wallets, address, split, 2, 1)
+	child := newSignedTx(t, wallets, address, high, 1, 0)
+
+	template, err := bc.NewBlockTemplate([]*Transaction{child, low, conflict, high}, address)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, template.Height)
+	assert.Equal(t, []*Transaction{high, low}, template.Transactions[1:], "Best fee rate first")
+	assert.Equal(t, 3, template.Fees)
+	assert.Equal(t, []int{0, 2, 1}, template.TxFees)
+	assert.Len(t, template.Rejected, 2)
+	var limitErr *BlockLimitError
+	assert.True(t, errors.As(template.Rejected[hex.EncodeToString(child.ID)], &limitErr))
+	assert.Equal(t, "transactions", limitErr.Limit)
+	assert.NotNil(t, template.Rejected[hex.EncodeToString(conflict.ID)])
+
+	// the child is valid once its parent is, whether pending or in a block
+	assert.NotNil(t, bc.CheckPendingTransaction(nil, child))
+	assert.Nil(t, bc.CheckPendingTransaction([]*Transaction{high}, child))
+
+	block, err = bc.AddBlock(template.Transactions)
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY+3, block.Transactions[0].Vout[0].Value)
+	assert.True(t, template.Size >= block.Size(), "Template size is an upper bound")
+
+	// the parent is in, the child can go
+	template, err = bc.NewBlockTemplate([]*Transaction{child}, address)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Transaction{child}, template.Transactions[1:])
+
+	// blocks over the limits are refused, naming the limit
+	reward, err := bc.NewCoinbase(address)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{
+		reward, child, newSignedTx(t, wallets, address, split, 3, 2), newSignedTx(t, wallets, address, low, 2, 0),
+	})
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "transactions", limitErr.Limit)
+
+	params.MaxBlockSize = 1000
+	err = checkBlockLimits(block, params)
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "size", limitErr.Limit)
+	assert.Contains(t, err.Error(), "size limit")
+}
@@ -0,0 +1,161 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xav-b/blockchain/storage"
+)
+
+// Miners pick the timestamp of their blocks, so it cannot be trusted to the
+// second, but it must stay close to the actual time. Like Bitcoin, a block is
+// only valid if its timestamp is:
+//
+//   - after the median time past (MTP): the median timestamp of the 11
+//     blocks before it. A single miner cannot drag it back, and it only
+//     moves forward, unlike the timestamps themselves.
+//   - no more than 2 hours ahead of the network time, our clock adjusted by
+//     the median offset of the peers' clocks. A block from the future is
+//     refused for now, it may be valid later.
+//
+// Anything depending on the time in the consensus rules, like difficulty
+// retargeting or time locks, should use the MTP of the chain rather than the
+// timestamp of a block. Neither exists yet: the difficulty is fixed
+// (Params.PowBits) and transactions have no lock time.
+
+const (
+	MEDIAN_TIME_SPAN      = 11
+	MAX_FUTURE_BLOCK_TIME = 2 * 60 * 60
+	// peer offsets beyond that suggest our clock is wrong, bitcoind then
+	// leaves it alone and warns
+	MAX_TIME_ADJUSTMENT = 70 * 60
+	// bitcoind needs 5 samples before adjusting the time, and keeps 200
+	MIN_TIME_SAMPLES = 5
+	MAX_TIME_SAMPLES = 200
+)
+
+// timeSamples are the offsets between the clocks of the peers and ours
+var timeSamples struct {
+	sync.Mutex
+	offsets []int64
+}
+
+// AddTimeSample records the offset, in seconds, between the clock of a peer
+// and ours
+func AddTimeSample(offset int64) {
+	timeSamples.Lock()
+	defer timeSamples.Unlock()
+
+	if len(timeSamples.offsets) < MAX_TIME_SAMPLES {
+		timeSamples.offsets = append(timeSamples.offsets, offset)
+	}
+}
+
+// TimeOffset returns the adjustment of our clock to the network time
+func TimeOffset() int64 {
+	timeSamples.Lock()
+	defer timeSamples.Unlock()
+
+	if len(timeSamples.offsets) < MIN_TIME_SAMPLES {
+		return 0
+	}
+
+	offset := median(timeSamples.offsets)
+	if offset > MAX_TIME_ADJUSTMENT || offset < -MAX_TIME_ADJUSTMENT {
+		return 0
+	}
+
+	return offset
+}
+
+// AdjustedTime returns the network time, as a Unix timestamp
+func AdjustedTime() int64 {
+	return time.Now().Unix() + TimeOffset()
+}
+
+// median returns the median of values, the higher one of an even number
+func median(values []int64) int64 {
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[len(sorted)/2]
+}
+
+// medianTimePast returns the median timestamp of the block stored under hash
+// and the ones before it, 0 when there are none
+func medianTimePast(tx storage.Tx, hash []byte) (int64, error) {
+	var timestamps []int64
+
+	for len(hash) > 0 && len(timestamps) < MEDIAN_TIME_SPAN {
+		// the header of a pruned block is enough
+		block, err := getBlock(tx, hash)
+		if err != nil && err != ErrBlockPruned {
+			return 0, err
+		}
+
+		timestamps = append(timestamps, block.Timestamp)
+		hash = block.PrevBlockHash
+	}
+
+	if len(timestamps) == 0 {
+		return 0, nil
+	}
+
+	return median(timestamps), nil
+}
+
+// MedianTimePast returns the median time past of the tip, which the next
+// block must be timestamped after
+func (bc *Blockchain) MedianTimePast() (int64, error) {
+	var mtp int64
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		mtp, err = medianTimePast(tx, bc.Tip())
+
+		return err
+	})
+
+	return mtp, err
+}
+
+// checkMedianTimePast makes sure the block is timestamped after the median
+// time past of its parent
+func checkMedianTimePast(tx storage.Tx, block *Block) error {
+	if len(block.PrevBlockHash) == 0 {
+		return nil
+	}
+
+	mtp, err := medianTimePast(tx, block.PrevBlockHash)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp <= mtp {
+		return fmt.Errorf("timestamp %d is not after the median time past %d", block.Timestamp, mtp)
+	}
+
+	return nil
+}
+
+// checkFutureTime makes sure the block is not timestamped too far ahead of
+// the network time
+func checkFutureTime(block *Block) error {
+	if limit := AdjustedTime() + MAX_FUTURE_BLOCK_TIME; block.Timestamp > limit {
+		return fmt.Errorf("timestamp %d is more than %d seconds ahead of the network time", block.Timestamp, MAX_FUTURE_BLOCK_TIME)
+	}
+
+	return nil
+}
+
+// nextTimestamp returns the timestamp of a block mined now on top of a chain
+// whose median time past is mtp: our clock, unless it is behind
+func nextTimestamp(mtp int64) int64 {
+	timestamp := AdjustedTime()
+	if timestamp <= mtp {
+		timestamp = mtp + 1
+	}
+
+	return timestamp
+}
@@ -0,0 +1,56 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestTimestampRules(t *testing.T) {
+	_, address := newWallets(t)
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	// blocks mined within the same second still move past the MTP
+	for i := 0; i < 3; i++ {
+		mtp, err := bc.MedianTimePast()
+		assert.Nil(t, err)
+		block, err := bc.AddBlock([]*Transaction{newCoinbase(t, address, i+1)})
+		assert.Nil(t, err)
+		assert.True(t, block.Timestamp > mtp)
+	}
+
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	mine := func(timestamp int64) *Block {
+		return MineBlock(pow.Default, BLOCK_VERSION, []*Transaction{newCoinbase(t, address, 4)}, bc.Tip(), 4, timestamp)
+	}
+	assert.NotNil(t, bc.ConnectBlock(mine(mtp)), "Timestamp must be after the MTP")
+	assert.NotNil(t, bc.ConnectBlock(mine(AdjustedTime()+MAX_FUTURE_BLOCK_TIME+60)), "Block is from the future")
+	assert.Nil(t, bc.ConnectBlock(mine(mtp+1)))
+
+	_, err = bc.VerifyChain(1, 0)
+	assert.Nil(t, err)
+}
+
+func TestAdjustedTime(t *testing.T) {
+	defer func() { timeSamples.offsets = nil }()
+
+	for _, offset := range []int64{60, 120, -30, 600} {
+		AddTimeSample(offset)
+	}
+	assert.Equal(t, int64(0), TimeOffset(), "Not enough samples")
+
+	AddTimeSample(90)
+	assert.Equal(t, int64(90), TimeOffset(), "Median of the peers")
+
+	for i := 0; i < 6; i++ {
+		AddTimeSample(2 * MAX_TIME_ADJUSTMENT)
+	}
+	assert.Equal(t, int64(0), TimeOffset(), "Too far off to be trusted")
+}
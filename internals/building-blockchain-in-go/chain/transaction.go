@@ -0,0 +1,436 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+
+	"github.com/xav-b/blockchain/encoding"
+	"github.com/xav-b/blockchain/schnorr"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// the height is encoded on 8 bytes, before the data of the coinbase
+const COINBASE_HEIGHT_LEN = 8
+
+var (
+	// ErrMissingPrevTx is returned when an input refers to a transaction that
+	// was not provided
+	ErrMissingPrevTx = errors.New("previous transaction is not correct")
+	// ErrNotEnoughFunds is returned when the sender cannot afford a transfer
+	ErrNotEnoughFunds = errors.New("not enough funds")
+)
+
+// Transaction represents a Bitcoin transaction
+type Transaction struct {
+	ID   []byte
+	Vin  []TXInput
+	Vout []TXOutput
+	// Witness unlocks the inputs, one per input, when they are not unlocked
+	// by themselves. See witness.go.
+	Witness []TXWitness
+}
+
+func init() {
+	// gob numbers types in the order the process first encodes them and writes
+	// these numbers out. Transactions are hashed through gob, so we encode
+	// them first, for their hash not to depend on what else was encoded before.
+	_ = Transaction{}.serializeNoWitness()
+	_ = gob.NewEncoder(ioutil.Discard).Encode(Transaction{})
+}
+
+// NewCoinbaseTX creates a new coinbase transaction
+// The initial transaction of the block, creating coins out of thin air instead
+// of a previous txn output. This also happens to be the miner's reward and the
+// mechanism for Bitcoin to mint money.
+//
+// It pays value (the subsidy of the block, plus its fees if any) to `to`, and
+// starts with the height of the block, as required by BIP34: two coinbases
+// paying the same address would otherwise have the same ID.
+func NewCoinbaseTX(to, data string, height, value int) (*Transaction, error) {
+	if data == "" {
+		randData := make([]byte, 20)
+		_, err := rand.Read(randData)
+		if err != nil {
+			return nil, err
+		}
+
+		data = fmt.Sprintf("%x", randData)
+	}
+
+	txout, err := NewTXOutput(value, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPayoutCoinbaseTX(data, height, []TXOutput{*txout}), nil
+}
+
+// NewPayoutCoinbaseTX creates a coinbase splitting the value of the block
+// between several outputs, like a mining pool paying its workers
+func NewPayoutCoinbaseTX(data string, height int, outputs []TXOutput) *Transaction {
+	// previous txn reference are empty, and we use the height and arbitrary
+	// data in place of a ScriptSig (since there's nothing to unlock)
+	script := append(encoding.IntToHex(int64(height)), []byte(data)...)
+	txin := TXInput{[]byte{}, -1, nil, script}
+	tx := Transaction{nil, []TXInput{txin}, outputs, nil}
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// WithExtraNonce returns a copy of the coinbase with the extra nonce right
+// after the height. Miners roll it to change the merkle root of the block when
+// they run out of nonces. The coinbase must start with the height.
+func (tx Transaction) WithExtraNonce(extraNonce []byte) *Transaction {
+	script := tx.Vin[0].PubKey
+	rolled := append([]byte{}, script[:COINBASE_HEIGHT_LEN]...)
+	rolled = append(rolled, extraNonce...)
+	rolled = append(rolled, script[COINBASE_HEIGHT_LEN:]...)
+
+	tx.Vin = []TXInput{tx.Vin[0]}
+	tx.Vin[0].PubKey = rolled
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// CoinbaseHeight returns the height of the block the coinbase was created for
+func (tx Transaction) CoinbaseHeight() (int, error) {
+	if !tx.IsCoinbase() || len(tx.Vin[0].PubKey) < COINBASE_HEIGHT_LEN {
+		return 0, errors.New("coinbase does not start with the block height")
+	}
+
+	return int(binary.BigEndian.Uint64(tx.Vin[0].PubKey[:COINBASE_HEIGHT_LEN])), nil
+}
+
+// IsCoinbase checks whether the transaction is coinbase
+func (tx Transaction) IsCoinbase() bool {
+	return len(tx.Vin) == 1 && len(tx.Vin[0].Txid) == 0 && tx.Vin[0].Vout == -1
+}
+
+// Hash returns the ID of the transaction, which does not cover its witness
+func (tx *Transaction) Hash() []byte {
+	var hash [32]byte
+
+	txCopy := *tx
+	txCopy.ID = []byte{}
+
+	hash = sha256.Sum256(txCopy.serializeNoWitness())
+
+	return hash[:]
+}
+
+// checkID makes sure the ID of the transaction is its hash. The UTXO set is
+// keyed by transaction ID, so a made up one would overwrite the outputs of
+// another transaction.
+//
+// The wallet used to hash the transactions before signing their inputs: the
+// ones it made, without witness, have the hash of their unsigned inputs.
+func (tx *Transaction) checkID() error {
+	if bytes.Equal(tx.ID, tx.Hash()) {
+		return nil
+	}
+	if !tx.HasWitness() && bytes.Equal(tx.ID, tx.unsignedHash()) {
+		return nil
+	}
+
+	return fmt.Errorf("transaction %x does not hash to its ID", tx.ID)
+}
+
+// unsignedHash returns the hash of the transaction with the signatures of its
+// inputs left out
+func (tx *Transaction) unsignedHash() []byte {
+	txCopy := *tx
+	txCopy.Vin = make([]TXInput, len(tx.Vin))
+	for i, vin := range tx.Vin {
+		vin.Signature = nil
+		txCopy.Vin[i] = vin
+	}
+
+	return txCopy.Hash()
+}
+
+// Serialize returns a serialized Transaction
+func (tx Transaction) Serialize() []byte {
+	var encoded bytes.Buffer
+
+	enc := gob.NewEncoder(&encoded)
+	// encoding to memory only fails on unsupported types
+	if err := enc.Encode(tx); err != nil {
+		panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// DeserializeTransaction deserializes a transaction
+func DeserializeTransaction(data []byte) (Transaction, error) {
+	var transaction Transaction
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&transaction)
+
+	return transaction, err
+}
+
+// Sign signs each input of a Transaction with SIGHASH_ALL
+func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transaction) error {
+	if tx.IsCoinbase() {
+		// no previous transactions and input to sign
+		return nil
+	}
+
+	for _, vin := range tx.Vin {
+		if prevTXs[hex.EncodeToString(vin.Txid)].ID == nil {
+			return ErrMissingPrevTx
+		}
+	}
+
+	// go over the tx's inputs and sign them separately, covering all of it
+	for inID := range tx.Vin {
+		if err := tx.SignInput(inID, privKey, prevTXs, SIGHASH_ALL); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SignInput signs a single input of the Transaction, which lets inputs locked
+// by different keys be signed by their respective owners. The hash type tells
+// which parts of the transaction the signature covers, see sighash.go.
+func (tx *Transaction) SignInput(inID int, privKey ecdsa.PrivateKey, prevTXs map[string]Transaction, hashType SigHashType) error {
+	vin := tx.Vin[inID]
+
+	prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+	if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+		return ErrMissingPrevTx
+	}
+	// hashes the parts of the trimmed transaction covered by the hash type
+	// with the SHA-256 algorithm. The resulted hash is the data we’re going to
+	// sign
+	hash, err := tx.SignatureHash(inID, prevTx.Vout[vin.Vout].PubKeyHash, hashType)
+	if err != nil {
+		return err
+	}
+
+	// sign the hash with the private key
+	r, s, err := ecdsa.Sign(rand.Reader, &privKey, hash)
+	if err != nil {
+		return err
+	}
+	signature := append(encodePoint(r, s), byte(hashType))
+
+	if tx.HasWitness() {
+		tx.Witness[inID].Signature = signature
+	} else {
+		tx.Vin[inID].Signature = signature
+	}
+
+	return nil
+}
+
+// TrimmedCopy creates a trimmed copy of Transaction to be used in signing
+// We don’t need to sign the public keys stored in inputs. Because of this, in
+// Bitcoin, it’s not a transaction that’s signed, but its trimmed copy with
+// inputs storing ScriptPubKey from referenced outputs. The witness goes too,
+// so that both kinds of transactions sign the same data.
+func (tx *Transaction) TrimmedCopy() Transaction {
+	var inputs []TXInput
+	var outputs []TXOutput
+
+	for _, vin := range tx.Vin {
+		// copy the input but set signature and pubkey to nil
+		inputs = append(inputs, TXInput{vin.Txid, vin.Vout, nil, nil})
+	}
+
+	for _, vout := range tx.Vout {
+		outputs = append(outputs, TXOutput{vout.Value, vout.PubKeyHash})
+	}
+
+	txCopy := Transaction{tx.ID, inputs, outputs, nil}
+
+	return txCopy
+}
+
+// Verify verifies signatures of Transaction inputs
+func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	if tx.IsCoinbase() {
+		return true
+	}
+	if tx.checkWitness() != nil {
+		return false
+	}
+
+	for _, vin := range tx.Vin {
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		// an input we can't resolve can't be proven to be authorized
+		if prevTx.ID == nil || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+			return false
+		}
+	}
+
+	curve := elliptic.P256()
+
+	for inID := range tx.Vin {
+		vin := tx.WitnessedInput(inID)
+		prevTx := prevTXs[hex.EncodeToString(vin.Txid)]
+		pubKeyHash := prevTx.Vout[vin.Vout].PubKeyHash
+
+		// identical to the one in the SignInput method, because during
+		// verification we need the same data what was signed. Signatures
+		// without hash type sign the whole trimmed copy.
+		signature, hashType, typed := splitSignature(vin.Signature)
+		hash := tx.legacySignatureHash(inID, pubKeyHash)
+		if typed {
+			var err error
+			if hash, err = tx.SignatureHash(inID, pubKeyHash, hashType); err != nil {
+				return false
+			}
+		}
+
+		// an x-only public key takes a Schnorr signature, always typed
+		if len(vin.PubKey) == schnorr.PUBKEY_SIZE {
+			if !typed || !schnorr.Verify(vin.PubKey, hash, signature) {
+				return false
+			}
+			continue
+		}
+
+		// Here we unpack values stored in the Signature and PubKey of the input
+		// or its witness, since a signature is a pair of numbers and a public
+		// key is a pair of coordinates. We concatenated them earlier for
+		// storing, and now we need to unpack them to use in crypto/ecdsa
+		// functions. Both are padded to POINT_SIZE numbers, those of older
+		// transactions may be shorter but were only valid split in halves.
+		r := big.Int{}
+		s := big.Int{}
+		sigLen := len(signature)
+		r.SetBytes(signature[:(sigLen / 2)])
+		s.SetBytes(signature[(sigLen / 2):])
+
+		x := big.Int{}
+		y := big.Int{}
+		keyLen := len(vin.PubKey)
+		x.SetBytes(vin.PubKey[:(keyLen / 2)])
+		y.SetBytes(vin.PubKey[(keyLen / 2):])
+
+		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
+		if !ecdsa.Verify(&rawPubKey, hash, &r, &s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewUTXOTransaction creates a new transaction, spending the coins of the
+// wallet
+// There will be as many inputs as the total outputs that sum enough for the transfer
+// And 1 or 2 Inputs: The actual transfer and the changes back to the sender
+func NewUTXOTransaction(from *wallet.Wallet, to string, amount int, UTXOSet *UTXOSet) (*Transaction, error) {
+	output, err := NewTXOutput(amount, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return newTransfer(from, *output, UTXOSet)
+}
+
+// NewStakeTX creates a transaction locking amount coins of the wallet as its
+// stake, see pos.go
+func NewStakeTX(from *wallet.Wallet, amount int, UTXOSet *UTXOSet) (*Transaction, error) {
+	output, err := NewStakeOutput(amount, string(from.Address()))
+	if err != nil {
+		return nil, err
+	}
+
+	return newTransfer(from, *output, UTXOSet)
+}
+
+// newTransfer creates the transaction paying output with coins of the wallet
+func newTransfer(from *wallet.Wallet, output TXOutput, UTXOSet *UTXOSet) (*Transaction, error) {
+	var inputs []TXInput
+	var outputs []TXOutput
+	amount := output.Value
+
+	pubKeyHash := wallet.HashPubKey(from.PublicKey)
+	acc, validOutputs, err := UTXOSet.FindSpendableOutputs(pubKeyHash, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if acc < amount {
+		return nil, ErrNotEnoughFunds
+	}
+
+	// Build a list of inputs, mapped to the unspent outputs, and unlocked by
+	// the witness: the ID is final before signing
+	var witness []TXWitness
+	for txid, outs := range validOutputs {
+		txID, err := hex.DecodeString(txid)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, out := range outs {
+			inputs = append(inputs, TXInput{txID, out, nil, nil})
+			witness = append(witness, TXWitness{nil, from.PublicKey})
+		}
+	}
+
+	// the first output is the actual transfer
+	outputs = append(outputs, output)
+	if acc > amount {
+		// there's change, send back to the emitter
+		change, err := NewTXOutput(acc-amount, string(from.Address()))
+		if err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, *change)
+	}
+
+	tx := Transaction{nil, inputs, outputs, witness}
+	tx.ID = tx.Hash()
+	if err := UTXOSet.Blockchain.SignTransaction(&tx, from.PrivateKey); err != nil {
+		return nil, err
+	}
+
+	return &tx, nil
+}
+
+// String returns a human-readable representation of a transaction
+func (tx Transaction) String() string {
+	var lines []string
+
+	lines = append(lines, fmt.Sprintf("--- Transaction %x:", tx.ID))
+
+	for i := range tx.Vin {
+		input := tx.WitnessedInput(i)
+
+		lines = append(lines, fmt.Sprintf("     Input %d:", i))
+		lines = append(lines, fmt.Sprintf("       TXID:      %x", input.Txid))
+		lines = append(lines, fmt.Sprintf("       Out:       %d", input.Vout))
+		lines = append(lines, fmt.Sprintf("       Signature: %x", input.Signature))
+		lines = append(lines, fmt.Sprintf("       PubKey:    %x", input.PubKey))
+	}
+
+	for i, output := range tx.Vout {
+		lines = append(lines, fmt.Sprintf("     Output %d:", i))
+		lines = append(lines, fmt.Sprintf("       Value:  %d", output.Value))
+		lines = append(lines, fmt.Sprintf("       Script: %x", output.PubKeyHash))
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -1,6 +1,10 @@
-package main
+package chain
 
-import "bytes"
+import (
+	"bytes"
+
+	"github.com/xav-b/blockchain/wallet"
+)
 
 // TXInput represents a transaction input
 type TXInput struct {
@@ -15,7 +19,7 @@ type TXInput struct {
 
 // UsesKey checks whether the address initiated the transaction
 func (in *TXInput) UsesKey(pubKeyHash []byte) bool {
-	lockingHash := HashPubKey(in.PubKey)
+	lockingHash := wallet.HashPubKey(in.PubKey)
 
-	return bytes.Compare(lockingHash, pubKeyHash) == 0
+	return bytes.Equal(lockingHash, pubKeyHash)
 }
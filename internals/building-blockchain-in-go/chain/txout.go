@@ -0,0 +1,147 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/gob"
+
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// TXOutput represents a transaction output
+type TXOutput struct {
+	// Value is the actual storage of coins
+	// in satoshis (== 0.00000001 BTC)
+	Value int
+	// PubKeyHash is the hash of the public key that can unlock the output
+	// Script reference: https://en.bitcoin.it/wiki/Script
+	PubKeyHash []byte
+}
+
+// Lock signs the output
+func (out *TXOutput) Lock(address []byte) error {
+	pubKeyHash, err := wallet.AddressToPubKeyHash(string(address))
+	if err != nil {
+		return err
+	}
+	out.PubKeyHash = pubKeyHash
+
+	return nil
+}
+
+// IsLockedWithKey checks if the output can be used by the owner of the pubkey
+// This is very similar to TXInput.UsesKey but the public key used is already
+// hashed, while TXInput stores the raw public key
+func (out *TXOutput) IsLockedWithKey(pubKeyHash []byte) bool {
+	return bytes.Equal(out.PubKeyHash, pubKeyHash)
+}
+
+// NewTXOutput create a new TXOutput
+func NewTXOutput(value int, address string) (*TXOutput, error) {
+	txo := &TXOutput{value, nil}
+	if err := txo.Lock([]byte(address)); err != nil {
+		return nil, err
+	}
+
+	return txo, nil
+}
+
+// STAKE_MARKER prefixes the public key hash of the outputs a validator of a
+// proof of stake locks as its stake, see pos.go
+const STAKE_MARKER = "stake"
+
+// StakePubKeyHash returns what locks the stake of the owner of pubKeyHash
+func StakePubKeyHash(pubKeyHash []byte) []byte {
+	return append([]byte(STAKE_MARKER), pubKeyHash...)
+}
+
+// NewStakeOutput creates an output locking value as the stake of address. Its
+// owner spends it like any other output, once unlocked.
+func NewStakeOutput(value int, address string) (*TXOutput, error) {
+	txo, err := NewTXOutput(value, address)
+	if err != nil {
+		return nil, err
+	}
+	txo.PubKeyHash = StakePubKeyHash(txo.PubKeyHash)
+
+	return txo, nil
+}
+
+// StakeOwner returns the public key hash of the validator the output is the
+// stake of, if it is one
+func (out *TXOutput) StakeOwner() ([]byte, bool) {
+	if len(out.PubKeyHash) <= len(STAKE_MARKER) || !bytes.HasPrefix(out.PubKeyHash, []byte(STAKE_MARKER)) {
+		return nil, false
+	}
+
+	return out.PubKeyHash[len(STAKE_MARKER):], true
+}
+
+// TXOutputs collects TXOutput
+type TXOutputs struct {
+	Outputs []TXOutput
+	// Indexes keeps the position of each output within its transaction, as
+	// spent outputs are removed from the UTXO set and shift the others
+	Indexes []int
+	// Height of the block the transaction was mined in, and whether it is
+	// its coinbase: the outputs of a coinbase can only be spent once mature
+	Height   int
+	Coinbase bool
+}
+
+// newTXOutputs collects the outputs of a transaction mined at height
+func newTXOutputs(tx *Transaction, height int) TXOutputs {
+	outs := TXOutputs{Height: height, Coinbase: tx.IsCoinbase()}
+	for outIdx, out := range tx.Vout {
+		outs.Outputs = append(outs.Outputs, out)
+		outs.Indexes = append(outs.Indexes, outIdx)
+	}
+
+	return outs
+}
+
+// coin returns the unspent output vout of the transaction, if any
+func (outs TXOutputs) coin(vout int) (Coin, bool) {
+	for i, out := range outs.Outputs {
+		if outs.Indexes[i] == vout {
+			return Coin{out, outs.Height, outs.Coinbase}, true
+		}
+	}
+
+	return Coin{}, false
+}
+
+// without returns the outputs left once vout is spent
+func (outs TXOutputs) without(vout int) TXOutputs {
+	updated := TXOutputs{Height: outs.Height, Coinbase: outs.Coinbase}
+	for i, out := range outs.Outputs {
+		if outs.Indexes[i] != vout {
+			updated.Outputs = append(updated.Outputs, out)
+			updated.Indexes = append(updated.Indexes, outs.Indexes[i])
+		}
+	}
+
+	return updated
+}
+
+// Serialize serializes TXOutputs
+func (outs TXOutputs) Serialize() []byte {
+	var buff bytes.Buffer
+
+	enc := gob.NewEncoder(&buff)
+	// encoding to memory only fails on unsupported types
+	if err := enc.Encode(outs); err != nil {
+		panic(err)
+	}
+
+	return buff.Bytes()
+}
+
+// DeserializeOutputs deserializes TXOutputs
+func DeserializeOutputs(data []byte) (TXOutputs, error) {
+	var outputs TXOutputs
+
+	dec := gob.NewDecoder(bytes.NewReader(data))
+	err := dec.Decode(&outputs)
+
+	return outputs, err
+}
@@ -0,0 +1,163 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/storage"
+)
+
+// Connecting a block deletes the outputs it spends from the UTXO set, so to
+// be able to disconnect it later (in a reorg) we keep them aside as its undo
+// data, like bitcoind's rev*.dat files.
+
+const UNDO_BUCKET = "undo"
+
+// BlockUndo holds the outputs spent by a block, in the order of its inputs
+type BlockUndo struct {
+	Spent []SpentOutput
+}
+
+// SpentOutput is an output spent by a block, with where it was created so
+// that it goes back to the UTXO set as it was
+type SpentOutput struct {
+	Txid     []byte
+	Vout     int
+	Output   TXOutput
+	Height   int
+	Coinbase bool
+}
+
+func putUndo(tx storage.Tx, blockHash []byte, undo BlockUndo) error {
+	b, err := tx.CreateBucketIfNotExists([]byte(UNDO_BUCKET))
+	if err != nil {
+		return err
+	}
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(undo); err != nil {
+		return err
+	}
+
+	return b.Put(blockHash, encoded.Bytes())
+}
+
+func getUndo(tx storage.Tx, blockHash []byte) (*BlockUndo, error) {
+	var undo BlockUndo
+
+	b := tx.Bucket([]byte(UNDO_BUCKET))
+	if b == nil {
+		return nil, fmt.Errorf("no undo data for block %x", blockHash)
+	}
+
+	data := b.Get(blockHash)
+	if data == nil {
+		return nil, fmt.Errorf("no undo data for block %x", blockHash)
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&undo); err != nil {
+		return nil, err
+	}
+
+	return &undo, nil
+}
+
+// DisconnectTip removes the latest block from the main chain, putting back in
+// the UTXO set the outputs it had spent. The block itself stays stored.
+func (bc *Blockchain) DisconnectTip() (*Block, error) {
+	var block *Block
+
+	bc.writer.Lock()
+	defer bc.writer.Unlock()
+
+	err := bc.db.Update(func(tx storage.Tx) error {
+		var err error
+
+		block, err = getBlock(tx, bc.tip)
+		if err != nil {
+			return err
+		}
+		if len(block.PrevBlockHash) == 0 {
+			return errors.New("cannot disconnect the genesis block")
+		}
+
+		undo, err := getUndo(tx, block.Hash)
+		if err != nil {
+			return err
+		}
+
+		utxo := tx.Bucket([]byte(UTXO_BUCKET))
+		spent := undo.Spent
+
+		// walk the block backward, so outputs created and spent within the
+		// block are restored then deleted
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			blockTx := block.Transactions[i]
+
+			// every output of the transaction was created by this block
+			if err := utxo.Delete(blockTx.ID); err != nil {
+				return err
+			}
+
+			if blockTx.IsCoinbase() {
+				continue
+			}
+
+			for j := len(blockTx.Vin) - 1; j >= 0; j-- {
+				if len(spent) == 0 {
+					return fmt.Errorf("undo data of block %x is incomplete", block.Hash)
+				}
+				if err := restoreOutput(utxo, spent[len(spent)-1]); err != nil {
+					return err
+				}
+				spent = spent[:len(spent)-1]
+			}
+		}
+
+		if err := tx.Bucket([]byte(UNDO_BUCKET)).Delete(block.Hash); err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte(BLOCKS_BUCKET)).Put([]byte("l"), block.PrevBlockHash)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bc.setTip(block.PrevBlockHash)
+	bc.publish(Event{Type: BlockDisconnected, Block: block})
+
+	return block, nil
+}
+
+// restoreOutput puts back an output in the UTXO set, at its position among
+// the other unspent outputs of its transaction
+func restoreOutput(b storage.Bucket, prevOut SpentOutput) error {
+	outs := TXOutputs{Height: prevOut.Height, Coinbase: prevOut.Coinbase}
+	if outsBytes := b.Get(prevOut.Txid); outsBytes != nil {
+		var err error
+		if outs, err = DeserializeOutputs(outsBytes); err != nil {
+			return err
+		}
+	}
+
+	restored := TXOutputs{Height: outs.Height, Coinbase: outs.Coinbase}
+	inserted := false
+	for i, out := range outs.Outputs {
+		if !inserted && outs.Indexes[i] > prevOut.Vout {
+			restored.Outputs = append(restored.Outputs, prevOut.Output)
+			restored.Indexes = append(restored.Indexes, prevOut.Vout)
+			inserted = true
+		}
+		restored.Outputs = append(restored.Outputs, out)
+		restored.Indexes = append(restored.Indexes, outs.Indexes[i])
+	}
+	if !inserted {
+		restored.Outputs = append(restored.Outputs, prevOut.Output)
+		restored.Indexes = append(restored.Indexes, prevOut.Vout)
+	}
+
+	return b.Put(prevOut.Txid, restored.Serialize())
+}
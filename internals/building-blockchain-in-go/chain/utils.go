@@ -0,0 +1,11 @@
+package chain
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+// Outpoint formats the reference to an output as "txid:vout"
+func Outpoint(txid []byte, vout int) string {
+	return hex.EncodeToString(txid) + ":" + strconv.Itoa(vout)
+}
@@ -0,0 +1,380 @@
+package chain
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/storage"
+)
+
+const UTXO_BUCKET = "chainstate"
+
+// ErrNoUTXOSet is returned when reading the UTXO set of a node that has not
+// built it yet
+var ErrNoUTXOSet = errors.New("the UTXO set is not built, run reindexutxo")
+
+// utxoBucket returns the bucket of the UTXO set, or ErrNoUTXOSet
+func utxoBucket(tx storage.Tx) (storage.Bucket, error) {
+	b := tx.Bucket([]byte(UTXO_BUCKET))
+	if b == nil {
+		return nil, ErrNoUTXOSet
+	}
+
+	return b, nil
+}
+
+// UTXOSet represents UTXO set
+type UTXOSet struct {
+	Blockchain *Blockchain
+}
+
+// Reindex rebuilds the UTXO set
+func (u UTXOSet) Reindex() error {
+	db := u.Blockchain.db
+	bucketName := []byte(UTXO_BUCKET)
+
+	u.Blockchain.writer.Lock()
+	defer u.Blockchain.writer.Unlock()
+
+	// walk the chain first, so a failure leaves the current set untouched
+	UTXO, err := u.Blockchain.FindUTXO()
+	if err != nil {
+		return err
+	}
+
+	// reset bucket and fill it at once
+	return db.Update(func(tx storage.Tx) error {
+		err := tx.DeleteBucket(bucketName)
+		if err != nil && err != storage.ErrBucketNotFound {
+			return err
+		}
+
+		b, err := tx.CreateBucket(bucketName)
+		if err != nil {
+			return err
+		}
+
+		for txID, outs := range UTXO {
+			key, err := hex.DecodeString(txID)
+			if err != nil {
+				return err
+			}
+
+			if err := b.Put(key, outs.Serialize()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// FindSpendableOutputs finds and returns unspent outputs to reference in inputs.
+// Immature coinbase outputs are left out, they could not be mined.
+func (u UTXOSet) FindSpendableOutputs(pubkeyHash []byte, amount int) (int, map[string][]int, error) {
+	unspentOutputs := make(map[string][]int)
+	accumulated := 0
+	db := u.Blockchain.db
+	maturity := u.Blockchain.Params().CoinbaseMaturity
+
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// load UTXO set
+	err = db.View(func(tx storage.Tx) error {
+		b, err := utxoBucket(tx)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+
+		// iterate over each transaction
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			txID := hex.EncodeToString(k)
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+			if !outs.isMature(bestHeight+1, maturity) {
+				continue
+			}
+
+			// and now over each unspent output
+			for i, out := range outs.Outputs {
+				// accumulate address' values as long as we don't have enough money
+				if out.IsLockedWithKey(pubkeyHash) && accumulated < amount {
+					accumulated += out.Value
+					unspentOutputs[txID] = append(unspentOutputs[txID], outs.Indexes[i])
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return accumulated, unspentOutputs, err
+}
+
+// Balance returns the coins of pubKeyHash that can be spent in the next
+// block, and those from coinbases not mature yet
+func (u UTXOSet) Balance(pubKeyHash []byte) (int, int, error) {
+	spendable, immature := 0, 0
+	maturity := u.Blockchain.Params().CoinbaseMaturity
+
+	bestHeight, err := u.Blockchain.GetBestHeight()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = u.Blockchain.db.View(func(tx storage.Tx) error {
+		b, err := utxoBucket(tx)
+		if err != nil {
+			return err
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for _, out := range outs.Outputs {
+				if !out.IsLockedWithKey(pubKeyHash) {
+					continue
+				}
+				if outs.isMature(bestHeight+1, maturity) {
+					spendable += out.Value
+				} else {
+					immature += out.Value
+				}
+			}
+
+			return nil
+		})
+	})
+
+	return spendable, immature, err
+}
+
+// FindUTXO finds UTXO for a public key hash
+func (u UTXOSet) FindUTXO(pubKeyHash []byte) ([]TXOutput, error) {
+	var UTXOs []TXOutput
+	db := u.Blockchain.db
+
+	err := db.View(func(tx storage.Tx) error {
+		b, err := utxoBucket(tx)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for _, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					UTXOs = append(UTXOs, out)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return UTXOs, err
+}
+
+// UTXO locates an unspent output and its value
+type UTXO struct {
+	Txid   []byte
+	Vout   int
+	Output TXOutput
+}
+
+// FindUnspent is like FindUTXO but also tells where each output lives, which
+// is what we need to reference them as inputs
+func (u UTXOSet) FindUnspent(pubKeyHash []byte) ([]UTXO, error) {
+	var UTXOs []UTXO
+	db := u.Blockchain.db
+
+	err := db.View(func(tx storage.Tx) error {
+		b, err := utxoBucket(tx)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			outs, err := DeserializeOutputs(v)
+			if err != nil {
+				return err
+			}
+
+			for i, out := range outs.Outputs {
+				if out.IsLockedWithKey(pubKeyHash) {
+					txID := append([]byte{}, k...)
+					UTXOs = append(UTXOs, UTXO{txID, outs.Indexes[i], out})
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return UTXOs, err
+}
+
+// Coin is an unspent output, along with where it was created
+type Coin struct {
+	Output   TXOutput
+	Height   int
+	Coinbase bool
+}
+
+// IsMature tells whether the coin can be spent by a block at height. Like in
+// Bitcoin, a coinbase has to be buried under maturity blocks first, so that
+// a reorg (which could make it vanish) does not invalidate its spends.
+func (c Coin) IsMature(height, maturity int) bool {
+	return !c.Coinbase || height-c.Height >= maturity
+}
+
+// isMature is IsMature for the outputs of a transaction
+func (outs TXOutputs) isMature(height, maturity int) bool {
+	return Coin{Height: outs.Height, Coinbase: outs.Coinbase}.IsMature(height, maturity)
+}
+
+// FindOutput returns the output txid:vout if it is still unspent. Outputs we
+// can't read are an error, not reported as missing: the UTXO set is corrupted.
+func (u UTXOSet) FindOutput(txid []byte, vout int) (TXOutput, bool, error) {
+	coin, found, err := u.FindCoin(txid, vout)
+
+	return coin.Output, found, err
+}
+
+// FindCoin is FindOutput, telling where the output was created as well
+func (u UTXOSet) FindCoin(txid []byte, vout int) (Coin, bool, error) {
+	var coin Coin
+	found := false
+	db := u.Blockchain.db
+
+	err := db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(UTXO_BUCKET))
+		if b == nil {
+			// not indexed yet
+			return nil
+		}
+
+		outsBytes := b.Get(txid)
+		if outsBytes == nil {
+			return nil
+		}
+
+		outs, err := DeserializeOutputs(outsBytes)
+		if err != nil {
+			return fmt.Errorf("unspent outputs of %x: %s", txid, err)
+		}
+		coin, found = outs.coin(vout)
+
+		return nil
+	})
+
+	return coin, found, err
+}
+
+// Update updates the UTXO set with transactions from the Block
+// The Block is considered to be the tip of a blockchain. AddBlock and
+// ConnectBlock already do it along with storing the block.
+func (u UTXOSet) Update(block *Block) error {
+	u.Blockchain.writer.Lock()
+	defer u.Blockchain.writer.Unlock()
+
+	return u.Blockchain.db.Update(func(tx storage.Tx) error {
+		return u.update(tx, block)
+	})
+}
+
+// update applies the block to the UTXO set within an ongoing
+// transaction. The outputs it spends are saved as the undo data of the
+// block, should it be disconnected.
+func (u UTXOSet) update(dbTx storage.Tx, block *Block) error {
+	b := dbTx.Bucket([]byte(UTXO_BUCKET))
+	var undo BlockUndo
+
+	for _, tx := range block.Transactions {
+		// update unspent outputs that are now referenced by a newly mined
+		// block's txn inputs
+		if !tx.IsCoinbase() {
+			for _, vin := range tx.Vin {
+				// get the (raw) outputs referenced by this new block's transaction input
+				outsBytes := b.Get(vin.Txid)
+				if outsBytes == nil {
+					return fmt.Errorf("output %s is unknown or already spent", Outpoint(vin.Txid, vin.Vout))
+				}
+				outs, err := DeserializeOutputs(outsBytes)
+				if err != nil {
+					return err
+				}
+
+				// the outputs of the previous transaction not referenced in
+				// this new transaction's input are still unspent
+				coin, ok := outs.coin(vin.Vout)
+				if !ok {
+					return fmt.Errorf("output %s is unknown or already spent", Outpoint(vin.Txid, vin.Vout))
+				}
+				undo.Spent = append(undo.Spent, SpentOutput{vin.Txid, vin.Vout, coin.Output, coin.Height, coin.Coinbase})
+				updatedOuts := outs.without(vin.Vout)
+
+				if len(updatedOuts.Outputs) == 0 {
+					// all outputs were spent, remove transaction
+					if err := b.Delete(vin.Txid); err != nil {
+						return err
+					}
+				} else {
+					// update the set of unspent outputs of this transaction
+					if err := b.Put(vin.Txid, updatedOuts.Serialize()); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		// add all the new transaction's outputs. Slashing transactions have
+		// none, see pos.go.
+		if len(tx.Vout) == 0 {
+			continue
+		}
+		if err := b.Put(tx.ID, newTXOutputs(tx, block.Height).Serialize()); err != nil {
+			return err
+		}
+	}
+
+	return putUndo(dbTx, block.Hash, undo)
+}
+
+// CountTransactions returns the number of transactions in the UTXO set
+func (u UTXOSet) CountTransactions() (int, error) {
+	db := u.Blockchain.db
+	counter := 0
+
+	err := db.View(func(tx storage.Tx) error {
+		b, err := utxoBucket(tx)
+		if err != nil {
+			return err
+		}
+		c := b.Cursor()
+
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			counter++
+		}
+
+		return nil
+	})
+
+	return counter, err
+}
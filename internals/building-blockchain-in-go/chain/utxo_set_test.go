@@ -0,0 +1,236 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+func TestUTXOSetNotBuilt(t *testing.T) {
+	_, address := newWallets(t)
+	pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+
+	// reading the set before it is built is an error, not a panic
+	_, _, err = utxo.Balance(pubKeyHash)
+	assert.Equal(t, ErrNoUTXOSet, err)
+	_, err = utxo.FindUnspent(pubKeyHash)
+	assert.Equal(t, ErrNoUTXOSet, err)
+
+	assert.Nil(t, utxo.Reindex())
+	spendable, _, err := utxo.Balance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY, spendable)
+}
+
+func TestForgedTransactionID(t *testing.T) {
+	wallets, alice := newWallets(t)
+	mallory, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	malloryWallet, err := wallets.GetWallet(mallory)
+	assert.Nil(t, err)
+	alicePubKeyHash, err := wallet.AddressToPubKeyHash(alice)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, mallory, 1)})
+	assert.Nil(t, err)
+
+	// mallory spends her own coins under the ID of alice's coinbase, which
+	// the signatures do not cover, to overwrite its outputs with hers
+	forged, err := NewUTXOTransaction(malloryWallet, mallory, 1, &utxo)
+	assert.Nil(t, err)
+	forged.ID = genesis.Transactions[0].ID
+	err = utxo.CheckTransaction(forged)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not hash to its ID")
+	_, err = utxo.SendRawTransaction(forged, mallory)
+	assert.NotNil(t, err)
+	// nor can she pick an ID nobody uses
+	forged.ID = make([]byte, 32)
+	assert.NotNil(t, utxo.CheckTransaction(forged))
+
+	spendable, _, err := utxo.Balance(alicePubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY, spendable, "Alice's coinbase is untouched")
+
+	// a transaction cannot be mined again while its outputs are unspent
+	forged.ID = forged.Hash()
+	_, err = utxo.SendRawTransaction(forged, mallory)
+	assert.Nil(t, err)
+	err = utxo.CheckTransaction(forged)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "already exists")
+
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
+
+func TestSubmitForgedTransactionID(t *testing.T) {
+	wallets, alice := newWallets(t)
+	mallory, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	malloryWallet, err := wallets.GetWallet(mallory)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, mallory, 1)})
+	assert.Nil(t, err)
+
+	// what submitblock does with the block it is sent
+	submit := func(transactions ...*Transaction) error {
+		mtp, err := bc.MedianTimePast()
+		assert.Nil(t, err)
+		block := newBlock(CommitWitnesses(transactions), bc.Tip(), 2, nextTimestamp(mtp))
+		mineBlock(block, pow.Default, pow.MAX_NONCE)
+		block, err = DeserializeBlock(block.Serialize())
+		assert.Nil(t, err)
+
+		return bc.ConnectBlock(block)
+	}
+
+	forged, err := NewUTXOTransaction(malloryWallet, mallory, 1, &utxo)
+	assert.Nil(t, err)
+	forged.ID = genesis.Transactions[0].ID
+	err = submit(newCoinbase(t, mallory, 2), forged)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not hash to its ID")
+
+	coinbase := newCoinbase(t, mallory, 2)
+	coinbase.ID = genesis.Transactions[0].ID
+	err = submit(coinbase)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not hash to its ID")
+
+	forged.ID = forged.Hash()
+	err = submit(newCoinbase(t, mallory, 2), forged, forged)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "twice")
+
+	spendable, _, err := utxo.Balance(genesis.Transactions[0].Vout[0].PubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY, spendable, "Alice's coinbase is untouched")
+	assert.Nil(t, submit(newCoinbase(t, mallory, 2), forged))
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
+
+func TestUnsignedTransactionID(t *testing.T) {
+	wallets, alice := newWallets(t)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+
+	// hashed before being signed, like the wallet did before witnesses
+	tx := NewRawTransaction([]TXInput{{coinbase.ID, 0, nil, aliceWallet.PublicKey}}, []TXOutput{newOutput(t, 4, alice)})
+	unsignedID := tx.ID
+	assert.Nil(t, tx.Sign(aliceWallet.PrivateKey, map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase}))
+	assert.Equal(t, unsignedID, tx.ID)
+	assert.NotEqual(t, unsignedID, tx.Hash())
+	assert.Nil(t, utxo.CheckTransaction(tx))
+
+	_, err = utxo.SendRawTransaction(tx, alice)
+	assert.Nil(t, err)
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
+
+func TestCorruptedUTXOSet(t *testing.T) {
+	wallets, alice := newWallets(t)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+	tx, err := NewUTXOTransaction(aliceWallet, alice, 1, &utxo)
+	assert.Nil(t, err)
+
+	// the outputs of the genesis coinbase cannot be decoded anymore
+	txid := tx.Vin[0].Txid
+	err = bc.db.Update(func(dbTx storage.Tx) error {
+		return dbTx.Bucket([]byte(UTXO_BUCKET)).Put(txid, []byte("garbage"))
+	})
+	assert.Nil(t, err)
+
+	_, _, err = utxo.FindOutput(txid, 0)
+	assert.NotNil(t, err, "Not reported as spent")
+	err = utxo.CheckTransaction(tx)
+	assert.NotNil(t, err)
+	assert.NotContains(t, err.Error(), "already spent")
+}
+
+func TestSpendShortCoordinateKey(t *testing.T) {
+	// about 1 key in 256 has a first coordinate starting with a zero byte
+	var key *ecdsa.PrivateKey
+	for key == nil || key.PublicKey.X.BitLen() > 8*(wallet.COORD_SIZE-1) {
+		var err error
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		assert.Nil(t, err)
+	}
+
+	// a wallet saved before the coordinates were padded
+	legacy := &wallet.Wallet{
+		PrivateKey: *key,
+		PublicKey:  append(key.PublicKey.X.Bytes(), key.PublicKey.Y.Bytes()...),
+	}
+	var file bytes.Buffer
+	assert.Nil(t, gob.NewEncoder(&file).Encode(legacy))
+	loaded := &wallet.Wallet{}
+	assert.Nil(t, gob.NewDecoder(&file).Decode(loaded))
+	assert.Len(t, loaded.PublicKey, 2*wallet.COORD_SIZE)
+	assert.Equal(t, legacy.Address(), loaded.Address(), "Same address once padded")
+
+	_, bob := newWallets(t)
+	bc, err := NewBlockchain(storage.NewMemory(), string(loaded.Address()), DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+
+	tx, err := NewUTXOTransaction(loaded, bob, 4, &utxo)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, bob, 1), tx})
+	assert.Nil(t, err)
+	pubKeyHash, err := wallet.AddressToPubKeyHash(bob)
+	assert.Nil(t, err)
+	spendable, _, err := utxo.Balance(pubKeyHash)
+	assert.Nil(t, err)
+	assert.Equal(t, INITIAL_SUBSIDY+4, spendable)
+}
@@ -0,0 +1,338 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/storage"
+)
+
+// verifychain walks the chain down from the tip to catch a corrupted or
+// tampered database before it makes the node panic, like bitcoind's command
+// of the same name. Each level adds checks to the previous ones:
+//
+//   0  blocks can be read, are stored under their hash and link to their parent
+//   1  block hashes are sealed (meet the proof of work target, or are signed
+//      by the signer in turn), and timestamps are after the median time past
+//   2  the header, with the merkle root of the transactions, hashes to the
+//      block hash
+//   3  the block is within the limits, its transactions are valid,
+//      signatures included, and so is the undo data
+//   4  the UTXO set rebuilt from the blocks is the one stored
+//
+// Levels 2 and 3 need the transactions so they skip pruned blocks, and level 4
+// the whole chain so it is skipped on a pruned one.
+
+const (
+	DEFAULT_CHECK_LEVEL  = 3
+	DEFAULT_CHECK_BLOCKS = 6
+	MAX_CHECK_LEVEL      = 4
+)
+
+// VerifyChainError reports the first block found breaking a rule
+type VerifyChainError struct {
+	Height int
+	Hash   []byte
+	Rule   string
+	Err    error
+}
+
+func (e *VerifyChainError) Error() string {
+	return fmt.Sprintf("block %d %x fails the %s check: %s", e.Height, e.Hash, e.Rule, e.Err)
+}
+
+// VerifyChain checks the last `depth` blocks (all of them when 0) up to the
+// given level, and returns how many were checked
+func (bc *Blockchain) VerifyChain(level, depth int) (int, error) {
+	if level < 0 || level > MAX_CHECK_LEVEL {
+		return 0, fmt.Errorf("level must be between 0 and %d", MAX_CHECK_LEVEL)
+	}
+
+	checked := 0
+	err := bc.db.View(func(tx storage.Tx) error {
+		blocks := tx.Bucket([]byte(BLOCKS_BUCKET))
+		if blocks == nil {
+			return errors.New("no blockchain found, create one first")
+		}
+
+		hash := blocks.Get([]byte("l"))
+		height := -1 // unknown until the tip is read
+
+		for len(hash) > 0 && (depth == 0 || checked < depth) {
+			block, err := bc.verifyBlock(tx, hash, height, level)
+			if err != nil {
+				return err
+			}
+
+			hash = block.PrevBlockHash
+			height = block.Height - 1
+			checked++
+		}
+
+		return nil
+	})
+	if err != nil || level < 4 {
+		return checked, err
+	}
+
+	pruneHeight, err := bc.PruneHeight()
+	if err != nil {
+		return checked, err
+	}
+	if pruneHeight > 0 {
+		// the UTXO set cannot be rebuilt without the old blocks
+		return checked, nil
+	}
+
+	return checked, bc.verifyUTXOSet()
+}
+
+// verifyBlock checks the block stored under hash, expected at height unless
+// negative
+func (bc *Blockchain) verifyBlock(tx storage.Tx, hash []byte, height, level int) (*Block, error) {
+	params := bc.Params()
+	engine := bc.Engine()
+
+	fail := func(block *Block, rule string, format string, a ...interface{}) error {
+		if block != nil {
+			height = block.Height
+		}
+		// hash points into the transaction
+		return &VerifyChainError{height, append([]byte{}, hash...), rule, fmt.Errorf(format, a...)}
+	}
+
+	var block *Block
+	if data := tx.Bucket([]byte(BLOCKS_BUCKET)).Get(hash); data != nil {
+		var err error
+		if block, err = DeserializeBlock(data); err != nil {
+			return nil, fail(nil, "storage", "cannot decode the block: %s", err)
+		}
+	} else {
+		// pruned blocks are only needed as headers, as are missing ones
+		var err error
+		if block, err = getBlock(tx, hash); err != ErrBlockPruned {
+			return nil, fail(nil, "storage", "block is missing")
+		}
+	}
+
+	if !bytes.Equal(block.Hash, hash) {
+		return nil, fail(block, "storage", "stored under the hash %x", hash)
+	}
+	if height >= 0 && block.Height != height {
+		return nil, fail(block, "linkage", "parent of the block %d has height %d", height+1, block.Height)
+	}
+	if len(block.PrevBlockHash) == 0 && block.Height != 0 {
+		return nil, fail(block, "linkage", "no parent")
+	}
+
+	if level >= 1 {
+		if err := engine.VerifySeal(block); err != nil {
+			return nil, fail(block, "seal", "%s", err)
+		}
+		if err := checkMedianTimePast(tx, block); err != nil {
+			return nil, fail(block, "timestamp", "%s", err)
+		}
+	}
+
+	// the hash covers the header, merkle root of the transactions included
+	if level >= 2 && !bytes.Equal(engine.Hash(block), block.Hash) {
+		return nil, fail(block, "merkle root", "transactions and header do not hash to the block hash")
+	}
+
+	if level >= 3 && !block.IsPruned() {
+		if err := checkBlockLimits(block, params); err != nil {
+			return nil, fail(block, "limits", "%s", err)
+		}
+		_, rules, err := bc.versionBits(tx, block.PrevBlockHash)
+		if err != nil {
+			return nil, fail(block, "storage", "%s", err)
+		}
+		if err := verifyTransactions(tx, block, params, rules); err != nil {
+			return nil, fail(block, "transactions", "%s", err)
+		}
+	}
+
+	return block, nil
+}
+
+// verifyTransactions checks the transactions of the block following rules,
+// against the outputs recorded in its undo data when there are some
+func verifyTransactions(tx storage.Tx, block *Block, params Params, rules Rules) error {
+	if len(block.Transactions) == 0 || !block.Transactions[0].IsCoinbase() {
+		return errors.New("first transaction must be the coinbase")
+	}
+	if err := checkWitnessCommitment(block); err != nil {
+		return err
+	}
+
+	// blocks connected before the undo data existed have none
+	undo, err := getUndo(tx, block.Hash)
+	if err != nil {
+		undo = nil
+	}
+
+	var spent []SpentOutput
+	fees := 0
+	for _, blockTx := range block.Transactions[1:] {
+		if blockTx.IsCoinbase() {
+			return errors.New("more than one coinbase")
+		}
+		if err := blockTx.checkID(); err != nil {
+			return err
+		}
+
+		slashing := blockTx.IsSlashing() && params.Consensus == CONSENSUS_POS
+		if len(blockTx.Vout) == 0 && !slashing {
+			return fmt.Errorf("transaction %x has no outputs", blockTx.ID)
+		}
+
+		var prevOuts []PrevOutput
+		var coins []Coin
+		inputs := 0
+		for _, vin := range blockTx.Vin {
+			spentOut, err := findSpentOutput(tx, block, vin)
+			if errors.Is(err, ErrBlockPruned) && undo != nil && len(spent) < len(undo.Spent) {
+				// the undo data of the block recorded the output, it is
+				// checked against the input below
+				spentOut, err = undo.Spent[len(spent)], nil
+			}
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(spentOut.Txid, vin.Txid) || spentOut.Vout != vin.Vout {
+				return fmt.Errorf("undo data does not match the output %s", Outpoint(vin.Txid, vin.Vout))
+			}
+			coin := Coin{spentOut.Output, spentOut.Height, spentOut.Coinbase}
+			if !coin.IsMature(block.Height, params.CoinbaseMaturity) {
+				return fmt.Errorf("output %s is an immature coinbase", Outpoint(vin.Txid, vin.Vout))
+			}
+			if !slashing && !coin.IsUnlocked(block.Height, params.StakeLock) {
+				return fmt.Errorf("output %s is a locked stake", Outpoint(vin.Txid, vin.Vout))
+			}
+			prevOuts = append(prevOuts, PrevOutput{vin.Txid, vin.Vout, spentOut.Output})
+			coins = append(coins, coin)
+			spent = append(spent, spentOut)
+			inputs += spentOut.Output.Value
+		}
+
+		if slashing {
+			// the stake is burned, not left as a fee
+			if err := checkSlashing(blockTx, coins); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fees += inputs
+		for _, out := range blockTx.Vout {
+			fees -= out.Value
+		}
+
+		if rules[DEPLOYMENT_PUBKEYHASH] {
+			if err := checkPubKeyHashes(blockTx, prevOuts); err != nil {
+				return err
+			}
+		}
+		if !blockTx.Verify(PrevTXsFromOutputs(prevOuts)) {
+			return fmt.Errorf("transaction %x has an invalid signature", blockTx.ID)
+		}
+	}
+
+	if err := checkCoinbase(block.Transactions[0], block.Height, params.Subsidy(block.Height)+fees); err != nil {
+		return err
+	}
+
+	if undo == nil {
+		return nil
+	}
+	if len(undo.Spent) != len(spent) {
+		return fmt.Errorf("undo data holds %d outputs for %d inputs", len(undo.Spent), len(spent))
+	}
+	for i, prevOut := range spent {
+		if !bytes.Equal(undo.Spent[i].Txid, prevOut.Txid) || undo.Spent[i].Vout != prevOut.Vout ||
+			undo.Spent[i].Output.Value != prevOut.Output.Value ||
+			!bytes.Equal(undo.Spent[i].Output.PubKeyHash, prevOut.Output.PubKeyHash) ||
+			undo.Spent[i].Height != prevOut.Height || undo.Spent[i].Coinbase != prevOut.Coinbase {
+			return fmt.Errorf("undo data does not match the output %s", Outpoint(prevOut.Txid, prevOut.Vout))
+		}
+	}
+
+	return nil
+}
+
+// findSpentOutput looks for the output spent by vin in the blocks below the
+// given one, within the ongoing transaction
+func findSpentOutput(tx storage.Tx, block *Block, vin TXInput) (SpentOutput, error) {
+	for hash := block.PrevBlockHash; len(hash) > 0; {
+		prev, err := getBlock(tx, hash)
+		if err == ErrBlockPruned {
+			return SpentOutput{}, fmt.Errorf("output %s is in a pruned block: %w", Outpoint(vin.Txid, vin.Vout), ErrBlockPruned)
+		} else if err != nil {
+			return SpentOutput{}, err
+		}
+
+		for _, prevTx := range prev.Transactions {
+			if bytes.Equal(prevTx.ID, vin.Txid) {
+				if vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) {
+					return SpentOutput{}, fmt.Errorf("output %s does not exist", Outpoint(vin.Txid, vin.Vout))
+				}
+				return SpentOutput{vin.Txid, vin.Vout, prevTx.Vout[vin.Vout], prev.Height, prevTx.IsCoinbase()}, nil
+			}
+		}
+
+		hash = prev.PrevBlockHash
+	}
+
+	return SpentOutput{}, fmt.Errorf("output %s is unknown", Outpoint(vin.Txid, vin.Vout))
+}
+
+// verifyUTXOSet rebuilds the UTXO set from the blocks and compares it with
+// the chainstate bucket, reporting the first transaction that differs
+func (bc *Blockchain) verifyUTXOSet() error {
+	rebuilt, err := bc.FindUTXO()
+	if err != nil {
+		return err
+	}
+	stored := make(map[string]TXOutputs)
+
+	err = bc.db.View(func(tx storage.Tx) error {
+		b := tx.Bucket([]byte(UTXO_BUCKET))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			outs, err := DeserializeOutputs(v)
+			stored[hex.EncodeToString(k)] = outs
+
+			return err
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	tip, _ := bc.GetBlock(bc.Tip())
+	fail := func(format string, a ...interface{}) error {
+		return &VerifyChainError{tip.Height, tip.Hash, "UTXO set", fmt.Errorf(format, a...)}
+	}
+
+	for _, txID := range sortedTxIDs(rebuilt) {
+		outs, ok := stored[txID]
+		if !ok {
+			return fail("unspent outputs of transaction %s are missing", txID)
+		}
+		if !bytes.Equal(outs.Serialize(), rebuilt[txID].Serialize()) {
+			return fail("unspent outputs of transaction %s differ", txID)
+		}
+	}
+	for _, txID := range sortedTxIDs(stored) {
+		if _, ok := rebuilt[txID]; !ok {
+			return fail("transaction %s has no unspent output", txID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestVerifyChain(t *testing.T) {
+	wallets, address := newWallets(t)
+
+	bc, err := NewBlockchain(storage.NewMemory(), address, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	UTXOSet := UTXOSet{bc}
+	assert.Nil(t, UTXOSet.Reindex())
+
+	// a block spending the genesis coinbase, for the signature checks
+	genesis, _ := bc.GetBlock(bc.Tip())
+	coinbase := genesis.Transactions[0]
+	tx := NewRawTransaction(
+		[]TXInput{{coinbase.ID, 0, nil, nil}},
+		[]TXOutput{newOutput(t, INITIAL_SUBSIDY, address)},
+	)
+	_, _ = SignRawTransaction(tx, wallets, PrevTXsFromOutputs([]PrevOutput{{coinbase.ID, 0, coinbase.Vout[0]}}), SIGHASH_ALL)
+	block, err := UTXOSet.SendRawTransaction(tx, address)
+	assert.Nil(t, err)
+
+	checked, err := bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, checked)
+
+	// the stored UTXO set loses an output
+	_ = bc.db.Update(func(dbTx storage.Tx) error {
+		return dbTx.Bucket([]byte(UTXO_BUCKET)).Delete(tx.ID)
+	})
+	_, err = bc.VerifyChain(3, 0)
+	assert.Nil(t, err, "Blocks are still fine")
+	_, err = bc.VerifyChain(4, 0)
+	assert.Equal(t, "UTXO set", err.(*VerifyChainError).Rule)
+	assert.Nil(t, UTXOSet.Reindex())
+
+	// someone pays themselves more in a stored block
+	block.Transactions[1].Vout[0].Value = 2 * INITIAL_SUBSIDY
+	_ = bc.db.Update(func(dbTx storage.Tx) error {
+		return dbTx.Bucket([]byte(BLOCKS_BUCKET)).Put(block.Hash, block.Serialize())
+	})
+	_, err = bc.VerifyChain(1, 0)
+	assert.Nil(t, err, "Proof of work alone does not see it")
+	_, err = bc.VerifyChain(2, 0)
+	assert.Equal(t, &VerifyChainError{1, block.Hash, "merkle root", err.(*VerifyChainError).Err}, err)
+
+	// or the block is plain corrupted
+	_ = bc.db.Update(func(dbTx storage.Tx) error {
+		return dbTx.Bucket([]byte(BLOCKS_BUCKET)).Put(block.Hash, []byte("garbage"))
+	})
+	_, err = bc.VerifyChain(0, 0)
+	assert.Equal(t, "storage", err.(*VerifyChainError).Rule)
+}
@@ -0,0 +1,412 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/xav-b/blockchain/storage"
+)
+
+// New rules are rolled out as soft forks, following BIP9: every deployment is
+// given a bit of the block version, which miners set once they are ready to
+// enforce the rules. The chain is cut into windows of DeploymentWindow blocks,
+// and the state of a deployment only changes from one window to the next:
+//
+//   - DEFINED until the median time past of the last block of a window
+//     reaches the start time of the deployment, then STARTED
+//   - STARTED: miners signal, and the deployment is LOCKED_IN once Threshold
+//     blocks of a window did. It FAILED once the timeout is reached, even by
+//     a window that signalled enough.
+//   - LOCKED_IN for one more window, leaving the last miners time to
+//     upgrade, then ACTIVE: the blocks must follow the new rules
+//
+// Nodes that do not know about a deployment keep accepting the blocks, the
+// new rules only reject some of what the old ones allowed.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0009.mediawiki
+
+const (
+	// the version of the blocks using version bits starts with 001, leaving
+	// 29 bits to signal. Versions are 32 bits, like in Bitcoin, the mask not
+	// fitting in an int on 32 bits platforms.
+	VERSIONBITS_TOP_BITS        = 0x20000000
+	VERSIONBITS_TOP_MASK uint32 = 0xE0000000
+	VERSIONBITS_NUM_BITS        = 29
+
+	// Bitcoin's window is the difficulty retarget period, 2016 blocks, and
+	// its threshold 95%. Those of regtest are used here.
+	DEPLOYMENT_WINDOW    = 144
+	DEPLOYMENT_THRESHOLD = 108
+
+	// ALWAYS_ACTIVE as a start time skips the deployment, its rules are
+	// enforced from the genesis block
+	ALWAYS_ACTIVE = -1
+	NO_TIMEOUT    = math.MaxInt64
+
+	// DEPLOYMENT_PUBKEYHASH requires the inputs to show the public key
+	// hashing to the output they spend. Without it, any signature is enough.
+	DEPLOYMENT_PUBKEYHASH = "pubkeyhash"
+)
+
+// DeploymentState is the state of a deployment for the blocks of a window
+type DeploymentState int
+
+const (
+	DEFINED DeploymentState = iota
+	STARTED
+	LOCKED_IN
+	ACTIVE
+	FAILED
+)
+
+func (s DeploymentState) String() string {
+	switch s {
+	case DEFINED:
+		return "defined"
+	case STARTED:
+		return "started"
+	case LOCKED_IN:
+		return "locked_in"
+	case ACTIVE:
+		return "active"
+	case FAILED:
+		return "failed"
+	}
+
+	return fmt.Sprintf("unknown (%d)", int(s))
+}
+
+// Deployment is a soft fork rolled out with a bit of the block version
+type Deployment struct {
+	Name string
+	// the bit of the version signalling it, between 0 and 28
+	Bit int
+	// median time past, as Unix times, from which blocks may signal, and
+	// after which the deployment fails if not locked in yet
+	Start   int64
+	Timeout int64
+	// number of blocks of a window signalling to lock it in
+	Threshold int
+}
+
+// DefaultDeployments are the deployments of chains created without
+// parameters. A new chain has no miners to wait for: its rules are all
+// enforced from the genesis block.
+var DefaultDeployments = []Deployment{
+	{DEPLOYMENT_PUBKEYHASH, 0, ALWAYS_ACTIVE, NO_TIMEOUT, DEPLOYMENT_THRESHOLD},
+}
+
+// UpgradeDeployments are the deployments of chains created before version
+// bits, whose blocks followed the old rules: the miners signal the new ones
+// from the first window.
+var UpgradeDeployments = []Deployment{
+	{DEPLOYMENT_PUBKEYHASH, 0, 0, NO_TIMEOUT, DEPLOYMENT_THRESHOLD},
+}
+
+// Signals tells whether a block of the given version signals the deployment
+func (d Deployment) Signals(version int) bool {
+	return usesVersionBits(version) && version&(1<<uint(d.Bit)) != 0
+}
+
+// usesVersionBits tells whether the version is made of version bits, rather
+// than being the one of the blocks mined before them
+func usesVersionBits(version int) bool {
+	return uint32(version)&VERSIONBITS_TOP_MASK == VERSIONBITS_TOP_BITS
+}
+
+// validateDeployments makes sure the deployments can be told apart, and can
+// lock in within a window
+func validateDeployments(deployments []Deployment, window int) error {
+	if window <= 0 {
+		return errors.New("the deployment window must be positive")
+	}
+
+	names := make(map[string]bool)
+	bits := make(map[int]bool)
+	for _, d := range deployments {
+		if d.Name == "" || names[d.Name] {
+			return fmt.Errorf("deployments need distinct names, got %q twice", d.Name)
+		}
+		if d.Bit < 0 || d.Bit >= VERSIONBITS_NUM_BITS || bits[d.Bit] {
+			return fmt.Errorf("deployment %s: bit %d is out of range or taken", d.Name, d.Bit)
+		}
+		if d.Threshold <= 0 || d.Threshold > window {
+			return fmt.Errorf("deployment %s: the threshold must be between 1 and the window of %d blocks", d.Name, window)
+		}
+		if d.Start != ALWAYS_ACTIVE && (d.Start < 0 || d.Timeout <= d.Start) {
+			return fmt.Errorf("deployment %s: the timeout must come after the start", d.Name)
+		}
+		names[d.Name] = true
+		bits[d.Bit] = true
+	}
+
+	return nil
+}
+
+// Rules are the deployments active for a block, by name
+type Rules map[string]bool
+
+// DeploymentInfo is the state of a deployment for the block after the tip
+type DeploymentInfo struct {
+	Deployment
+	State DeploymentState
+	// height of the first block the deployment has had its state since
+	Since int
+	// blocks of the current window so far, and how many of them signal
+	Elapsed int
+	Count   int
+}
+
+// versionBitsCache remembers the state of the deployments for each window,
+// by deployment name and hash of the last block of the previous window.
+// Blocks are identified by hash, so the states survive reorgs.
+type versionBitsCache struct {
+	mu     sync.Mutex
+	states map[string]DeploymentState
+}
+
+func newVersionBitsCache() *versionBitsCache {
+	return &versionBitsCache{states: make(map[string]DeploymentState)}
+}
+
+func (c *versionBitsCache) get(d Deployment, end []byte) (DeploymentState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, ok := c.states[d.Name+string(end)]
+
+	return state, ok
+}
+
+func (c *versionBitsCache) put(d Deployment, end []byte, state DeploymentState) {
+	c.mu.Lock()
+	c.states[d.Name+string(end)] = state
+	c.mu.Unlock()
+}
+
+// window is a window whose state transition is computed
+type window struct {
+	end   []byte
+	mtp   int64
+	count int
+}
+
+// state returns the state of the deployment for the blocks following the
+// window that ends with the block under end, nil before the first window
+func (c *versionBitsCache) state(tx storage.Tx, params Params, d Deployment, end []byte) (DeploymentState, error) {
+	if d.Start == ALWAYS_ACTIVE {
+		return ACTIVE, nil
+	}
+
+	// walk back to a window of known state, the first one being DEFINED
+	var windows []window
+	state := DEFINED
+	for len(end) > 0 {
+		if cached, ok := c.get(d, end); ok {
+			state = cached
+			break
+		}
+
+		mtp, err := medianTimePast(tx, end)
+		if err != nil {
+			return state, err
+		}
+		if mtp < d.Start {
+			// nothing can have happened before the start
+			c.put(d, end, DEFINED)
+			break
+		}
+
+		count, prevEnd, err := countSignals(tx, d, end, params.DeploymentWindow)
+		if err != nil {
+			return state, err
+		}
+		windows = append(windows, window{end, mtp, count})
+		end = prevEnd
+	}
+
+	// then forward, state being the one of the blocks of each window
+	for i := len(windows) - 1; i >= 0; i-- {
+		w := windows[i]
+
+		switch state {
+		case DEFINED:
+			if w.mtp >= d.Timeout {
+				state = FAILED
+			} else if w.mtp >= d.Start {
+				state = STARTED
+			}
+		case STARTED:
+			if w.mtp >= d.Timeout {
+				state = FAILED
+			} else if w.count >= d.Threshold {
+				state = LOCKED_IN
+			}
+		case LOCKED_IN:
+			state = ACTIVE
+		}
+
+		c.put(d, w.end, state)
+	}
+
+	return state, nil
+}
+
+// countSignals counts the blocks of the window ending with the block under
+// end which signal the deployment, and returns the end of the window before
+func countSignals(tx storage.Tx, d Deployment, end []byte, size int) (int, []byte, error) {
+	count := 0
+	hash := end
+
+	for i := 0; i < size && len(hash) > 0; i++ {
+		// the header of a pruned block is enough
+		block, err := getBlock(tx, hash)
+		if err != nil && err != ErrBlockPruned {
+			return 0, nil, err
+		}
+		if d.Signals(block.Version) {
+			count++
+		}
+		hash = block.PrevBlockHash
+	}
+
+	return count, hash, nil
+}
+
+// windowEnd returns the hash of the last block of the window before the one
+// of the block after parent, nil when that block is in the first window. It
+// also returns how many blocks of its own window come before it.
+func windowEnd(tx storage.Tx, parent []byte, size int) ([]byte, int, error) {
+	if len(parent) == 0 {
+		return nil, 0, nil
+	}
+
+	block, err := getBlock(tx, parent)
+	if err != nil && err != ErrBlockPruned {
+		return nil, 0, err
+	}
+	elapsed := (block.Height + 1) % size
+
+	hash := parent
+	for i := 0; i < elapsed && len(hash) > 0; i++ {
+		block, err := getBlock(tx, hash)
+		if err != nil && err != ErrBlockPruned {
+			return nil, 0, err
+		}
+		hash = block.PrevBlockHash
+	}
+
+	return hash, elapsed, nil
+}
+
+// versionBits returns the version of the block after parent, signalling the
+// deployments started or locked in, and the rules it has to follow
+func (bc *Blockchain) versionBits(tx storage.Tx, parent []byte) (int, Rules, error) {
+	params := bc.Params()
+	version := BLOCK_VERSION
+	rules := make(Rules)
+
+	end, _, err := windowEnd(tx, parent, params.DeploymentWindow)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, d := range params.Deployments {
+		state, err := bc.versionBitsCache.state(tx, params, d, end)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch state {
+		case STARTED, LOCKED_IN:
+			// signalling after the lock in changes nothing, but does not hurt
+			version |= 1 << uint(d.Bit)
+		case ACTIVE:
+			rules[d.Name] = true
+		}
+	}
+
+	return version, rules, nil
+}
+
+// nextVersionBits returns the version and the rules of the block after the
+// tip
+func (bc *Blockchain) nextVersionBits() (int, Rules, error) {
+	var version int
+	var rules Rules
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		var err error
+		version, rules, err = bc.versionBits(tx, bc.Tip())
+
+		return err
+	})
+
+	return version, rules, err
+}
+
+// Deployments returns the state of the deployments for the block after the
+// tip, and how far the current window is
+func (bc *Blockchain) Deployments() ([]DeploymentInfo, error) {
+	params := bc.Params()
+	var infos []DeploymentInfo
+
+	err := bc.db.View(func(tx storage.Tx) error {
+		tip := bc.Tip()
+		end, elapsed, err := windowEnd(tx, tip, params.DeploymentWindow)
+		if err != nil {
+			return err
+		}
+		height := -1
+		if len(tip) > 0 {
+			block, err := getBlock(tx, tip)
+			if err != nil && err != ErrBlockPruned {
+				return err
+			}
+			height = block.Height
+		}
+
+		for _, d := range params.Deployments {
+			state, err := bc.versionBitsCache.state(tx, params, d, end)
+			if err != nil {
+				return err
+			}
+			info := DeploymentInfo{Deployment: d, State: state, Elapsed: elapsed}
+
+			// the blocks of the current window
+			if len(tip) > 0 && elapsed > 0 {
+				if info.Count, _, err = countSignals(tx, d, tip, elapsed); err != nil {
+					return err
+				}
+			}
+
+			// the state holds since the first window with another before
+			info.Since = height + 1 - elapsed
+			for since := end; len(since) > 0 && d.Start != ALWAYS_ACTIVE; {
+				_, prevEnd, err := countSignals(tx, d, since, params.DeploymentWindow)
+				if err != nil {
+					return err
+				}
+				prevState, err := bc.versionBitsCache.state(tx, params, d, prevEnd)
+				if err != nil {
+					return err
+				}
+				if prevState != state {
+					break
+				}
+				info.Since -= params.DeploymentWindow
+				since = prevEnd
+			}
+			if d.Start == ALWAYS_ACTIVE {
+				info.Since = 0
+			}
+
+			infos = append(infos, info)
+		}
+
+		return nil
+	})
+
+	return infos, err
+}
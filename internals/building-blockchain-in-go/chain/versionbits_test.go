@@ -0,0 +1,216 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestVersionBits(t *testing.T) {
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	bobWallet, err := wallets.GetWallet(bob)
+	assert.Nil(t, err)
+
+	params := DefaultParams
+	params.DeploymentWindow = 4
+	params.Deployments = []Deployment{
+		{DEPLOYMENT_PUBKEYHASH, 0, 0, NO_TIMEOUT, 3},
+		{"dummy", 1, 0, 1, 3},
+	}
+	bc, err := NewBlockchain(storage.NewMemory(), alice, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+
+	states := func() []DeploymentState {
+		infos, err := bc.Deployments()
+		assert.Nil(t, err)
+
+		return []DeploymentState{infos[0].State, infos[1].State}
+	}
+	assert.Equal(t, []DeploymentState{DEFINED, DEFINED}, states())
+
+	// bob spends alice's genesis coinbase with his own key
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+	theft := NewRawTransaction([]TXInput{{coinbase.ID, 0, nil, bobWallet.PublicKey}}, []TXOutput{newOutput(t, coinbase.Vout[0].Value, bob)})
+	assert.Nil(t, theft.Sign(bobWallet.PrivateKey, map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase}))
+	theft.ID = theft.Hash()
+
+	for height := 1; height <= 3; height++ {
+		block, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, height)})
+		assert.Nil(t, err)
+		assert.Equal(t, BLOCK_VERSION, block.Version, "Nothing to signal in the first window")
+	}
+	assert.Equal(t, []DeploymentState{STARTED, FAILED}, states(), "The dummy timed out")
+	assert.Nil(t, utxo.CheckTransaction(theft), "Not a rule yet")
+
+	// one block of the window does not signal
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	block := newBlock([]*Transaction{newCoinbase(t, alice, 4)}, bc.Tip(), 4, mtp+1)
+	mineBlock(block, pow.Default, pow.MAX_NONCE)
+	assert.Nil(t, bc.ConnectBlock(block))
+	for height := 5; height <= 6; height++ {
+		block, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, height)})
+		assert.Nil(t, err)
+		assert.True(t, params.Deployments[0].Signals(block.Version))
+		assert.False(t, params.Deployments[1].Signals(block.Version))
+	}
+	infos, err := bc.Deployments()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, infos[0].Elapsed)
+	assert.Equal(t, 2, infos[0].Count)
+	assert.Equal(t, 4, infos[0].Since)
+
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 7)})
+	assert.Nil(t, err)
+	assert.Equal(t, []DeploymentState{LOCKED_IN, FAILED}, states())
+	assert.Nil(t, utxo.CheckTransaction(theft), "Only locked in")
+
+	for height := 8; height <= 11; height++ {
+		_, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, height)})
+		assert.Nil(t, err)
+	}
+	infos, err = bc.Deployments()
+	assert.Nil(t, err)
+	assert.Equal(t, ACTIVE, infos[0].State)
+	assert.Equal(t, 12, infos[0].Since)
+	assert.NotNil(t, utxo.CheckTransaction(theft))
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, bob, 12), theft})
+	assert.NotNil(t, err)
+
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+	tx, err := NewUTXOTransaction(aliceWallet, bob, 3, &utxo)
+	assert.Nil(t, err)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 12), tx})
+	assert.Nil(t, err)
+
+	// a new cache gets to the same states
+	bc.versionBitsCache = newVersionBitsCache()
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, []DeploymentState{ACTIVE, FAILED}, states())
+
+	params.Deployments[1].Threshold = 5
+	assert.NotNil(t, params.Validate(), "Above the window")
+}
+
+func TestDeploymentsOfNewChains(t *testing.T) {
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	bobWallet, err := wallets.GetWallet(bob)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	genesis, err := bc.GetBlock(bc.Tip())
+	assert.Nil(t, err)
+	coinbase := genesis.Transactions[0]
+	theft := NewRawTransaction([]TXInput{{coinbase.ID, 0, nil, bobWallet.PublicKey}}, []TXOutput{newOutput(t, coinbase.Vout[0].Value, bob)})
+	assert.Nil(t, theft.Sign(bobWallet.PrivateKey, map[string]Transaction{hex.EncodeToString(coinbase.ID): *coinbase}))
+	theft.ID = theft.Hash()
+
+	// a new chain enforces the rules from the genesis block
+	infos, err := bc.Deployments()
+	assert.Nil(t, err)
+	assert.Equal(t, ACTIVE, infos[0].State)
+	assert.Equal(t, 0, infos[0].Since)
+	assert.NotNil(t, UTXOSet{bc}.CheckTransaction(theft))
+
+	// a chain saved before the deployments rolls them out
+	assert.Nil(t, bc.db.Update(func(tx storage.Tx) error {
+		return tx.Bucket([]byte(META_BUCKET)).Delete([]byte("params"))
+	}))
+	upgraded, err := OpenBlockchain(bc.db)
+	assert.Nil(t, err)
+	assert.Equal(t, UpgradeDeployments, upgraded.Params().Deployments)
+	assert.Equal(t, DEPLOYMENT_WINDOW, upgraded.Params().DeploymentWindow)
+	infos, err = upgraded.Deployments()
+	assert.Nil(t, err)
+	assert.Equal(t, DEFINED, infos[0].State)
+	assert.Nil(t, UTXOSet{upgraded}.CheckTransaction(theft), "Not a rule yet")
+}
+
+func TestDeploymentTimeout(t *testing.T) {
+	_, alice := newWallets(t)
+
+	now := AdjustedTime()
+	params := DefaultParams
+	params.DeploymentWindow = 2
+	params.Deployments = []Deployment{{"late", 2, 0, now + 500, 2}}
+	bc, err := NewBlockchain(storage.NewMemory(), alice, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+
+	connect := func(height int, timestamp int64) {
+		block := newBlock([]*Transaction{newCoinbase(t, alice, height)}, bc.Tip(), height, timestamp)
+		block.Version = BLOCK_VERSION | 1<<2
+		mineBlock(block, pow.Default, pow.MAX_NONCE)
+		assert.Nil(t, bc.ConnectBlock(block))
+	}
+	state := func() DeploymentState {
+		infos, err := bc.Deployments()
+		assert.Nil(t, err)
+
+		return infos[0].State
+	}
+
+	connect(1, now+10)
+	assert.Equal(t, STARTED, state())
+
+	// the whole window signals, but its median time past is past the timeout
+	connect(2, now+1000)
+	connect(3, now+1000)
+	assert.Equal(t, FAILED, state(), "Timeout is checked first")
+}
+
+func TestLoadedDeployments(t *testing.T) {
+	_, alice := newWallets(t)
+
+	var bootstrap, snapshot bytes.Buffer
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, UTXOSet{bc}.Reindex())
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 1)})
+	assert.Nil(t, err)
+	assert.Nil(t, ExportChain(bc, &bootstrap, func(int, int) {}))
+	_, err = DumpTxOutSet(bc, &snapshot)
+	assert.Nil(t, err)
+
+	// the rules are the same on the nodes loading the chain, which never
+	// signalled them
+	imported, err := OpenBlockchain(storage.NewMemory())
+	assert.Nil(t, err)
+	defer imported.Close()
+	_, err = ImportChain(imported, bytes.NewReader(bootstrap.Bytes()), func(*Block) {})
+	assert.Nil(t, err)
+	loaded, err := OpenBlockchain(storage.NewMemory())
+	assert.Nil(t, err)
+	defer loaded.Close()
+	_, err = LoadTxOutSet(loaded, &snapshot)
+	assert.Nil(t, err)
+
+	for _, node := range []*Blockchain{imported, loaded} {
+		assert.Equal(t, bc.Params(), node.Params())
+		infos, err := node.Deployments()
+		assert.Nil(t, err)
+		assert.Equal(t, DEPLOYMENT_PUBKEYHASH, infos[0].Name)
+		assert.Equal(t, ACTIVE, infos[0].State)
+	}
+}
@@ -0,0 +1,215 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+
+	"github.com/xav-b/blockchain/merkle"
+)
+
+// The ID of a transaction is the hash of all of it, signatures included. Yet
+// anyone relaying a transaction can re-encode its signatures (with leading
+// zeros for instance) without invalidating them, which changes its ID: a
+// transaction spending its outputs before it is mined then refers to a
+// transaction that never will be.
+//
+// Segregated witness (BIP141) moves what unlocks the inputs, their signature
+// and public key, out of the transaction, into a witness section that the ID
+// does not commit to. The witness hash, over the whole of it, is what commits
+// to the witnesses: the coinbase of every block carries the merkle root of
+// the witness hashes of its transactions.
+//
+// Transactions without witness keep their inputs signed as before, and so
+// their ID.
+//
+// The ID a transaction comes with is not trusted: checkID hashes it again
+// without the witness, for the witness hash to hold it to its witness.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0141.mediawiki
+
+// WITNESS_COMMITMENT_HEADER marks the witness commitment at the end of the
+// coinbase data, followed by the witness root
+var WITNESS_COMMITMENT_HEADER = []byte{0xaa, 0x21, 0xa9, 0xed}
+
+const WITNESS_COMMITMENT_LEN = 4 + 32
+
+// TXWitness is what unlocks an input of a transaction with witness
+type TXWitness struct {
+	Signature []byte
+	// Raw public key (not hashed)
+	PubKey []byte
+}
+
+// HasWitness tells whether the inputs of the transaction are unlocked by its
+// witness
+func (tx Transaction) HasWitness() bool {
+	return len(tx.Witness) > 0
+}
+
+// WitnessedInput returns the input inID along with what unlocks it, whether
+// it is in the witness or in the input itself
+func (tx Transaction) WitnessedInput(inID int) TXInput {
+	vin := tx.Vin[inID]
+	if tx.HasWitness() {
+		vin.Signature = tx.Witness[inID].Signature
+		vin.PubKey = tx.Witness[inID].PubKey
+	}
+
+	return vin
+}
+
+// serializeNoWitness serializes the transaction without its witness, which is
+// what its ID and the merkle root of its block commit to
+func (tx Transaction) serializeNoWitness() []byte {
+	// gob writes the name and fields of the type out: this is the Transaction
+	// of before witnesses, for the old transactions to keep their ID
+	type Transaction struct {
+		ID   []byte
+		Vin  []TXInput
+		Vout []TXOutput
+	}
+
+	var encoded bytes.Buffer
+
+	enc := gob.NewEncoder(&encoded)
+	// encoding to memory only fails on unsupported types
+	if err := enc.Encode(Transaction{tx.ID, tx.Vin, tx.Vout}); err != nil {
+		panic(err)
+	}
+
+	return encoded.Bytes()
+}
+
+// WitnessHash returns the hash of the transaction with its witness, its ID
+// when it has none. The coinbase witness hash is zero: it cannot commit to
+// itself.
+func (tx *Transaction) WitnessHash() []byte {
+	if tx.IsCoinbase() {
+		return make([]byte, sha256.Size)
+	}
+	if !tx.HasWitness() {
+		return tx.Hash()
+	}
+
+	txCopy := *tx
+	txCopy.ID = []byte{}
+	hash := sha256.Sum256(txCopy.Serialize())
+
+	return hash[:]
+}
+
+// checkWitness makes sure the transaction either unlocks all its inputs in
+// the witness, or none of them: a signature left in an input would still be
+// malleable
+func (tx *Transaction) checkWitness() error {
+	if !tx.HasWitness() {
+		return nil
+	}
+	if tx.IsCoinbase() {
+		return errors.New("coinbase cannot have a witness")
+	}
+	if len(tx.Witness) != len(tx.Vin) {
+		return fmt.Errorf("transaction %x has %d witnesses for %d inputs", tx.ID, len(tx.Witness), len(tx.Vin))
+	}
+
+	for i, vin := range tx.Vin {
+		if len(vin.Signature) != 0 || len(vin.PubKey) != 0 {
+			return fmt.Errorf("input %d of transaction %x is unlocked outside of its witness", i, tx.ID)
+		}
+	}
+
+	return nil
+}
+
+// WitnessRoot returns the merkle root of the witness hashes of the
+// transactions of a block, the coinbase first
+func WitnessRoot(transactions []*Transaction) []byte {
+	var hashes [][]byte
+
+	for _, tx := range transactions {
+		hashes = append(hashes, tx.WitnessHash())
+	}
+
+	return merkle.NewMerkleTree(hashes).RootNode.Data
+}
+
+// WithWitnessCommitment returns a copy of the coinbase committing to the
+// witness root, at the end of its data where rolling the extra nonce leaves
+// it untouched
+func (tx Transaction) WithWitnessCommitment(root []byte) *Transaction {
+	script := append([]byte{}, tx.Vin[0].PubKey...)
+	if _, ok := tx.WitnessCommitment(); ok {
+		script = script[:len(script)-WITNESS_COMMITMENT_LEN]
+	}
+	script = append(script, WITNESS_COMMITMENT_HEADER...)
+	script = append(script, root...)
+
+	tx.Vin = []TXInput{tx.Vin[0]}
+	tx.Vin[0].PubKey = script
+	tx.ID = tx.Hash()
+
+	return &tx
+}
+
+// WitnessCommitment returns the witness root the coinbase commits to, if any
+func (tx Transaction) WitnessCommitment() ([]byte, bool) {
+	if !tx.IsCoinbase() {
+		return nil, false
+	}
+
+	script := tx.Vin[0].PubKey
+	if len(script) < COINBASE_HEIGHT_LEN+WITNESS_COMMITMENT_LEN {
+		return nil, false
+	}
+	commitment := script[len(script)-WITNESS_COMMITMENT_LEN:]
+	if !bytes.Equal(commitment[:len(WITNESS_COMMITMENT_HEADER)], WITNESS_COMMITMENT_HEADER) {
+		return nil, false
+	}
+
+	return commitment[len(WITNESS_COMMITMENT_HEADER):], true
+}
+
+// CommitWitnesses returns the transactions of a block, the coinbase first,
+// with the coinbase committing to their witnesses when some have one
+func CommitWitnesses(transactions []*Transaction) []*Transaction {
+	if !hasWitnesses(transactions) {
+		return transactions
+	}
+
+	committed := append([]*Transaction{}, transactions...)
+	committed[0] = transactions[0].WithWitnessCommitment(WitnessRoot(transactions))
+
+	return committed
+}
+
+func hasWitnesses(transactions []*Transaction) bool {
+	for _, tx := range transactions {
+		if tx.HasWitness() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkWitnessCommitment makes sure the coinbase of a block with witnesses
+// commits to them. A commitment is checked even without witnesses.
+func checkWitnessCommitment(block *Block) error {
+	root, ok := block.Transactions[0].WitnessCommitment()
+	if !ok {
+		if hasWitnesses(block.Transactions) {
+			return errors.New("coinbase does not commit to the witnesses")
+		}
+
+		return nil
+	}
+
+	if !bytes.Equal(root, WitnessRoot(block.Transactions)) {
+		return errors.New("coinbase commits to other witnesses")
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package chain
+
+import (
+	"crypto/elliptic"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/storage"
+)
+
+func TestSegregatedWitness(t *testing.T) {
+	// transactions without witness keep the IDs they had before witnesses
+	coinbase := NewPayoutCoinbaseTX("data", 1, []TXOutput{{5, []byte("abc")}})
+	legacy := NewRawTransaction([]TXInput{{coinbase.ID, 0, []byte("sig"), []byte("pub")}}, []TXOutput{{3, []byte("x")}})
+	block := &Block{Transactions: []*Transaction{coinbase, legacy}}
+	assert.Equal(t, "6ea34d3bf652ef06d8966ee1351298b7169aa1dc1fa9743c06f01c2afd0ee375", hex.EncodeToString(coinbase.ID))
+	assert.Equal(t, "adf317900da693e30c2bbec24dc0ee045b3c8c5b0e18905d1d0c5513eb14898a", hex.EncodeToString(legacy.ID))
+	assert.Equal(t, "34560ad7b31b0068918609c462c42aec679614d574b42171332ef7f1156c0aa2", hex.EncodeToString(block.HashTransactions()))
+	assert.Equal(t, legacy.ID, legacy.WitnessHash())
+
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+
+	tx, err := NewUTXOTransaction(aliceWallet, bob, 3, &utxo)
+	assert.Nil(t, err)
+	assert.True(t, tx.HasWitness())
+	assert.Nil(t, tx.Vin[0].Signature)
+	txID := tx.ID
+
+	// bob spends it before it is mined
+	child := newSignedTx(t, wallets, alice, tx, 3, 0)
+
+	// (r, -s) is as valid as (r, s): relaying the transaction with it only
+	// changes its witness hash
+	signature, hashType, _ := splitSignature(tx.Witness[0].Signature)
+	r, s := decodePoint(signature)
+	s.Sub(elliptic.P256().Params().N, s)
+	wtxid := tx.WitnessHash()
+	tx.Witness[0].Signature = append(encodePoint(r, s), byte(hashType))
+	assert.Nil(t, utxo.CheckTransaction(tx))
+	assert.Equal(t, txID, tx.Hash())
+	assert.NotEqual(t, wtxid, tx.WitnessHash())
+
+	// the coinbase commits to the witnesses, and the child still connects
+	mined, err := bc.AddBlock([]*Transaction{newCoinbase(t, alice, 1), tx})
+	assert.Nil(t, err)
+	root, ok := mined.Transactions[0].WitnessCommitment()
+	assert.True(t, ok)
+	assert.Equal(t, WitnessRoot(mined.Transactions), root)
+	_, err = bc.AddBlock([]*Transaction{newCoinbase(t, alice, 2), child})
+	assert.Nil(t, err)
+
+	// a block whose coinbase does not commit to its witnesses is rejected
+	spend, err := NewUTXOTransaction(aliceWallet, bob, 1, &utxo)
+	assert.Nil(t, err)
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	block = newBlock([]*Transaction{newCoinbase(t, alice, 3), spend}, bc.Tip(), 3, mtp+1)
+	mineBlock(block, pow.Default, pow.MAX_NONCE)
+	assert.NotNil(t, bc.ConnectBlock(block))
+
+	// nor can the signature stay in the input
+	spend.Vin[0].Signature = spend.Witness[0].Signature
+	assert.NotNil(t, utxo.CheckTransaction(spend))
+	spend.Vin[0].Signature = nil
+	assert.Nil(t, utxo.CheckTransaction(spend))
+
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
+
+func TestWitnessForgedID(t *testing.T) {
+	wallets, alice := newWallets(t)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(storage.NewMemory(), alice, DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	utxo := UTXOSet{bc}
+	assert.Nil(t, utxo.Reindex())
+
+	tx, err := NewUTXOTransaction(aliceWallet, bob, 3, &utxo)
+	assert.Nil(t, err)
+	txID := tx.ID
+
+	mtp, err := bc.MedianTimePast()
+	assert.Nil(t, err)
+	submit := func() error {
+		block := newBlock(CommitWitnesses([]*Transaction{newCoinbase(t, alice, 1), tx}), bc.Tip(), 1, nextTimestamp(mtp))
+		mineBlock(block, pow.Default, pow.MAX_NONCE)
+		assert.Nil(t, checkWitnessCommitment(block))
+		block, err := DeserializeBlock(block.Serialize())
+		assert.Nil(t, err)
+
+		return bc.ConnectBlock(block)
+	}
+
+	// the witness is committed to, but the ID it comes with is made up
+	tx.ID = tx.WitnessHash()
+	assert.NotNil(t, utxo.CheckTransaction(tx))
+	err = submit()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "does not hash to its ID")
+
+	tx.ID = txID
+	assert.Nil(t, submit())
+	_, err = bc.VerifyChain(MAX_CHECK_LEVEL, 0)
+	assert.Nil(t, err)
+}
@@ -1,153 +1,1263 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"math"
+	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/pool"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/schnorr"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
 )
 
-// TODO: flag for difficulty mining
-// TODO: flag for database
-type CLI struct{}
+type CLI struct {
+	// directory of the database, the wallet file and the cookie, set by the
+	// -datadir flag every command takes
+	dataDir string
+}
+
+func (cli *CLI) printUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("\tEvery command takes -datadir DIR, where the database, wallet and cookie files are (default: current directory)")
+	fmt.Println("\tcreateblockchain -address ADDRESS [-prune N -subsidy N -halving N -maturity N -consensus pow|poa|pos -signers ADDRESSES -stakelock N -powhash sha256|scrypt|argon2 -powbits N -window N -threshold N] - Create a blockchain and send genesis block reward to ADDRESS. A proof of authority chain is signed in turn by the comma separated signers, the genesis reward is the first stake of a proof of stake chain. A memory-hard -powhash needs fewer -powbits")
+	fmt.Println("\tls - print all the blocks of the blockchain")
+	fmt.Println("\treindexutxo - Rebuilds the UTXO set")
+	fmt.Println("\tcreatewallet - Generates a new key-pair and saves it into the wallet file")
+	fmt.Println("\twallets - Lists all addresses from the wallet file")
+	fmt.Println("\tbalance -address ADDRESS - Get balance of ADDRESS")
+	fmt.Println("\tsend -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
+	fmt.Println("\tstake -from FROM -amount AMOUNT - Lock AMOUNT of coins of FROM as its stake, to propose blocks of a proof of stake chain")
+	fmt.Println("\tunstake -address ADDRESS - Take back the stake of ADDRESS, once unlocked")
+	fmt.Println("\tstakes - List the validators of a proof of stake chain, and the proposer of the next block")
+	fmt.Println("\tcreaterawtransaction -inputs TXID:VOUT[,...] -outputs ADDRESS:AMOUNT[,...] - Create an unsigned transaction spending exactly the given outputs")
+	fmt.Println("\tsignrawtransaction -hex HEX [-prevouts TXID:VOUT:ADDRESS:AMOUNT[,...] -sighash ALL|NONE|SINGLE[|ANYONECANPAY]] - Sign the inputs of a raw transaction with the keys of the wallet file, the chain is only read for missing previous outputs. The sighash type tells what the signatures cover: all the outputs, none, or the one of the same index, and all the inputs unless ANYONECANPAY")
+	fmt.Println("\tdecoderawtransaction -hex HEX - Print a raw transaction")
+	fmt.Println("\tsendrawtransaction -hex HEX -rewardaddress ADDRESS - Validate a signed raw transaction and mine it, sending the block reward to ADDRESS")
+	fmt.Println("\tcreatepsbt -inputs TXID:VOUT[,...] -outputs ADDRESS:AMOUNT[,...] [-prevouts TXID:VOUT:ADDRESS:AMOUNT[,...]] - Create a partially signed transaction carrying the outputs it spends")
+	fmt.Println("\tsignpsbt -psbt PSBT - Add the signatures of the keys of the wallet file, no chain needed")
+	fmt.Println("\tcombinepsbt -psbts PSBT,PSBT[,...] - Merge the signatures of several copies of a PSBT")
+	fmt.Println("\tfinalizepsbt -psbt PSBT - Turn a fully signed PSBT into a raw transaction")
+	fmt.Println("\tmusigaddress -keys ADDRESS|PUBKEY,... - Print the address locked by the aggregate of the Schnorr keys of the addresses of the wallet file, or x-only keys in hex, spent with a single signature by all of them")
+	fmt.Println("\tcreatemusig -hex HEX -keys ADDRESS|PUBKEY,... -file FILE [-input N -prevouts TXID:VOUT:ADDRESS:AMOUNT[,...] -sighash TYPE] - Start the joint signature of an input of a raw transaction, locked by the aggregate of the keys, in a session file")
+	fmt.Println("\tmusignonce -session FILE -address ADDRESS -file FILE - Commit to a nonce for the session, written to FILE for the other signers. Its secret part stays in the data directory until it signs")
+	fmt.Println("\tsignmusig -session FILE -address ADDRESS -nonces FILE,... -file FILE - Write the partial signature of ADDRESS to FILE, with the nonces of all the signers")
+	fmt.Println("\tfinalizemusig -session FILE -nonces FILE,... -partialsigs FILE,... - Combine the partial signatures of all the signers into the raw transaction")
+	fmt.Println("\texportchain -file FILE - Write the blocks to a bootstrap file")
+	fmt.Println("\timportchain -file FILE [-prune N -subsidy N -halving N -maturity N -consensus pow|poa|pos -signers ADDRESSES -stakelock N -powhash sha256|scrypt|argon2 -powbits N -window N -threshold N] - Validate and connect the blocks of a bootstrap file, resuming a previous import. The parameters are given for files written without them")
+	fmt.Println("\tsupply - Show the coins issued so far and the most there will ever be")
+	fmt.Println("\tgetdeploymentinfo - Show the state of the soft forks rolled out with version bits, which change every -window blocks once -threshold of them signal")
+	fmt.Println("\tgettxoutsetinfo - Summarize the UTXO set, with a hash to compare it between nodes")
+	fmt.Println("\tdumptxoutset -file FILE - Write a snapshot of the UTXO set along with the block headers")
+	fmt.Println("\tloadtxoutset -file FILE [-subsidy N -halving N -maturity N -consensus pow|poa|pos -signers ADDRESSES -stakelock N -powhash sha256|scrypt|argon2 -powbits N -window N -threshold N] - Start an empty chain from a snapshot, importchain then verifies it against the history. The parameters are given for snapshots written without them")
+	fmt.Println("\tverifychain [-level N -depth M] - Check the last M blocks (all if 0) up to level N: 0 storage, 1 proof of work, 2 merkle root, 3 transactions, 4 UTXO set")
+	fmt.Println("\tdisconnectblock - Disconnect the tip of the chain, restoring the UTXO set from its undo data")
+	fmt.Println("\tbenchmark [-duration D -threads N -bits N] - Compare the hashrates of the proof of work hash functions, and the time they take to mine a block of N bits")
+	fmt.Println("\tserve [-rpcaddr ADDR -rpcuser USER -rpcpassword PASSWORD -exploreraddr ADDR -prune N -pooladdr ADDR -pooladdress ADDRESS -sharebits N] - Run a JSON-RPC daemon and the block explorer, and a mining pool with -pooladdr. Other commands go through it while it runs")
+}
+
+// daemon returns a client to the running `bc serve` daemon, if any. bolt only
+// lets one process open the database so while the daemon runs, commands have
+// to go through it.
+func (cli *CLI) daemon() *RPCClient {
+	client, err := DialDaemon(cli.dataDir)
+	if err == ErrNoDaemon {
+		return nil
+	} else if err != nil {
+		log.Panic(err)
+	}
+
+	return client
+}
+
+// openDB opens the local database. Commands going through the daemon dial it
+// first, the others cannot run along with it.
+func (cli *CLI) openDB() storage.Store {
+	db, err := chain.OpenDB(cli.dataDir)
+	if err == chain.ErrDBLocked {
+		fmt.Fprintf(os.Stderr, "ERROR: %s\nThis command does not go through the daemon, stop it first\n", err)
+		os.Exit(1)
+	} else if err != nil {
+		log.Panic(err)
+	}
+
+	return db
+}
+
+// openBlockchain opens the local chain, which must exist unless empty is set.
+// The library returns errors, the CLI has nothing better to do than give up.
+func (cli *CLI) openBlockchain(empty bool) *chain.Blockchain {
+	db := cli.openDB()
+	bc, err := chain.OpenBlockchain(db)
+	if err != nil {
+		db.Close()
+		log.Panic(err)
+	}
+
+	if !empty && bc.Tip() == nil {
+		bc.Close()
+		log.Panic("ERROR: No blockchain found, create one first")
+	}
+	cli.authorize(bc)
+
+	return bc
+}
+
+// authorize lets a proof of authority or of stake chain sign blocks with the
+// keys of the wallet file, those of its signers or validators
+func (cli *CLI) authorize(bc *chain.Blockchain) {
+	engine, ok := bc.Engine().(chain.Authorizer)
+	if !ok {
+		return
+	}
+
+	wallets, err := wallet.NewWallets(walletFile(cli.dataDir))
+	if err != nil && !os.IsNotExist(err) {
+		log.Panic(err)
+	}
+	for _, w := range wallets.Wallets {
+		engine.Authorize(w.PrivateKey)
+	}
+}
+
+// loadWallet returns the wallet of the wallet file holding the keys of address
+func (cli *CLI) loadWallet(address string) *wallet.Wallet {
+	wallets, err := wallet.NewWallets(walletFile(cli.dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return w
+}
+
+// walletFile is the path of the wallet file in dataDir
+func walletFile(dataDir string) string {
+	return filepath.Join(dataDir, wallet.WALLET_FILE)
+}
+
+func (cli *CLI) validateArgs() {
+	if len(os.Args) < 2 {
+		cli.printUsage()
+		os.Exit(1)
+	}
+}
+
+func (cli *CLI) createBlockchain(address string, prune int, params chain.Params) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	// TODO: overwrite behavior or manually delete the database
+	db := cli.openDB()
+	bc, err := chain.NewBlockchain(db, address, params)
+	if err != nil {
+		db.Close()
+		log.Panic(err)
+	}
+	defer bc.Close()
+
+	fmt.Println("initializing UTXO set")
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	fmt.Println("reindexing UTXO set")
+	if err := UTXOSet.Reindex(); err != nil {
+		log.Panic(err)
+	}
+	cli.setPrune(bc, prune)
+
+	fmt.Println("Done!")
+}
+
+// withParams returns params with the ones given on the command line, flags
+// left at 0 keeping the current values
+func withParams(params, flags chain.Params) chain.Params {
+	if flags.InitialSubsidy != 0 {
+		params.InitialSubsidy = flags.InitialSubsidy
+	}
+	if flags.HalvingInterval != 0 {
+		params.HalvingInterval = flags.HalvingInterval
+	}
+	if flags.CoinbaseMaturity != 0 {
+		params.CoinbaseMaturity = flags.CoinbaseMaturity
+	}
+	if flags.Consensus != "" {
+		params.Consensus = flags.Consensus
+	}
+	if flags.Signers != nil {
+		params.Signers = flags.Signers
+	}
+	if flags.StakeLock >= 0 {
+		params.StakeLock = flags.StakeLock
+	}
+	if flags.PowHash != "" {
+		params.PowHash = flags.PowHash
+	}
+	if flags.PowBits != 0 {
+		params.PowBits = flags.PowBits
+	}
+	if flags.Deployments != nil {
+		params.Deployments = flags.Deployments
+	}
+	if flags.DeploymentWindow != 0 {
+		params.DeploymentWindow = flags.DeploymentWindow
+	}
+
+	return params
+}
+
+// setParams sets the consensus parameters asked for on an empty chain, before
+// loading blocks created with them. A resumed import keeps the current ones.
+func (cli *CLI) setParams(bc *chain.Blockchain, flags chain.Params) {
+	params := withParams(bc.Params(), flags)
+	if reflect.DeepEqual(params, bc.Params()) {
+		return
+	}
+
+	if err := bc.SetParams(params); err != nil {
+		log.Panic(err)
+	}
+}
+
+// setPrune enables prune mode when asked, it then stays on for the following
+// commands
+func (cli *CLI) setPrune(bc *chain.Blockchain, depth int) {
+	if depth == 0 {
+		return
+	}
+
+	if err := bc.SetPruneDepth(depth); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("prune mode: keeping the last %d blocks\n", depth)
+}
+
+func (cli *CLI) disconnectBlock() {
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	block, err := bc.DisconnectTip()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Disconnected block %d %x\n", block.Height, block.Hash)
+}
+
+func (cli *CLI) reindexUTXO() {
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	if err := UTXOSet.Reindex(); err != nil {
+		log.Panic(err)
+	}
+
+	count, err := UTXOSet.CountTransactions()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+}
+
+func (cli *CLI) getBalance(address string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	var balances BalancesJSON
+	if rpc := cli.daemon(); rpc != nil {
+		if err := rpc.Call("getbalances", &balances, address); err != nil {
+			log.Panic(err)
+		}
+		printBalances(address, balances)
+		return
+	}
+
+	bc := cli.openBlockchain(false)
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	defer bc.Close()
+
+	pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	balances.Spendable, balances.Immature, err = UTXOSet.Balance(pubKeyHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	spendable, immature, err := UTXOSet.Balance(chain.StakePubKeyHash(pubKeyHash))
+	if err != nil {
+		log.Panic(err)
+	}
+	balances.Staked = spendable + immature
+
+	printBalances(address, balances)
+}
+
+func printBalances(address string, balances BalancesJSON) {
+	fmt.Printf("Balance of '%s': %d\n", address, balances.Spendable)
+	if balances.Immature > 0 {
+		fmt.Printf("  immature coinbase: %d\n", balances.Immature)
+	}
+	if balances.Staked > 0 {
+		fmt.Printf("  staked: %d\n", balances.Staked)
+	}
+}
+
+func (cli *CLI) createWallet() {
+	if rpc := cli.daemon(); rpc != nil {
+		var address string
+		if err := rpc.Call("getnewaddress", &address); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Your new address: %s\n", address)
+		return
+	}
+
+	// there is no wallet file yet the first time
+	wallets, _ := wallet.NewWallets(walletFile(cli.dataDir))
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Your new address: %s\n", address)
+}
+
+func (cli *CLI) listAddresses() {
+	wallets, err := wallet.NewWallets(walletFile(cli.dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
+	addresses := wallets.GetAddresses()
+
+	for _, address := range addresses {
+		fmt.Println(address)
+	}
+}
+
+func (cli *CLI) printChain() {
+	if rpc := cli.daemon(); rpc != nil {
+		cli.printRemoteChain(rpc)
+		return
+	}
+
+	// TODO: handle better new vs loading blochains. API is bad and there's too
+	// much assumptions here
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	bci := bc.Iterator()
+
+	for bc.Tip() != nil {
+		block, err := bci.Next()
+		if err != nil {
+			log.Panic(err)
+		}
+		cli.printBlock(bc.Engine(), block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+}
+
+// printRemoteChain walks the chain of the daemon from its tip, fetching the
+// raw blocks so they print exactly like local ones
+func (cli *CLI) printRemoteChain(rpc *RPCClient) {
+	var info BlockchainInfo
+	if err := rpc.Call("getblockchaininfo", &info); err != nil {
+		log.Panic(err)
+	}
+
+	engine, err := chain.NewEngine(chain.Params{
+		Consensus: info.Consensus,
+		Signers:   info.Signers,
+		StakeLock: info.StakeLock,
+		PowHash:   info.PowHash,
+		PowBits:   info.Difficulty,
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+
+	blockHash := info.BestBlockHash
+	for blockHash != "" {
+		var blockJSON BlockJSON
+		if err := rpc.Call("getblock", &blockJSON, blockHash, 1); err != nil {
+			log.Panic(err)
+		}
+
+		// only the header is left of pruned blocks
+		block := blockJSON.Header().Block()
+		if !blockJSON.Pruned {
+			var rawBlock string
+			if err := rpc.Call("getblock", &rawBlock, blockHash, 0); err != nil {
+				log.Panic(err)
+			}
+			var err error
+			if block, err = chain.DeserializeBlock(decodeHex(rawBlock)); err != nil {
+				log.Panic(err)
+			}
+		}
+
+		cli.printBlock(engine, block)
+
+		blockHash = hex.EncodeToString(block.PrevBlockHash)
+	}
+}
+
+func (cli *CLI) printBlock(engine chain.ConsensusEngine, block *chain.Block) {
+	fmt.Printf("\n============ Block %x ============\n", block.Hash)
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
+	fmt.Printf("Seal (%s): %s\n\n", engine.Name(), strconv.FormatBool(chain.VerifyHeader(engine, block) == nil))
+	if block.IsPruned() {
+		fmt.Println("(transactions pruned)")
+	}
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Printf("\n\n")
+}
+
+func (cli *CLI) send(from, to string, amount int) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("ERROR: Sender address is not valid")
+	}
+	if !wallet.ValidateAddress(to) {
+		log.Panic("ERROR: Recipient address is not valid")
+	}
+
+	if rpc := cli.daemon(); rpc != nil {
+		var txid string
+		if err := rpc.Call("sendtoaddress", &txid, to, amount, from); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Success! Transaction %s\n", txid)
+		return
+	}
+
+	fmt.Println("initializing a new transaction")
+	sender := cli.loadWallet(from)
+	bc := cli.openBlockchain(false)
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	defer bc.Close()
+
+	fmt.Printf("creating the coinbase tx, reward to %s\n", from)
+	cbTx, err := bc.NewCoinbase(from)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("creating the actual transaction of %d bitcoins\n", amount)
+	tx, err := chain.NewUTXOTransaction(sender, to, amount, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+	txs := []*chain.Transaction{cbTx, tx}
+
+	fmt.Println("mining the new block, and updating the UTXO set")
+	block, err := bc.AddBlock(txs)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Success! Block %d %x\n", block.Height, block.Hash)
+}
+
+// stake locks coins of the validator in a new block, which it is rewarded
+func (cli *CLI) stake(from string, amount int) {
+	if !wallet.ValidateAddress(from) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	validator := cli.loadWallet(from)
+	bc := cli.openBlockchain(false)
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	defer bc.Close()
+
+	tx, err := chain.NewStakeTX(validator, amount, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.addBlock(bc, from, tx)
+
+	fmt.Printf("Success! %d coins staked\n", amount)
+}
+
+// unstake gives the unlocked stake of the validator back, in a new block
+func (cli *CLI) unstake(address string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("ERROR: Address is not valid")
+	}
+
+	validator := cli.loadWallet(address)
+	bc := cli.openBlockchain(false)
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	defer bc.Close()
+
+	tx, err := chain.NewUnstakeTX(validator, &UTXOSet)
+	if err != nil {
+		log.Panic(err)
+	}
+	cli.addBlock(bc, address, tx)
+
+	fmt.Printf("Success! %d coins unstaked\n", tx.Vout[0].Value)
+}
+
+// addBlock adds a block with the transaction, rewarding address
+func (cli *CLI) addBlock(bc *chain.Blockchain, address string, tx *chain.Transaction) {
+	cbTx, err := bc.NewCoinbase(address)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, err := bc.AddBlock([]*chain.Transaction{cbTx, tx}); err != nil {
+		log.Panic(err)
+	}
+}
+
+func (cli *CLI) listStakes() {
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	pos, ok := bc.Engine().(*chain.ProofOfStake)
+	if !ok {
+		log.Panic("ERROR: The chain is not a proof of stake")
+	}
+	stakes, err := chain.UTXOSet{Blockchain: bc}.Stakes()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	total := stakes.Total()
+	for _, stake := range stakes {
+		fmt.Printf("%s: %d (%.1f%%)\n", wallet.PubKeyHashToAddress(stake.PubKeyHash), stake.Value, 100*float64(stake.Value)/float64(total))
+	}
+
+	bestHeight, err := bc.GetBestHeight()
+	if err != nil {
+		log.Panic(err)
+	}
+	proposer, err := pos.Proposer(&chain.Block{PrevBlockHash: bc.Tip(), Height: bestHeight + 1})
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Proposer of block %d: %s\n", bestHeight+1, proposer)
+}
+
+func (cli *CLI) createRawTransaction(inputs, outputs string) {
+	tx := cli.parseRawTransaction(inputs, outputs)
+	fmt.Println(chain.EncodeRawTransaction(tx))
+}
+
+// parseRawTransaction builds an unsigned transaction out of the TXID:VOUT
+// inputs and ADDRESS:AMOUNT outputs lists
+func (cli *CLI) parseRawTransaction(inputs, outputs string) *chain.Transaction {
+	var vin []chain.TXInput
+	for _, input := range splitList(inputs) {
+		fields := strings.Split(input, ":")
+		if len(fields) != 2 {
+			log.Panicf("ERROR: Input %s is not TXID:VOUT", input)
+		}
+		vin = append(vin, chain.TXInput{Txid: decodeHex(fields[0]), Vout: parseInt(fields[1])})
+	}
+
+	var vout []chain.TXOutput
+	for _, output := range splitList(outputs) {
+		fields := strings.Split(output, ":")
+		if len(fields) != 2 || !wallet.ValidateAddress(fields[0]) {
+			log.Panicf("ERROR: Output %s is not ADDRESS:AMOUNT", output)
+		}
+		vout = append(vout, *cli.newOutput(parseInt(fields[1]), fields[0]))
+	}
+
+	return chain.NewRawTransaction(vin, vout)
+}
+
+func (cli *CLI) signRawTransaction(rawTx, prevouts, sigHash string) {
+	tx, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		log.Panic(err)
+	}
+	hashType, err := chain.ParseSigHashType(sigHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	prevTXs := cli.prevTXs(tx, prevouts)
+
+	wallets, err := wallet.NewWallets(walletFile(cli.dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	complete, err := chain.SignRawTransaction(tx, wallets, prevTXs, hashType)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(chain.EncodeRawTransaction(tx))
+	fmt.Printf("complete: %t\n", complete)
+}
+
+// prevTXs gathers the outputs spent by tx, from the TXID:VOUT:ADDRESS:AMOUNT
+// list or else from the chain
+func (cli *CLI) prevTXs(tx *chain.Transaction, prevouts string) map[string]chain.Transaction {
+	var prevOuts []chain.PrevOutput
+	for _, prevout := range splitList(prevouts) {
+		fields := strings.Split(prevout, ":")
+		if len(fields) != 4 || !wallet.ValidateAddress(fields[2]) {
+			log.Panicf("ERROR: Previous output %s is not TXID:VOUT:ADDRESS:AMOUNT", prevout)
+		}
+		output := cli.newOutput(parseInt(fields[3]), fields[2])
+		prevOuts = append(prevOuts, chain.PrevOutput{Txid: decodeHex(fields[0]), Vout: parseInt(fields[1]), Output: *output})
+	}
+	prevTXs := chain.PrevTXsFromOutputs(prevOuts)
+
+	// only look at the chain for the previous outputs we were not given
+	for _, vin := range tx.Vin {
+		prevTx, ok := prevTXs[hex.EncodeToString(vin.Txid)]
+		if !ok || vin.Vout < 0 || vin.Vout >= len(prevTx.Vout) || prevTx.Vout[vin.Vout].PubKeyHash == nil {
+			prevTXs[hex.EncodeToString(vin.Txid)] = cli.findTransaction(vin.Txid)
+		}
+	}
+
+	return prevTXs
+}
+
+// findTransaction fetches a transaction from the daemon, if running, or from
+// the local chain
+func (cli *CLI) findTransaction(txid []byte) chain.Transaction {
+	if rpc := cli.daemon(); rpc != nil {
+		var rawTx string
+		if err := rpc.Call("getrawtransaction", &rawTx, hex.EncodeToString(txid)); err != nil {
+			log.Panic(err)
+		}
+		tx, err := chain.DecodeRawTransaction(rawTx)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		return *tx
+	}
+
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	tx, err := bc.FindTransaction(txid)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return tx
+}
+
+func (cli *CLI) decodeRawTransaction(rawTx string) {
+	tx, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(tx)
+}
+
+func (cli *CLI) sendRawTransaction(rawTx, rewardAddress string) {
+	if !wallet.ValidateAddress(rewardAddress) {
+		log.Panic("ERROR: Reward address is not valid")
+	}
+
+	if rpc := cli.daemon(); rpc != nil {
+		var txid string
+		if err := rpc.Call("sendrawtransaction", &txid, rawTx, rewardAddress); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Success! Transaction %s\n", txid)
+		return
+	}
+
+	tx, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+
+	if _, err := UTXOSet.SendRawTransaction(tx, rewardAddress); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Success! Transaction %x\n", tx.ID)
+}
+
+func (cli *CLI) createPSBT(inputs, outputs, prevouts string) {
+	tx := cli.parseRawTransaction(inputs, outputs)
+
+	psbt, err := chain.NewPSBT(tx, cli.prevTXs(tx, prevouts))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(chain.EncodePSBT(psbt))
+}
+
+func (cli *CLI) signPSBT(encoded string) {
+	psbt, err := chain.DecodePSBT(encoded)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	wallets, err := wallet.NewWallets(walletFile(cli.dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	signed, err := psbt.Sign(wallets)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(chain.EncodePSBT(psbt))
+	fmt.Printf("signed: %d input(s)\n", signed)
+}
+
+func (cli *CLI) combinePSBT(encoded string) {
+	var psbts []*chain.PSBT
+	for _, e := range splitList(encoded) {
+		psbt, err := chain.DecodePSBT(e)
+		if err != nil {
+			log.Panic(err)
+		}
+		psbts = append(psbts, psbt)
+	}
+
+	combined, err := chain.CombinePSBTs(psbts)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(chain.EncodePSBT(combined))
+}
+
+func (cli *CLI) finalizePSBT(encoded string) {
+	psbt, err := chain.DecodePSBT(encoded)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	tx, err := psbt.Finalize()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(chain.EncodeRawTransaction(tx))
+}
+
+// musigKeys returns the x-only keys of the comma separated list of addresses
+// of the wallet file and hex encoded keys
+func (cli *CLI) musigKeys(list string) [][]byte {
+	var wallets *wallet.Wallets
+	var pubKeys [][]byte
+
+	for _, key := range splitList(list) {
+		if len(key) == 2*schnorr.PUBKEY_SIZE {
+			pubKeys = append(pubKeys, decodeHex(key))
+			continue
+		}
+
+		if wallets == nil {
+			var err error
+			if wallets, err = wallet.NewWallets(walletFile(cli.dataDir)); err != nil {
+				log.Panic(err)
+			}
+		}
+		w, err := wallets.GetWallet(key)
+		if err != nil {
+			log.Panic(err)
+		}
+		pubKeys = append(pubKeys, schnorr.PublicKey(&w.PrivateKey))
+	}
+
+	return pubKeys
+}
+
+// readMuSigSession reads a session file
+func readMuSigSession(file string) *chain.MuSigSession {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	session, err := chain.DecodeMuSigSession(strings.TrimSpace(string(data)))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return session
+}
+
+// writeMuSigFile writes what a signer shares with the others, prefixed with
+// its key: its nonce or its partial signature
+func writeMuSigFile(file string, pubKey, data []byte) {
+	content := fmt.Sprintf("%x %x\n", pubKey, data)
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		log.Panic(err)
+	}
+}
+
+// readMuSigFiles reads the files written by writeMuSigFile, and returns
+// their data in the order of the keys of the session
+func readMuSigFiles(session *chain.MuSigSession, files string) [][]byte {
+	byKey := make(map[string][]byte)
+	for _, file := range splitList(files) {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Panic(err)
+		}
+		fields := strings.Fields(string(content))
+		if len(fields) != 2 {
+			log.Panicf("ERROR: %s is not a nonce or partial signature file", file)
+		}
+		byKey[fields[0]] = decodeHex(fields[1])
+	}
+
+	var data [][]byte
+	for _, pubKey := range session.PubKeys {
+		d, ok := byKey[hex.EncodeToString(pubKey)]
+		if !ok {
+			log.Panicf("ERROR: nothing from signer %x", pubKey)
+		}
+		data = append(data, d)
+	}
+
+	return data
+}
+
+// secNonceFile is where the secret nonce of a signer waits for it to sign
+func (cli *CLI) secNonceFile(session *chain.MuSigSession, pubKey []byte) string {
+	msg, err := session.Message()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return filepath.Join(cli.dataDir, fmt.Sprintf("musig_%x_%x.secnonce", msg[:8], pubKey[:8]))
+}
+
+// signerWallet returns the wallet of a signer of the session
+func (cli *CLI) signerWallet(address string) *wallet.Wallet {
+	wallets, err := wallet.NewWallets(walletFile(cli.dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
+	w, err := wallets.GetWallet(address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return w
+}
+
+func (cli *CLI) musigAddress(keys string) {
+	address, err := chain.AggregateAddress(cli.musigKeys(keys))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Println(address)
+}
+
+func (cli *CLI) createMuSig(rawTx, keys, prevouts, sigHash, file string, input int) {
+	tx, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		log.Panic(err)
+	}
+	hashType, err := chain.ParseSigHashType(sigHash)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	session, err := chain.NewMuSigSession(tx, input, cli.prevTXs(tx, prevouts), cli.musigKeys(keys), hashType)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	if err := ioutil.WriteFile(file, []byte(chain.EncodeMuSigSession(session)+"\n"), 0644); err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Session of %d signers written to %s\n", len(session.PubKeys), file)
+}
+
+func (cli *CLI) musigNonce(sessionFile, address, file string) {
+	session := readMuSigSession(sessionFile)
+	w := cli.signerWallet(address)
+	pubKey := schnorr.PublicKey(&w.PrivateKey)
+
+	// a second nonce would make the first one shared useless
+	secNonceFile := cli.secNonceFile(session, pubKey)
+	if _, err := os.Stat(secNonceFile); err == nil {
+		log.Panicf("ERROR: %s already committed to a nonce for this session", address)
+	}
+
+	secNonce, pubNonce, err := schnorr.NewNonce()
+	if err != nil {
+		log.Panic(err)
+	}
+	if err := ioutil.WriteFile(secNonceFile, secNonce, 0600); err != nil {
+		log.Panic(err)
+	}
+	writeMuSigFile(file, pubKey, pubNonce)
+	fmt.Printf("Nonce of %s written to %s\n", address, file)
+}
+
+func (cli *CLI) signMuSig(sessionFile, address, nonces, file string) {
+	session := readMuSigSession(sessionFile)
+	w := cli.signerWallet(address)
+	pubKey := schnorr.PublicKey(&w.PrivateKey)
 
-func (cli *CLI) printUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("\tcreateblockchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
-	fmt.Println("\tls - print all the blocks of the blockchain")
-	fmt.Println("\treindexutxo - Rebuilds the UTXO set")
-	fmt.Println("\tcreatewallet - Generates a new key-pair and saves it into the wallet file")
-	fmt.Println("\twallets - Lists all addresses from the wallet file")
-	fmt.Println("\tbalance -address ADDRESS - Get balance of ADDRESS")
-	fmt.Println("\tsend -from FROM -to TO -amount AMOUNT - Send AMOUNT of coins from FROM address to TO")
-}
+	secNonceFile := cli.secNonceFile(session, pubKey)
+	secNonce, err := ioutil.ReadFile(secNonceFile)
+	if err != nil {
+		log.Panicf("ERROR: no nonce of %s for this session, run musignonce first", address)
+	}
 
-func (cli *CLI) validateArgs() {
-	if len(os.Args) < 2 {
-		cli.printUsage()
-		os.Exit(1)
+	partialSig, err := session.PartialSign(w.PrivateKey, secNonce, readMuSigFiles(session, nonces))
+	if err != nil {
+		log.Panic(err)
+	}
+	// signing twice with the same nonce would give the key away
+	if err := os.Remove(secNonceFile); err != nil {
+		log.Panic(err)
 	}
+	writeMuSigFile(file, pubKey, partialSig)
+	fmt.Printf("Partial signature of %s written to %s\n", address, file)
 }
 
-func (cli *CLI) createBlockchain(address string) {
-	if !ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+func (cli *CLI) finalizeMuSig(sessionFile, nonces, partialSigs string) {
+	session := readMuSigSession(sessionFile)
+
+	tx, err := session.Finalize(readMuSigFiles(session, nonces), readMuSigFiles(session, partialSigs))
+	if err != nil {
+		log.Panic(err)
 	}
 
-	// TODO: overwrite behavior or manually delete the database
-	bc := NewBlockchain(address)
-	defer bc.db.Close()
+	fmt.Println(chain.EncodeRawTransaction(tx))
+}
 
-	fmt.Println("initializing UTXO set")
-	UTXOSet := UTXOSet{bc}
-	fmt.Println("reindexing UTXO set")
-	UTXOSet.Reindex()
+func (cli *CLI) exportChain(file string) {
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	f, err := os.Create(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
+
+	err = chain.ExportChain(bc, f, func(height, bestHeight int) {
+		if height%100 == 0 || height == bestHeight {
+			fmt.Printf("\rexported block %d/%d", height, bestHeight)
+		}
+	})
+	fmt.Println()
+	if err != nil {
+		log.Panic(err)
+	}
 
 	fmt.Println("Done!")
 }
 
-func (cli *CLI) reindexUTXO() {
-	bc := NewBlockchain("")
-	UTXOSet := UTXOSet{bc}
-	UTXOSet.Reindex()
+func (cli *CLI) importChain(file string, prune int, params chain.Params) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
 
-	count := UTXOSet.CountTransactions()
-	fmt.Printf("Done! There are %d transactions in the UTXO set.\n", count)
+	info, err := f.Stat()
+	if err != nil {
+		log.Panic(err)
+	}
+	r := &countingReader{r: f}
+
+	bc := cli.openBlockchain(true)
+	defer bc.Close()
+	cli.setParams(bc, params)
+	cli.setPrune(bc, prune)
+
+	snapshot, err := bc.Snapshot()
+	if err != nil {
+		log.Panic(err)
+	}
+	imported, err := chain.ImportChain(bc, r, func(block *chain.Block) {
+		fmt.Printf("\rblock %d (%d%%)", block.Height, 100*r.n/info.Size())
+	})
+	fmt.Println()
+	if err != nil {
+		log.Panic(err)
+	}
+	if pending, _ := bc.Snapshot(); snapshot != nil && pending == nil {
+		fmt.Printf("Snapshot of height %d verified\n", snapshot.Height)
+	}
+
+	fmt.Printf("Done! Imported %d blocks, tip is now %x\n", imported, bc.Tip())
 }
 
-func (cli *CLI) getBalance(address string) {
-	if !ValidateAddress(address) {
-		log.Panic("ERROR: Address is not valid")
+func (cli *CLI) supply() {
+	var supply SupplyJSON
+
+	if rpc := cli.daemon(); rpc != nil {
+		if err := rpc.Call("getsupply", &supply); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := cli.openBlockchain(false)
+		defer bc.Close()
+
+		chainSupply, err := bc.Supply()
+		if err != nil {
+			log.Panic(err)
+		}
+		supply = NewSupplyJSON(chainSupply)
 	}
 
-	bc := NewBlockchain("")
-	UTXOSet := UTXOSet{bc}
-	defer bc.db.Close()
+	fmt.Printf("Height:       %d\n", supply.Height)
+	fmt.Printf("Issued:       %d\n", supply.Issued)
+	fmt.Printf("Unspent:      %d\n", supply.Unspent)
+	fmt.Printf("Subsidy:      %d, halving at height %d\n", supply.Subsidy, supply.NextHalving)
+	fmt.Printf("Max supply:   %d (%.2f%% issued)\n", supply.MaxSupply, 100*float64(supply.Issued)/float64(supply.MaxSupply))
+}
+
+func (cli *CLI) getDeploymentInfo() {
+	var info DeploymentInfoJSON
+
+	if rpc := cli.daemon(); rpc != nil {
+		if err := rpc.Call("getdeploymentinfo", &info); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := cli.openBlockchain(false)
+		defer bc.Close()
 
-	balance := 0
-	pubKeyHash := Base58Decode([]byte(address))
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTXOs := UTXOSet.FindUTXO(pubKeyHash)
+		height, err := bc.GetBestHeight()
+		if err != nil {
+			log.Panic(err)
+		}
+		infos, err := bc.Deployments()
+		if err != nil {
+			log.Panic(err)
+		}
+		info = NewDeploymentInfoJSON(bc.Tip(), height, infos, bc.Params().DeploymentWindow)
+	}
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	names := make([]string, 0, len(info.Deployments))
+	for name := range info.Deployments {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
-	fmt.Printf("Balance of '%s': %d\n", address, balance)
+	fmt.Printf("Deployments for block %d:\n", info.Height+1)
+	for _, name := range names {
+		deployment := info.Deployments[name]
+		fmt.Printf("%-12s bit %-2d %s since block %d\n", name, deployment.Bit, deployment.Status, deployment.Since)
+		if stats := deployment.Statistics; stats != nil {
+			fmt.Printf("%-12s %d of the %d blocks of the window so far signal, %d of %d needed", "", stats.Count, stats.Elapsed, stats.Threshold, stats.Period)
+			if !stats.Possible {
+				fmt.Print(", out of reach")
+			}
+			fmt.Println()
+		}
+	}
 }
 
-func (cli *CLI) createWallet() {
-	wallets, _ := NewWallets()
-	address := wallets.CreateWallet()
-	wallets.SaveToFile()
+// benchmark measures the hashrate of every proof of work hash function, and
+// deduces how long a block of bits takes to mine on average
+func (cli *CLI) benchmark(duration time.Duration, threads, bits int) {
+	fmt.Printf("Hashing for %s with each function, on %d threads\n", duration, threads)
 
-	fmt.Printf("Your new address: %s\n", address)
+	for _, name := range pow.HashFunctions() {
+		hash, err := pow.NewHashFunction(name)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		hashrate := pow.Benchmark(hash, duration, threads)
+		// 2^bits hashes on average
+		blockTime := math.Ldexp(1, bits) / hashrate
+		fmt.Printf("%-8s %12.0f H/s, %.3gs per block of %d bits\n", name, hashrate, blockTime, bits)
+	}
 }
 
-func (cli *CLI) listAddresses() {
-	wallets, err := NewWallets()
+func (cli *CLI) getTxOutSetInfo() {
+	var info TxOutSetInfoJSON
+
+	if rpc := cli.daemon(); rpc != nil {
+		if err := rpc.Call("gettxoutsetinfo", &info); err != nil {
+			log.Panic(err)
+		}
+	} else {
+		bc := cli.openBlockchain(false)
+		defer bc.Close()
+
+		setInfo, err := chain.UTXOSet{Blockchain: bc}.Info()
+		if err != nil {
+			log.Panic(err)
+		}
+		info = NewTxOutSetInfoJSON(setInfo)
+	}
+
+	fmt.Printf("Height:       %d\n", info.Height)
+	fmt.Printf("Best block:   %s\n", info.BestBlock)
+	fmt.Printf("Transactions: %d\n", info.Transactions)
+	fmt.Printf("Outputs:      %d\n", info.TxOuts)
+	fmt.Printf("Total amount: %d\n", info.TotalAmount)
+	fmt.Printf("Hash:         %s\n", info.HashSerialized)
+}
+
+func (cli *CLI) dumpTxOutSet(file string) {
+	if rpc := cli.daemon(); rpc != nil {
+		// the daemon may run from another directory
+		path, err := filepath.Abs(file)
+		if err != nil {
+			log.Panic(err)
+		}
+
+		var result DumpTxOutSetJSON
+		if err := rpc.Call("dumptxoutset", &result, path); err != nil {
+			log.Panic(err)
+		}
+		fmt.Printf("Wrote %d outputs at height %d to %s\n", result.CoinsWritten, result.BaseHeight, result.Path)
+		return
+	}
+
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	info, err := chain.DumpTxOutSetFile(bc, file)
 	if err != nil {
 		log.Panic(err)
 	}
-	addresses := wallets.GetAddresses()
 
-	for _, address := range addresses {
-		fmt.Println(address)
-	}
+	fmt.Printf("Wrote %d outputs at height %d to %s\n", info.TxOuts, info.Height, file)
 }
 
-func (cli *CLI) printChain() {
-	// TODO: handle better new vs loading blochains. API is bad and there's too
-	// much assumptions here
-	bc := NewBlockchain("")
-	defer bc.db.Close()
+func (cli *CLI) loadTxOutSet(file string, params chain.Params) {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer f.Close()
 
-	bci := bc.Iterator()
+	bc := cli.openBlockchain(true)
+	defer bc.Close()
+	cli.setParams(bc, params)
+
+	info, err := chain.LoadTxOutSet(bc, f)
+	if err != nil {
+		log.Panic(err)
+	}
 
-	for {
-		block := bci.Next()
-		pow := NewProofOfWork(block)
+	fmt.Printf("Loaded %d outputs, tip is now %x at height %d\n", info.TxOuts, info.BestBlock, info.Height)
+	fmt.Println("Import a bootstrap file with importchain to verify the history")
+}
 
-		fmt.Printf("\n============ Block %x ============\n", block.Hash)
-		fmt.Printf("Prev. block: %x\n", block.PrevBlockHash)
-		fmt.Printf("PoW: %s\n\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+func (cli *CLI) verifyChain(level, depth int) {
+	if rpc := cli.daemon(); rpc != nil {
+		var ok bool
+		if err := rpc.Call("verifychain", &ok, level, depth); err != nil {
+			log.Panic(err)
 		}
-		fmt.Printf("\n\n")
+		fmt.Println("No errors found")
+		return
+	}
 
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+
+	checked, err := bc.VerifyChain(level, depth)
+	if err != nil {
+		log.Panic(err)
 	}
+
+	fmt.Printf("No errors found in the last %d blocks at level %d\n", checked, level)
 }
 
-func (cli *CLI) send(from, to string, amount int) {
-	if !ValidateAddress(from) {
-		log.Panic("ERROR: Sender address is not valid")
+func (cli *CLI) serve(addr, user, password, explorerAddr string, prune int, poolAddr string, poolConfig pool.Config) {
+	if (user == "") != (password == "") {
+		log.Panic("ERROR: -rpcuser and -rpcpassword go together")
 	}
-	if !ValidateAddress(to) {
-		log.Panic("ERROR: Recipient address is not valid")
+	if poolAddr != "" && poolConfig.Address == "" {
+		log.Panic("ERROR: the pool needs an address, -pooladdress")
 	}
 
-	fmt.Println("initializing a new transaction")
-	bc := NewBlockchain("")
-	UTXOSet := UTXOSet{bc}
-	defer bc.db.Close()
+	bc := cli.openBlockchain(false)
+	defer bc.Close()
+	cli.setPrune(bc, prune)
 
-	fmt.Printf("creating the coinbase tx, reward to %s\n", from)
-	cbTx := NewCoinbaseTX(from, "")
-	fmt.Printf("creating the actual transaction of %d bitcoins\n", amount)
-	tx := NewUTXOTransaction(from, to, amount, &UTXOSet)
-	txs := []*Transaction{cbTx, tx}
+	// log the changes of the chain, whichever RPC call makes them
+	events := bc.Subscribe()
+	defer events.Unsubscribe()
+	go func() {
+		for event := range events.C {
+			switch event.Type {
+			case chain.TxAccepted:
+				log.Printf("%s: %x\n", event.Type, event.Tx.ID)
+			default:
+				log.Printf("%s: %x at height %d\n", event.Type, event.Block.Hash, event.Block.Height)
+			}
+		}
+	}()
+
+	if explorerAddr != "" {
+		go func() {
+			log.Printf("block explorer listening on http://%s\n", explorerAddr)
+			if err := http.ListenAndServe(explorerAddr, NewExplorer(bc)); err != nil {
+				log.Panic(err)
+			}
+		}()
+	}
+
+	server := NewRPCServer(bc, cli.dataDir, user, password)
 
-	fmt.Println("mining the new block")
-	newBlock := bc.AddBlock(txs)
-	fmt.Println("updating UTXO set")
-	UTXOSet.Update(newBlock)
+	if poolAddr != "" {
+		// the pool mines the transactions queued for external miners
+		poolConfig.Candidates = server.Pending
+		miningPool, err := pool.NewServer(bc, poolConfig)
+		if err != nil {
+			log.Panic(err)
+		}
+		defer miningPool.Close()
+		go func() {
+			if err := miningPool.ListenAndServe(poolAddr); err != nil {
+				log.Panic(err)
+			}
+		}()
+	}
 
-	fmt.Println("Success!")
+	if err := server.ListenAndServe(addr); err != nil {
+		log.Panic(err)
+	}
 }
 
 func (cli *CLI) Run() {
@@ -160,14 +1270,125 @@ func (cli *CLI) Run() {
 	walletsCmd := flag.NewFlagSet("wallets", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("balance", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
+	stakeCmd := flag.NewFlagSet("stake", flag.ExitOnError)
+	unstakeCmd := flag.NewFlagSet("unstake", flag.ExitOnError)
+	stakesCmd := flag.NewFlagSet("stakes", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	serveCmd := flag.NewFlagSet("serve", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	disconnectBlockCmd := flag.NewFlagSet("disconnectblock", flag.ExitOnError)
+	getTxOutSetInfoCmd := flag.NewFlagSet("gettxoutsetinfo", flag.ExitOnError)
+	supplyCmd := flag.NewFlagSet("supply", flag.ExitOnError)
+	benchmarkCmd := flag.NewFlagSet("benchmark", flag.ExitOnError)
+	getDeploymentInfoCmd := flag.NewFlagSet("getdeploymentinfo", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	dumpTxOutSetCmd := flag.NewFlagSet("dumptxoutset", flag.ExitOnError)
+	loadTxOutSetCmd := flag.NewFlagSet("loadtxoutset", flag.ExitOnError)
+	createRawTxCmd := flag.NewFlagSet("createrawtransaction", flag.ExitOnError)
+	signRawTxCmd := flag.NewFlagSet("signrawtransaction", flag.ExitOnError)
+	decodeRawTxCmd := flag.NewFlagSet("decoderawtransaction", flag.ExitOnError)
+	sendRawTxCmd := flag.NewFlagSet("sendrawtransaction", flag.ExitOnError)
+	createPSBTCmd := flag.NewFlagSet("createpsbt", flag.ExitOnError)
+	signPSBTCmd := flag.NewFlagSet("signpsbt", flag.ExitOnError)
+	combinePSBTCmd := flag.NewFlagSet("combinepsbt", flag.ExitOnError)
+	finalizePSBTCmd := flag.NewFlagSet("finalizepsbt", flag.ExitOnError)
+	musigAddressCmd := flag.NewFlagSet("musigaddress", flag.ExitOnError)
+	createMuSigCmd := flag.NewFlagSet("createmusig", flag.ExitOnError)
+	musigNonceCmd := flag.NewFlagSet("musignonce", flag.ExitOnError)
+	signMuSigCmd := flag.NewFlagSet("signmusig", flag.ExitOnError)
+	finalizeMuSigCmd := flag.NewFlagSet("finalizemusig", flag.ExitOnError)
+
+	for _, cmd := range []*flag.FlagSet{
+		createBlockchainCmd, printChainCmd, createWalletCmd, walletsCmd,
+		getBalanceCmd, sendCmd, stakeCmd, unstakeCmd, stakesCmd, reindexUTXOCmd, serveCmd, exportChainCmd,
+		importChainCmd, disconnectBlockCmd, getTxOutSetInfoCmd, supplyCmd, getDeploymentInfoCmd, verifyChainCmd,
+		dumpTxOutSetCmd, loadTxOutSetCmd, createRawTxCmd, signRawTxCmd,
+		decodeRawTxCmd, sendRawTxCmd, createPSBTCmd, signPSBTCmd,
+		combinePSBTCmd, finalizePSBTCmd, musigAddressCmd, createMuSigCmd,
+		musigNonceCmd, signMuSigCmd, finalizeMuSigCmd,
+	} {
+		cmd.StringVar(&cli.dataDir, "datadir", ".", "Directory of the database, wallet and cookie files")
+	}
+
+	// the consensus parameters, for the commands starting a chain
+	var params chain.Params
+	var signers string
+	var threshold int
+	for _, cmd := range []*flag.FlagSet{createBlockchainCmd, importChainCmd, loadTxOutSetCmd} {
+		cmd.IntVar(&params.InitialSubsidy, "subsidy", 0, fmt.Sprintf("Subsidy of the first blocks (default %d)", chain.INITIAL_SUBSIDY))
+		cmd.IntVar(&params.HalvingInterval, "halving", 0, fmt.Sprintf("Number of blocks between two halvings of the subsidy (default %d)", chain.HALVING_INTERVAL))
+		cmd.IntVar(&params.CoinbaseMaturity, "maturity", 0, fmt.Sprintf("Number of blocks before a coinbase can be spent (default %d)", chain.COINBASE_MATURITY))
+		cmd.StringVar(&params.Consensus, "consensus", "", fmt.Sprintf("Consensus engine, %s, %s or %s (default %s)", chain.CONSENSUS_POW, chain.CONSENSUS_POA, chain.CONSENSUS_POS, chain.CONSENSUS_POW))
+		cmd.StringVar(&signers, "signers", "", "Comma separated addresses of the signers of a proof of authority, in turn order")
+		cmd.IntVar(&params.StakeLock, "stakelock", -1, fmt.Sprintf("Number of blocks before a stake can be taken back (default %d)", chain.STAKE_LOCK))
+		cmd.StringVar(&params.PowHash, "powhash", "", fmt.Sprintf("Hash function of the proof of work, %s (default %s)", strings.Join(pow.HashFunctions(), ", "), pow.HASH_SHA256))
+		cmd.IntVar(&params.PowBits, "powbits", 0, fmt.Sprintf("Leading zero bits of the proof of work (default %d)", pow.TargetBits))
+		cmd.IntVar(&params.DeploymentWindow, "window", 0, fmt.Sprintf("Number of blocks between two changes of the state of the deployments (default %d)", chain.DEPLOYMENT_WINDOW))
+		cmd.IntVar(&threshold, "threshold", 0, fmt.Sprintf("Number of blocks of a window signalling a deployment to lock it in, the deployments of a new chain being active from the genesis block otherwise (default %d)", chain.DEPLOYMENT_THRESHOLD))
+	}
 
 	// CLI flags
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainPrune := createBlockchainCmd.Int("prune", 0, "Prune the blocks deeper than N below the tip")
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	stakeFrom := stakeCmd.String("from", "", "Address of the validator")
+	stakeAmount := stakeCmd.Int("amount", 0, "Amount to stake")
+	unstakeAddress := unstakeCmd.String("address", "", "Address of the validator")
+	serveAddr := serveCmd.String("rpcaddr", RPC_ADDR, "Address to listen on for JSON-RPC connections")
+	serveUser := serveCmd.String("rpcuser", "", "Username for JSON-RPC connections, a cookie file is used if empty")
+	servePassword := serveCmd.String("rpcpassword", "", "Password for JSON-RPC connections")
+	serveExplorerAddr := serveCmd.String("exploreraddr", EXPLORER_ADDR, "Address to serve the block explorer on, disabled if empty")
+	servePrune := serveCmd.Int("prune", 0, "Prune the blocks deeper than N below the tip")
+	servePoolAddr := serveCmd.String("pooladdr", "", fmt.Sprintf("Address to run a mining pool on, like %s, disabled if empty", pool.POOL_ADDR))
+	var servePoolConfig pool.Config
+	serveCmd.StringVar(&servePoolConfig.Address, "pooladdress", "", "The address of the pool, paid the reward of the blocks found before any share")
+	serveCmd.IntVar(&servePoolConfig.ShareBits, "sharebits", pool.SHARE_BITS, "Leading zero bits of the pool shares")
+
+	createRawTxInputs := createRawTxCmd.String("inputs", "", "Comma separated outputs to spend, as TXID:VOUT")
+	createRawTxOutputs := createRawTxCmd.String("outputs", "", "Comma separated outputs to create, as ADDRESS:AMOUNT")
+	signRawTxHex := signRawTxCmd.String("hex", "", "Raw transaction to sign")
+	signRawTxPrevouts := signRawTxCmd.String("prevouts", "", "Comma separated outputs spent by the transaction, as TXID:VOUT:ADDRESS:AMOUNT")
+	signRawTxSigHash := signRawTxCmd.String("sighash", "ALL", "Parts of the transaction the signatures cover: ALL, NONE or SINGLE, optionally followed by |ANYONECANPAY")
+	decodeRawTxHex := decodeRawTxCmd.String("hex", "", "Raw transaction to decode")
+	sendRawTxHex := sendRawTxCmd.String("hex", "", "Signed raw transaction to send")
+	sendRawTxRewardAddress := sendRawTxCmd.String("rewardaddress", "", "The address to send the reward of the mined block to")
+	createPSBTInputs := createPSBTCmd.String("inputs", "", "Comma separated outputs to spend, as TXID:VOUT")
+	createPSBTOutputs := createPSBTCmd.String("outputs", "", "Comma separated outputs to create, as ADDRESS:AMOUNT")
+	createPSBTPrevouts := createPSBTCmd.String("prevouts", "", "Comma separated outputs spent by the transaction, as TXID:VOUT:ADDRESS:AMOUNT")
+	signPSBTData := signPSBTCmd.String("psbt", "", "PSBT to sign")
+	combinePSBTData := combinePSBTCmd.String("psbts", "", "Comma separated PSBTs to combine")
+	finalizePSBTData := finalizePSBTCmd.String("psbt", "", "PSBT to finalize")
+	musigAddressKeys := musigAddressCmd.String("keys", "", "Comma separated addresses of the wallet file or x-only public keys, in hex")
+	createMuSigHex := createMuSigCmd.String("hex", "", "Raw transaction to sign")
+	createMuSigKeys := createMuSigCmd.String("keys", "", "Comma separated addresses of the wallet file or x-only public keys of the signers, in the order of musigaddress")
+	createMuSigInput := createMuSigCmd.Int("input", 0, "Index of the input to sign")
+	createMuSigPrevouts := createMuSigCmd.String("prevouts", "", "Comma separated outputs spent by the transaction, as TXID:VOUT:ADDRESS:AMOUNT")
+	createMuSigSigHash := createMuSigCmd.String("sighash", "ALL", "Parts of the transaction the signature covers: ALL, NONE or SINGLE, optionally followed by |ANYONECANPAY")
+	createMuSigFile := createMuSigCmd.String("file", "", "Session file to write")
+	musigNonceSession := musigNonceCmd.String("session", "", "Session file")
+	musigNonceAddress := musigNonceCmd.String("address", "", "Address of the signer, in the wallet file")
+	musigNonceFile := musigNonceCmd.String("file", "", "Nonce file to write")
+	signMuSigSession := signMuSigCmd.String("session", "", "Session file")
+	signMuSigAddress := signMuSigCmd.String("address", "", "Address of the signer, in the wallet file")
+	signMuSigNonces := signMuSigCmd.String("nonces", "", "Comma separated nonce files of all the signers")
+	signMuSigFile := signMuSigCmd.String("file", "", "Partial signature file to write")
+	finalizeMuSigSession := finalizeMuSigCmd.String("session", "", "Session file")
+	finalizeMuSigNonces := finalizeMuSigCmd.String("nonces", "", "Comma separated nonce files of all the signers")
+	finalizeMuSigPartialSigs := finalizeMuSigCmd.String("partialsigs", "", "Comma separated partial signature files of all the signers")
+	exportChainFile := exportChainCmd.String("file", "", "Bootstrap file to write")
+	importChainFile := importChainCmd.String("file", "", "Bootstrap file to read")
+	importChainPrune := importChainCmd.Int("prune", 0, "Prune the blocks deeper than N below the tip")
+	dumpTxOutSetFile := dumpTxOutSetCmd.String("file", "", "Snapshot file to write")
+	loadTxOutSetFile := loadTxOutSetCmd.String("file", "", "Snapshot file to read")
+	verifyChainLevel := verifyChainCmd.Int("level", chain.DEFAULT_CHECK_LEVEL, "How thorough the checks are, from 0 to 4")
+	benchmarkDuration := benchmarkCmd.Duration("duration", 5*time.Second, "How long to hash with each function")
+	benchmarkThreads := benchmarkCmd.Int("threads", runtime.NumCPU(), "Number of threads hashing")
+	benchmarkBits := benchmarkCmd.Int("bits", pow.TargetBits, "Leading zero bits of the blocks to time")
+	verifyChainDepth := verifyChainCmd.Int("depth", chain.DEFAULT_CHECK_BLOCKS, "Number of blocks to check below the tip, 0 for all")
 
 	// parse the right flags depending on the command
 	switch os.Args[1] {
@@ -189,21 +1410,83 @@ func (cli *CLI) Run() {
 		_ = getBalanceCmd.Parse(os.Args[2:])
 	case "send":
 		_ = sendCmd.Parse(os.Args[2:])
+	case "stake":
+		_ = stakeCmd.Parse(os.Args[2:])
+	case "unstake":
+		_ = unstakeCmd.Parse(os.Args[2:])
+	case "stakes":
+		_ = stakesCmd.Parse(os.Args[2:])
 	case "reindexutxo":
 		_ = reindexUTXOCmd.Parse(os.Args[2:])
+	case "serve":
+		_ = serveCmd.Parse(os.Args[2:])
+	case "exportchain":
+		_ = exportChainCmd.Parse(os.Args[2:])
+	case "importchain":
+		_ = importChainCmd.Parse(os.Args[2:])
+	case "disconnectblock":
+		_ = disconnectBlockCmd.Parse(os.Args[2:])
+	case "gettxoutsetinfo":
+		_ = getTxOutSetInfoCmd.Parse(os.Args[2:])
+	case "benchmark":
+		_ = benchmarkCmd.Parse(os.Args[2:])
+	case "getdeploymentinfo":
+		_ = getDeploymentInfoCmd.Parse(os.Args[2:])
+	case "supply":
+		_ = supplyCmd.Parse(os.Args[2:])
+	case "verifychain":
+		_ = verifyChainCmd.Parse(os.Args[2:])
+	case "dumptxoutset":
+		_ = dumpTxOutSetCmd.Parse(os.Args[2:])
+	case "loadtxoutset":
+		_ = loadTxOutSetCmd.Parse(os.Args[2:])
+	case "createrawtransaction":
+		_ = createRawTxCmd.Parse(os.Args[2:])
+	case "signrawtransaction":
+		_ = signRawTxCmd.Parse(os.Args[2:])
+	case "decoderawtransaction":
+		_ = decodeRawTxCmd.Parse(os.Args[2:])
+	case "sendrawtransaction":
+		_ = sendRawTxCmd.Parse(os.Args[2:])
+	case "createpsbt":
+		_ = createPSBTCmd.Parse(os.Args[2:])
+	case "signpsbt":
+		_ = signPSBTCmd.Parse(os.Args[2:])
+	case "combinepsbt":
+		_ = combinePSBTCmd.Parse(os.Args[2:])
+	case "finalizepsbt":
+		_ = finalizePSBTCmd.Parse(os.Args[2:])
+	case "musigaddress":
+		_ = musigAddressCmd.Parse(os.Args[2:])
+	case "createmusig":
+		_ = createMuSigCmd.Parse(os.Args[2:])
+	case "musignonce":
+		_ = musigNonceCmd.Parse(os.Args[2:])
+	case "signmusig":
+		_ = signMuSigCmd.Parse(os.Args[2:])
+	case "finalizemusig":
+		_ = finalizeMuSigCmd.Parse(os.Args[2:])
 	default:
 		cli.printUsage()
 		os.Exit(1)
 	}
 
 	// run the right command
+	params.Signers = splitList(signers)
+	if threshold != 0 {
+		// rolled out by signalling rather than active from the genesis block
+		params.Deployments = append([]chain.Deployment{}, chain.UpgradeDeployments...)
+		for i := range params.Deployments {
+			params.Deployments[i].Threshold = threshold
+		}
+	}
 
 	if createBlockchainCmd.Parsed() {
 		if *createBlockchainAddress == "" {
 			createBlockchainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.createBlockchain(*createBlockchainAddress)
+		cli.createBlockchain(*createBlockchainAddress, *createBlockchainPrune, withParams(chain.DefaultParams, params))
 	}
 
 	if printChainCmd.Parsed() {
@@ -238,4 +1521,246 @@ func (cli *CLI) Run() {
 
 		cli.send(*sendFrom, *sendTo, *sendAmount)
 	}
+
+	if stakeCmd.Parsed() {
+		if *stakeFrom == "" || *stakeAmount <= 0 {
+			stakeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.stake(*stakeFrom, *stakeAmount)
+	}
+
+	if unstakeCmd.Parsed() {
+		if *unstakeAddress == "" {
+			unstakeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.unstake(*unstakeAddress)
+	}
+
+	if stakesCmd.Parsed() {
+		cli.listStakes()
+	}
+
+	if createRawTxCmd.Parsed() {
+		if *createRawTxInputs == "" || *createRawTxOutputs == "" {
+			createRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createRawTransaction(*createRawTxInputs, *createRawTxOutputs)
+	}
+
+	if signRawTxCmd.Parsed() {
+		if *signRawTxHex == "" {
+			signRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signRawTransaction(*signRawTxHex, *signRawTxPrevouts, *signRawTxSigHash)
+	}
+
+	if decodeRawTxCmd.Parsed() {
+		if *decodeRawTxHex == "" {
+			decodeRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.decodeRawTransaction(*decodeRawTxHex)
+	}
+
+	if sendRawTxCmd.Parsed() {
+		if *sendRawTxHex == "" || *sendRawTxRewardAddress == "" {
+			sendRawTxCmd.Usage()
+			os.Exit(1)
+		}
+		cli.sendRawTransaction(*sendRawTxHex, *sendRawTxRewardAddress)
+	}
+
+	if createPSBTCmd.Parsed() {
+		if *createPSBTInputs == "" || *createPSBTOutputs == "" {
+			createPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createPSBT(*createPSBTInputs, *createPSBTOutputs, *createPSBTPrevouts)
+	}
+
+	if signPSBTCmd.Parsed() {
+		if *signPSBTData == "" {
+			signPSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signPSBT(*signPSBTData)
+	}
+
+	if combinePSBTCmd.Parsed() {
+		if *combinePSBTData == "" {
+			combinePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.combinePSBT(*combinePSBTData)
+	}
+
+	if finalizePSBTCmd.Parsed() {
+		if *finalizePSBTData == "" {
+			finalizePSBTCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizePSBT(*finalizePSBTData)
+	}
+
+	if musigAddressCmd.Parsed() {
+		if *musigAddressKeys == "" {
+			musigAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.musigAddress(*musigAddressKeys)
+	}
+
+	if createMuSigCmd.Parsed() {
+		if *createMuSigHex == "" || *createMuSigKeys == "" || *createMuSigFile == "" {
+			createMuSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.createMuSig(*createMuSigHex, *createMuSigKeys, *createMuSigPrevouts, *createMuSigSigHash, *createMuSigFile, *createMuSigInput)
+	}
+
+	if musigNonceCmd.Parsed() {
+		if *musigNonceSession == "" || *musigNonceAddress == "" || *musigNonceFile == "" {
+			musigNonceCmd.Usage()
+			os.Exit(1)
+		}
+		cli.musigNonce(*musigNonceSession, *musigNonceAddress, *musigNonceFile)
+	}
+
+	if signMuSigCmd.Parsed() {
+		if *signMuSigSession == "" || *signMuSigAddress == "" || *signMuSigNonces == "" || *signMuSigFile == "" {
+			signMuSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.signMuSig(*signMuSigSession, *signMuSigAddress, *signMuSigNonces, *signMuSigFile)
+	}
+
+	if finalizeMuSigCmd.Parsed() {
+		if *finalizeMuSigSession == "" || *finalizeMuSigNonces == "" || *finalizeMuSigPartialSigs == "" {
+			finalizeMuSigCmd.Usage()
+			os.Exit(1)
+		}
+		cli.finalizeMuSig(*finalizeMuSigSession, *finalizeMuSigNonces, *finalizeMuSigPartialSigs)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainFile == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(*exportChainFile)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainFile == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(*importChainFile, *importChainPrune, params)
+	}
+
+	if disconnectBlockCmd.Parsed() {
+		cli.disconnectBlock()
+	}
+
+	if getTxOutSetInfoCmd.Parsed() {
+		cli.getTxOutSetInfo()
+	}
+
+	if supplyCmd.Parsed() {
+		cli.supply()
+	}
+
+	if getDeploymentInfoCmd.Parsed() {
+		cli.getDeploymentInfo()
+	}
+
+	if benchmarkCmd.Parsed() {
+		if *benchmarkDuration <= 0 || *benchmarkThreads <= 0 || *benchmarkBits < 1 {
+			benchmarkCmd.Usage()
+			os.Exit(1)
+		}
+		cli.benchmark(*benchmarkDuration, *benchmarkThreads, *benchmarkBits)
+	}
+
+	if verifyChainCmd.Parsed() {
+		cli.verifyChain(*verifyChainLevel, *verifyChainDepth)
+	}
+
+	if dumpTxOutSetCmd.Parsed() {
+		if *dumpTxOutSetFile == "" {
+			dumpTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpTxOutSet(*dumpTxOutSetFile)
+	}
+
+	if loadTxOutSetCmd.Parsed() {
+		if *loadTxOutSetFile == "" {
+			loadTxOutSetCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadTxOutSet(*loadTxOutSetFile, params)
+	}
+
+	if serveCmd.Parsed() {
+		cli.serve(*serveAddr, *serveUser, *servePassword, *serveExplorerAddr, *servePrune, *servePoolAddr, servePoolConfig)
+	}
+}
+
+// splitList splits a comma separated flag value, ignoring empty items
+func splitList(list string) []string {
+	var items []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func decodeHex(s string) []byte {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		log.Panicf("ERROR: %s is not valid hex: %s", s, err)
+	}
+
+	return data
+}
+
+// newOutput locks an output to an address already validated
+func (cli *CLI) newOutput(value int, address string) *chain.TXOutput {
+	output, err := chain.NewTXOutput(value, address)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	return output
+}
+
+func parseInt(s string) int {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		log.Panicf("ERROR: %s is not a number", s)
+	}
+
+	return n
+}
+
+// countingReader counts the bytes read, to report progress
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
 }
@@ -0,0 +1,214 @@
+// Command miner is a reference external miner. It asks a running `bc serve`
+// daemon for block templates, mines them on its own and submits the solved
+// blocks, which the node checks like the ones it mines itself:
+//
+//	$ bc serve &
+//	$ miner -address ADDRESS -blocks 10
+//
+// With -stratum, it is rather a worker of the mining pool run by the daemon,
+// submitting shares until interrupted:
+//
+//	$ bc serve -pooladdr 127.0.0.1:3333 -pooladdress POOL_ADDRESS &
+//	$ miner -address ADDRESS -stratum 127.0.0.1:3333
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/pool"
+	"github.com/xav-b/blockchain/pow"
+)
+
+// blockTemplate holds the fields of `getblocktemplate` we need
+type blockTemplate struct {
+	Version           int    `json:"version"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	Height            int    `json:"height"`
+	CurTime           int64  `json:"curtime"`
+	MinTime           int64  `json:"mintime"`
+	Bits              int    `json:"bits"`
+	PowHash           string `json:"powhash"`
+	CoinbaseTxn       struct {
+		Data string `json:"data"`
+	} `json:"coinbasetxn"`
+	Transactions []struct {
+		Data string `json:"data"`
+	} `json:"transactions"`
+}
+
+// client calls the JSON-RPC methods of the daemon
+type client struct {
+	url      string
+	user     string
+	password string
+}
+
+func (c *client) call(method string, result interface{}, params ...interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "1.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("RPC authentication failed, check the credentials")
+	}
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return err
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("%s (code %d)", rpcResp.Error.Message, rpcResp.Error.Code)
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+// mine builds the block of the template and computes its proof of work,
+// rolling the extra nonce of the coinbase as needed
+func mine(template blockTemplate) (*chain.Block, error) {
+	function, err := pow.NewFunction(template.PowHash, template.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	prevBlockHash, err := hex.DecodeString(template.PreviousBlockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	coinbase, err := chain.DecodeRawTransaction(template.CoinbaseTxn.Data)
+	if err != nil {
+		return nil, err
+	}
+	transactions := []*chain.Transaction{coinbase}
+	for _, txJSON := range template.Transactions {
+		tx, err := chain.DecodeRawTransaction(txJSON.Data)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, tx)
+	}
+
+	timestamp := template.CurTime
+	if timestamp < template.MinTime {
+		timestamp = template.MinTime
+	}
+
+	return chain.MineBlock(function, template.Version, transactions, prevBlockHash, template.Height, timestamp), nil
+}
+
+func main() {
+	dataDir := flag.String("datadir", ".", "Directory of the cookie file of the daemon")
+	rpcAddr := flag.String("rpcaddr", "127.0.0.1:8332", "Address of the daemon")
+	rpcUser := flag.String("rpcuser", "", "RPC user, the cookie file is used when empty")
+	rpcPassword := flag.String("rpcpassword", "", "RPC password")
+	address := flag.String("address", "", "The address to send the block rewards to")
+	blocks := flag.Int("blocks", 0, "Stop after mining this many blocks, 0 never stops")
+	stratum := flag.String("stratum", "", "Address of a mining pool to work for, rather than mining alone")
+	flag.Parse()
+
+	if *address == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if *stratum != "" {
+		work(*stratum, *address)
+		return
+	}
+
+	user, password := *rpcUser, *rpcPassword
+	if user == "" {
+		cookie, err := ioutil.ReadFile(filepath.Join(*dataDir, ".cookie"))
+		if err != nil {
+			log.Fatalf("no credentials given and no cookie file: %s", err)
+		}
+		credentials := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+		if len(credentials) != 2 {
+			log.Fatal("malformed cookie file")
+		}
+		user, password = credentials[0], credentials[1]
+	}
+	rpc := &client{fmt.Sprintf("http://%s/", *rpcAddr), user, password}
+
+	for mined := 0; *blocks == 0 || mined < *blocks; {
+		var template blockTemplate
+		if err := rpc.call("getblocktemplate", &template, *address); err != nil {
+			log.Fatal(err)
+		}
+
+		block, err := mine(template)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// the template goes stale when another block extends the tip first
+		if err := rpc.call("submitblock", nil, hex.EncodeToString(block.Serialize())); err != nil {
+			log.Printf("block %d rejected: %s", block.Height, err)
+			continue
+		}
+		log.Printf("block %d accepted: %x, %d transactions", block.Height, block.Hash, len(block.Transactions))
+		mined++
+	}
+}
+
+// work submits shares to the pool until interrupted
+func work(poolAddr, address string) {
+	worker, err := pool.Dial(poolAddr, address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer worker.Close()
+
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		close(stop)
+	}()
+
+	log.Printf("working for the pool at %s\n", poolAddr)
+	err = worker.Mine(stop)
+	accepted, rejected := worker.Stats()
+	log.Printf("%d shares accepted, %d rejected\n", accepted, rejected)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,4 +1,6 @@
-package main
+// Package encoding holds the byte level helpers shared by the chain and the
+// wallet: Base58 for addresses, checksums and fixed size integers.
+package encoding
 
 import (
 	"bytes"
@@ -23,12 +25,12 @@ func Base58Encode(input []byte) []byte {
 	}
 
 	ReverseBytes(result)
-	for b := range input {
-		if b == 0x00 {
-			result = append([]byte{b58Alphabet[0]}, result...)
-		} else {
+	// leading zero bytes are lost in the number, each is written as a 1
+	for _, b := range input {
+		if b != 0x00 {
 			break
 		}
+		result = append([]byte{b58Alphabet[0]}, result...)
 	}
 
 	return result
@@ -39,10 +41,11 @@ func Base58Decode(input []byte) []byte {
 	result := big.NewInt(0)
 	zeroBytes := 0
 
-	for b := range input {
-		if b == 0x00 {
-			zeroBytes++
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
 		}
+		zeroBytes++
 	}
 
 	payload := input[zeroBytes:]
@@ -0,0 +1,28 @@
+package encoding
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBase58(t *testing.T) {
+	// from Bitcoin Core's base58_encode_decode.json
+	vectors := []struct{ hex, base58 string }{
+		{"", ""},
+		{"61", "2g"},
+		{"00", "1"},
+		{"00000000000000000000", "1111111111"},
+		{"00000000000000000000000000000000000000000000", "1111111111111111111111"},
+		{"000000287fb4cd", "111233QC4"},
+		{"00eb15231dfceb60925886b67d065299925915aeb172c06647", "1NS17iag9jJgTHD1VXjvLCEnZuQ3rJDE9L"},
+	}
+
+	for _, v := range vectors {
+		data, err := hex.DecodeString(v.hex)
+		assert.Nil(t, err)
+		assert.Equal(t, v.base58, string(Base58Encode(data)), v.hex)
+		assert.Equal(t, v.hex, hex.EncodeToString(Base58Decode([]byte(v.base58))), v.base58)
+	}
+}
@@ -0,0 +1,33 @@
+package encoding
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// CHECKSUM_LEN is the length of the checksums, as appended to addresses
+const CHECKSUM_LEN = 4
+
+// IntToHex converts an int64 to a byte array
+func IntToHex(num int64) []byte {
+	buff := make([]byte, 8)
+	binary.BigEndian.PutUint64(buff, uint64(num))
+
+	return buff
+}
+
+// ReverseBytes reverses a byte array
+func ReverseBytes(data []byte) {
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+}
+
+// Checksum generates a checksum for a payload
+// using SHA256 twice (for added security)
+func Checksum(payload []byte) []byte {
+	firstSHA := sha256.Sum256(payload)
+	secondSHA := sha256.Sum256(firstSHA[:])
+
+	return secondSHA[:CHECKSUM_LEN]
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// The explorer is a small read-only website browsing the chain of the node. It
+// doesn't keep any index of its own: everything is read through the
+// BlockchainIterator, FindTransaction and the UTXO set.
+
+const (
+	EXPLORER_ADDR = "127.0.0.1:8080"
+	// number of blocks listed on the home page
+	EXPLORER_PAGE_SIZE = 20
+)
+
+// Explorer serves the block explorer pages
+type Explorer struct {
+	Blockchain *chain.Blockchain
+	templates  *template.Template
+	mux        *http.ServeMux
+}
+
+// NewExplorer creates an explorer for the given chain
+func NewExplorer(bc *chain.Blockchain) *Explorer {
+	e := &Explorer{Blockchain: bc, mux: http.NewServeMux()}
+
+	e.templates = template.Must(template.New("explorer").Funcs(template.FuncMap{
+		"hex":  hex.EncodeToString,
+		"time": func(ts int64) string { return time.Unix(ts, 0).UTC().Format(time.RFC3339) },
+	}).Parse(explorerTemplates))
+
+	e.mux.HandleFunc("/", e.latestBlocks)
+	e.mux.HandleFunc("/block/", e.block)
+	e.mux.HandleFunc("/tx/", e.transaction)
+	e.mux.HandleFunc("/address/", e.address)
+	e.mux.HandleFunc("/search", e.search)
+
+	return e
+}
+
+// ServeHTTP implements http.Handler
+func (e *Explorer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// a corrupted block or an unknown address should only break its own page
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("explorer: %s: %v\n", r.URL.Path, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+		}
+	}()
+
+	e.mux.ServeHTTP(w, r)
+}
+
+func (e *Explorer) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := e.templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("explorer: rendering %s: %s\n", name, err)
+	}
+}
+
+func (e *Explorer) notFound(w http.ResponseWriter, what string) {
+	w.WriteHeader(http.StatusNotFound)
+	e.render(w, "notfound", what)
+}
+
+func (e *Explorer) internalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("explorer: %s: %s\n", r.URL.Path, err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+// latestBlocks lists the blocks from the tip, or from below `?before=HEIGHT`
+func (e *Explorer) latestBlocks(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		e.notFound(w, r.URL.Path)
+		return
+	}
+
+	bestHeight, err := e.Blockchain.GetBestHeight()
+	if err != nil {
+		e.internalError(w, r, err)
+		return
+	}
+	before := bestHeight + 1
+	if b, err := strconv.Atoi(r.URL.Query().Get("before")); err == nil && b < before {
+		before = b
+	}
+
+	var blocks []*chain.Block
+	bci := e.Blockchain.Iterator()
+	for before > 0 && len(blocks) < EXPLORER_PAGE_SIZE {
+		block, err := bci.Next()
+		if err != nil {
+			e.internalError(w, r, err)
+			return
+		}
+		if block.Height < before {
+			blocks = append(blocks, block)
+		}
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	older := -1
+	if len(blocks) > 0 && blocks[len(blocks)-1].Height > 0 {
+		older = blocks[len(blocks)-1].Height
+	}
+
+	e.render(w, "blocks", map[string]interface{}{"Blocks": blocks, "Older": older})
+}
+
+// block shows a block by hash or height: /block/{hash|height}
+func (e *Explorer) block(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/block/")
+
+	block, ok := e.findBlock(id)
+	if !ok {
+		e.notFound(w, "block "+id)
+		return
+	}
+
+	e.render(w, "block", block)
+}
+
+func (e *Explorer) findBlock(id string) (*chain.Block, bool) {
+	var hash []byte
+	var err error
+
+	if height, convErr := strconv.Atoi(id); convErr == nil {
+		hash, err = e.Blockchain.GetBlockHash(height)
+	} else {
+		hash, err = hex.DecodeString(id)
+	}
+	if err != nil {
+		return nil, false
+	}
+
+	// pruned blocks still have their header to show
+	block, err := e.Blockchain.GetBlock(hash)
+	if err != nil && err != chain.ErrBlockPruned {
+		return nil, false
+	}
+
+	return &block, true
+}
+
+// explorerInput is a transaction input resolved to the output it spends
+type explorerInput struct {
+	chain.TXInput
+	Address string
+	Value   int
+}
+
+// transaction shows a transaction with its inputs resolved: /tx/{txid}
+func (e *Explorer) transaction(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/tx/")
+
+	tx, ok := e.findTransaction(id)
+	if !ok {
+		e.notFound(w, "transaction "+id)
+		return
+	}
+
+	var inputs []explorerInput
+	if !tx.IsCoinbase() {
+		for _, vin := range tx.Vin {
+			prevTx, err := e.Blockchain.FindTransaction(vin.Txid)
+			if errors.Is(err, chain.ErrBlockPruned) {
+				inputs = append(inputs, explorerInput{vin, "(pruned)", 0})
+				continue
+			} else if err != nil {
+				e.internalError(w, r, err)
+				return
+			}
+			out := prevTx.Vout[vin.Vout]
+
+			inputs = append(inputs, explorerInput{vin, wallet.PubKeyHashToAddress(out.PubKeyHash), out.Value})
+		}
+	}
+
+	e.render(w, "tx", map[string]interface{}{
+		"Tx":       NewTransactionJSON(&tx),
+		"Inputs":   inputs,
+		"Coinbase": tx.IsCoinbase(),
+	})
+}
+
+func (e *Explorer) findTransaction(id string) (chain.Transaction, bool) {
+	txid, err := hex.DecodeString(id)
+	if err != nil {
+		return chain.Transaction{}, false
+	}
+
+	tx, err := e.Blockchain.FindTransaction(txid)
+
+	return tx, err == nil
+}
+
+// addressTx is a line of the history of an address
+type addressTx struct {
+	Txid     []byte
+	Height   int
+	Received int
+	Sent     int
+}
+
+// address shows the balance and history of an address: /address/{address}
+func (e *Explorer) address(w http.ResponseWriter, r *http.Request) {
+	address := strings.TrimPrefix(r.URL.Path, "/address/")
+	if !wallet.ValidateAddress(address) {
+		e.notFound(w, "address "+address)
+		return
+	}
+	pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+	if err != nil {
+		e.notFound(w, "address "+address)
+		return
+	}
+
+	UTXOs, err := chain.UTXOSet{Blockchain: e.Blockchain}.FindUTXO(pubKeyHash)
+	if err != nil {
+		e.internalError(w, r, err)
+		return
+	}
+	balance := 0
+	for _, out := range UTXOs {
+		balance += out.Value
+	}
+
+	history, err := e.addressHistory(pubKeyHash)
+	if err != nil {
+		e.internalError(w, r, err)
+		return
+	}
+
+	e.render(w, "address", map[string]interface{}{
+		"Address": address,
+		"Balance": balance,
+		"History": history,
+		"Pruned":  e.Blockchain.PruneDepth() > 0,
+	})
+}
+
+// addressHistory lists the transactions paying to or spending from the given
+// public key hash, newest first. Pruned blocks are left out.
+func (e *Explorer) addressHistory(pubKeyHash []byte) ([]addressTx, error) {
+	// the iterator walks from the tip but we need to have seen the outputs
+	// before the inputs spending them, so collect the blocks first
+	var blocks []*chain.Block
+	bci := e.Blockchain.Iterator()
+	for {
+		block, err := bci.Next()
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+	}
+
+	// value of the outputs received so far, by "txid:vout"
+	received := make(map[string]int)
+	var history []addressTx
+
+	for i := len(blocks) - 1; i >= 0; i-- {
+		for _, tx := range blocks[i].Transactions {
+			line := addressTx{Txid: tx.ID, Height: blocks[i].Height}
+
+			if !tx.IsCoinbase() {
+				for _, vin := range tx.Vin {
+					if value, ok := received[chain.Outpoint(vin.Txid, vin.Vout)]; ok {
+						line.Sent += value
+					}
+				}
+			}
+
+			for outIdx, out := range tx.Vout {
+				if out.IsLockedWithKey(pubKeyHash) {
+					received[chain.Outpoint(tx.ID, outIdx)] = out.Value
+					line.Received += out.Value
+				}
+			}
+
+			if line.Received > 0 || line.Sent > 0 {
+				history = append([]addressTx{line}, history...)
+			}
+		}
+	}
+
+	return history, nil
+}
+
+// search redirects to the block, transaction or address matching `?q=`
+func (e *Explorer) search(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	if _, ok := e.findBlock(q); ok {
+		http.Redirect(w, r, "/block/"+q, http.StatusFound)
+	} else if _, ok := e.findTransaction(q); ok {
+		http.Redirect(w, r, "/tx/"+q, http.StatusFound)
+	} else if wallet.ValidateAddress(q) {
+		http.Redirect(w, r, "/address/"+q, http.StatusFound)
+	} else {
+		e.notFound(w, q)
+	}
+}
+
+const explorerTemplates = `
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>bc explorer</title>
+  <style>
+    body { font-family: monospace; margin: 2em; }
+    table { border-collapse: collapse; }
+    td, th { padding: 0.2em 1em; text-align: left; border-bottom: 1px solid #ddd; }
+  </style>
+</head>
+<body>
+  <a href="/">latest blocks</a>
+  <form action="/search" style="display: inline">
+    <input name="q" size="70" placeholder="block hash or height, txid, address">
+    <button>search</button>
+  </form>
+  <hr>
+{{end}}
+
+{{define "footer"}}
+</body>
+</html>
+{{end}}
+
+{{define "notfound"}}{{template "header"}}
+  <p>Nothing found for {{.}}</p>
+{{template "footer"}}{{end}}
+
+{{define "blocks"}}{{template "header"}}
+  <h2>Latest blocks</h2>
+  <table>
+    <tr><th>height</th><th>hash</th><th>time</th><th>transactions</th></tr>
+    {{range .Blocks}}
+    <tr>
+      <td><a href="/block/{{.Height}}">{{.Height}}</a></td>
+      <td><a href="/block/{{hex .Hash}}">{{hex .Hash}}</a></td>
+      <td>{{time .Timestamp}}</td>
+      <td>{{if .IsPruned}}pruned{{else}}{{len .Transactions}}{{end}}</td>
+    </tr>
+    {{end}}
+  </table>
+  {{if ge .Older 0}}<p><a href="/?before={{.Older}}">older blocks</a></p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "block"}}{{template "header"}}
+  <h2>Block {{.Height}}</h2>
+  <table>
+    <tr><td>hash</td><td>{{hex .Hash}}</td></tr>
+    <tr><td>previous</td><td>{{if .PrevBlockHash}}<a href="/block/{{hex .PrevBlockHash}}">{{hex .PrevBlockHash}}</a>{{end}}</td></tr>
+    <tr><td>merkle root</td><td>{{hex .HashTransactions}}</td></tr>
+    <tr><td>time</td><td>{{time .Timestamp}}</td></tr>
+    <tr><td>nonce</td><td>{{.Nonce}}</td></tr>
+  </table>
+  <h3>Transactions</h3>
+  {{if .IsPruned}}<p>pruned</p>{{end}}
+  <ul>
+    {{range .Transactions}}<li><a href="/tx/{{hex .ID}}">{{hex .ID}}</a></li>{{end}}
+  </ul>
+{{template "footer"}}{{end}}
+
+{{define "tx"}}{{template "header"}}
+  <h2>Transaction {{.Tx.Txid}}</h2>
+  <h3>Inputs</h3>
+  {{if .Coinbase}}<p>coinbase: newly generated coins</p>{{end}}
+  <table>
+    {{range .Inputs}}
+    <tr>
+      <td><a href="/tx/{{hex .Txid}}">{{hex .Txid}}:{{.Vout}}</a></td>
+      <td><a href="/address/{{.Address}}">{{.Address}}</a></td>
+      <td>{{.Value}}</td>
+    </tr>
+    {{end}}
+  </table>
+  <h3>Outputs</h3>
+  <table>
+    {{range .Tx.Vout}}
+    <tr><td>{{.N}}</td><td><a href="/address/{{.Address}}">{{.Address}}</a></td><td>{{.Value}}</td></tr>
+    {{end}}
+  </table>
+{{template "footer"}}{{end}}
+
+{{define "address"}}{{template "header"}}
+  <h2>Address {{.Address}}</h2>
+  <p>Balance: {{.Balance}}</p>
+  <h3>History</h3>
+  {{if .Pruned}}<p>only the blocks not pruned are listed</p>{{end}}
+  <table>
+    <tr><th>block</th><th>transaction</th><th>received</th><th>sent</th></tr>
+    {{range .History}}
+    <tr>
+      <td><a href="/block/{{.Height}}">{{.Height}}</a></td>
+      <td><a href="/tx/{{hex .Txid}}">{{hex .Txid}}</a></td>
+      <td>{{.Received}}</td>
+      <td>{{.Sent}}</td>
+    </tr>
+    {{end}}
+  </table>
+{{template "footer"}}{{end}}
+`
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// get requests a page of the explorer, returning its status and body
+func get(e *Explorer, path string) (int, string) {
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestExplorer(t *testing.T) {
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	alice, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	bob, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	aliceWallet, err := wallets.GetWallet(alice)
+	assert.Nil(t, err)
+
+	bc, err := chain.NewBlockchain(storage.NewMemory(), alice, chain.DefaultParams)
+	assert.Nil(t, err)
+	defer bc.Close()
+	UTXOSet := chain.UTXOSet{Blockchain: bc}
+	assert.Nil(t, UTXOSet.Reindex())
+	coinbase, err := bc.NewCoinbase(alice)
+	assert.Nil(t, err)
+	tx, err := chain.NewUTXOTransaction(aliceWallet, bob, 3, &UTXOSet)
+	assert.Nil(t, err)
+	block, err := bc.AddBlock([]*chain.Transaction{coinbase, tx})
+	assert.Nil(t, err)
+	e := NewExplorer(bc)
+	txid := hex.EncodeToString(tx.ID)
+
+	status, body := get(e, "/")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, hex.EncodeToString(block.Hash))
+
+	for _, id := range []string{"1", hex.EncodeToString(block.Hash)} {
+		status, body = get(e, "/block/"+id)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, "Block 1")
+		assert.Contains(t, body, txid)
+	}
+
+	status, body = get(e, "/tx/"+txid)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, alice, "Input resolved to its address")
+	assert.Contains(t, body, bob)
+
+	status, body = get(e, "/address/"+bob)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, "Balance: 3")
+	assert.Contains(t, body, txid)
+
+	unknown := strings.Repeat("ab", 32)
+	for _, path := range []string{
+		"/block/" + unknown,
+		"/block/2",
+		"/block/-1",
+		"/block/nothex",
+		"/tx/" + unknown,
+		"/tx/nothex",
+		"/address/" + bob[:len(bob)-1],
+		"/search?q=" + unknown,
+		"/nothing",
+	} {
+		status, body = get(e, path)
+		assert.Equal(t, http.StatusNotFound, status, path)
+		assert.Contains(t, body, "Nothing found", path)
+	}
+
+	for q, location := range map[string]string{
+		"1":   "/block/1",
+		txid:  "/tx/" + txid,
+		bob:   "/address/" + bob,
+		alice: "/address/" + alice,
+	} {
+		recorder := httptest.NewRecorder()
+		e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/search?q=%s", q), nil))
+		assert.Equal(t, http.StatusFound, recorder.Code, q)
+		assert.Equal(t, location, recorder.Header().Get("Location"), q)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/hex"
+	"sort"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// JSON representations of the chain data, as returned by the RPC server
+
+// BlockchainInfo summarizes the state of the chain
+type BlockchainInfo struct {
+	Blocks        int    `json:"blocks"`
+	BestBlockHash string `json:"bestblockhash"`
+	Difficulty    int    `json:"difficulty"`
+	// the hash function of a proof of work
+	PowHash    string `json:"powhash,omitempty"`
+	MedianTime int64  `json:"mediantime"`
+	// total weight of the chain, hex encoded
+	ChainWork   string `json:"chainwork"`
+	Pruned      bool   `json:"pruned"`
+	PruneHeight int    `json:"pruneheight,omitempty"`
+	// the consensus engine, the signers of a proof of authority and the
+	// stake lock of a proof of stake
+	Consensus string   `json:"consensus"`
+	Signers   []string `json:"signers,omitempty"`
+	StakeLock int      `json:"stakelock,omitempty"`
+}
+
+// BlockJSON is a Block with its binary fields hex encoded
+type BlockJSON struct {
+	Hash              string        `json:"hash"`
+	Height            int           `json:"height"`
+	Version           int           `json:"version"`
+	MerkleRoot        string        `json:"merkleroot"`
+	Time              int64         `json:"time"`
+	Nonce             int64         `json:"nonce"`
+	PreviousBlockHash string        `json:"previousblockhash,omitempty"`
+	Signer            string        `json:"signer,omitempty"`
+	Signature         string        `json:"signature,omitempty"`
+	Pruned            bool          `json:"pruned,omitempty"`
+	Tx                []interface{} `json:"tx"`
+}
+
+// NewBlockJSON converts a block, either with the full transactions or only
+// their ids
+func NewBlockJSON(block *chain.Block, withTransactions bool) BlockJSON {
+	blockJSON := BlockJSON{
+		Hash:              hex.EncodeToString(block.Hash),
+		Height:            block.Height,
+		Version:           block.Version,
+		MerkleRoot:        hex.EncodeToString(block.HashTransactions()),
+		Time:              block.Timestamp,
+		Nonce:             block.Nonce,
+		PreviousBlockHash: hex.EncodeToString(block.PrevBlockHash),
+		Signer:            hex.EncodeToString(block.Signer),
+		Signature:         hex.EncodeToString(block.Signature),
+		Pruned:            block.IsPruned(),
+		Tx:                []interface{}{},
+	}
+
+	for _, tx := range block.Transactions {
+		if withTransactions {
+			blockJSON.Tx = append(blockJSON.Tx, NewTransactionJSON(tx))
+		} else {
+			blockJSON.Tx = append(blockJSON.Tx, hex.EncodeToString(tx.ID))
+		}
+	}
+
+	return blockJSON
+}
+
+// Header decodes the header fields of the block
+func (b BlockJSON) Header() chain.BlockHeader {
+	return chain.BlockHeader{
+		Version:       b.Version,
+		Timestamp:     b.Time,
+		PrevBlockHash: decodeHex(b.PreviousBlockHash),
+		MerkleRoot:    decodeHex(b.MerkleRoot),
+		Hash:          decodeHex(b.Hash),
+		Nonce:         b.Nonce,
+		Height:        b.Height,
+		Signer:        decodeHex(b.Signer),
+		Signature:     decodeHex(b.Signature),
+	}
+}
+
+// TXInputJSON is a TXInput with its binary fields hex encoded
+type TXInputJSON struct {
+	Coinbase  string `json:"coinbase,omitempty"`
+	Txid      string `json:"txid,omitempty"`
+	Vout      int    `json:"vout"`
+	Signature string `json:"signature,omitempty"`
+	PubKey    string `json:"pubkey,omitempty"`
+	// the signature and public key, when they are in the witness
+	Witness []string `json:"txinwitness,omitempty"`
+}
+
+// TXOutputJSON is a TXOutput along with the address it pays to, or whose
+// stake it is
+type TXOutputJSON struct {
+	Value      int    `json:"value"`
+	N          int    `json:"n"`
+	PubKeyHash string `json:"pubkeyhash"`
+	Address    string `json:"address"`
+	Stake      bool   `json:"stake,omitempty"`
+}
+
+// TransactionJSON is a Transaction with its binary fields hex encoded
+type TransactionJSON struct {
+	Txid string `json:"txid"`
+	// the witness hash
+	Hash string         `json:"hash"`
+	Hex  string         `json:"hex"`
+	Vin  []TXInputJSON  `json:"vin"`
+	Vout []TXOutputJSON `json:"vout"`
+}
+
+// NewTransactionJSON converts a transaction
+func NewTransactionJSON(tx *chain.Transaction) TransactionJSON {
+	txJSON := TransactionJSON{
+		Txid: hex.EncodeToString(tx.ID),
+		Hash: hex.EncodeToString(tx.WitnessHash()),
+		Hex:  hex.EncodeToString(tx.Serialize()),
+		Vin:  []TXInputJSON{},
+		Vout: []TXOutputJSON{},
+	}
+
+	for i, in := range tx.Vin {
+		if tx.IsCoinbase() {
+			// the pubkey of a coinbase input holds arbitrary data
+			txJSON.Vin = append(txJSON.Vin, TXInputJSON{Coinbase: hex.EncodeToString(in.PubKey), Vout: in.Vout})
+			continue
+		}
+
+		inJSON := TXInputJSON{
+			Txid:      hex.EncodeToString(in.Txid),
+			Vout:      in.Vout,
+			Signature: hex.EncodeToString(in.Signature),
+			PubKey:    hex.EncodeToString(in.PubKey),
+		}
+		if tx.HasWitness() {
+			witness := tx.Witness[i]
+			inJSON.Witness = []string{hex.EncodeToString(witness.Signature), hex.EncodeToString(witness.PubKey)}
+		}
+		txJSON.Vin = append(txJSON.Vin, inJSON)
+	}
+
+	for i, out := range tx.Vout {
+		outJSON := TXOutputJSON{
+			Value:      out.Value,
+			N:          i,
+			PubKeyHash: hex.EncodeToString(out.PubKeyHash),
+			Address:    wallet.PubKeyHashToAddress(out.PubKeyHash),
+		}
+		if owner, ok := out.StakeOwner(); ok {
+			outJSON.Address, outJSON.Stake = wallet.PubKeyHashToAddress(owner), true
+		}
+		txJSON.Vout = append(txJSON.Vout, outJSON)
+	}
+
+	return txJSON
+}
+
+// UnspentJSON is an entry of the `listunspent` result
+type UnspentJSON struct {
+	Txid    string `json:"txid"`
+	Vout    int    `json:"vout"`
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// TxOutSetInfoJSON is the result of `gettxoutsetinfo`
+type TxOutSetInfoJSON struct {
+	Height         int    `json:"height"`
+	BestBlock      string `json:"bestblock"`
+	Transactions   int    `json:"transactions"`
+	TxOuts         int    `json:"txouts"`
+	HashSerialized string `json:"hash_serialized"`
+	TotalAmount    int    `json:"total_amount"`
+}
+
+// NewTxOutSetInfoJSON converts a summary of the UTXO set
+func NewTxOutSetInfoJSON(info chain.TxOutSetInfo) TxOutSetInfoJSON {
+	return TxOutSetInfoJSON{
+		Height:         info.Height,
+		BestBlock:      hex.EncodeToString(info.BestBlock),
+		Transactions:   info.Transactions,
+		TxOuts:         info.TxOuts,
+		HashSerialized: hex.EncodeToString(info.Hash),
+		TotalAmount:    info.TotalAmount,
+	}
+}
+
+// BlockTemplateJSON is the result of `getblocktemplate`: what an external
+// miner needs to build the next block, transactions hex encoded like raw ones
+type BlockTemplateJSON struct {
+	Version           int    `json:"version"`
+	PreviousBlockHash string `json:"previousblockhash"`
+	Height            int    `json:"height"`
+	CurTime           int64  `json:"curtime"`
+	MinTime           int64  `json:"mintime"`
+	// the number of leading zero bits of the target, and the hash function
+	// of the proof of work
+	Bits          int                     `json:"bits"`
+	PowHash       string                  `json:"powhash"`
+	CoinbaseValue int                     `json:"coinbasevalue"`
+	CoinbaseTxn   TemplateTransactionJSON `json:"coinbasetxn"`
+	// the transactions to include after the coinbase, in order
+	Transactions []TemplateTransactionJSON `json:"transactions"`
+	SizeLimit    int                       `json:"sizelimit"`
+	SigOpLimit   int                       `json:"sigoplimit"`
+	TxLimit      int                       `json:"txlimit"`
+	// the deployments the block enforces
+	Rules []string `json:"rules"`
+}
+
+// TemplateTransactionJSON is a transaction of a block template
+type TemplateTransactionJSON struct {
+	Data   string `json:"data"`
+	Txid   string `json:"txid"`
+	Fee    int    `json:"fee"`
+	SigOps int    `json:"sigops"`
+}
+
+// NewBlockTemplateJSON converts a block template for a chain using params
+func NewBlockTemplateJSON(template *chain.BlockTemplate, params chain.Params) BlockTemplateJSON {
+	var txs []TemplateTransactionJSON
+	for i, tx := range template.Transactions {
+		txs = append(txs, TemplateTransactionJSON{
+			Data:   chain.EncodeRawTransaction(tx),
+			Txid:   hex.EncodeToString(tx.ID),
+			Fee:    template.TxFees[i],
+			SigOps: tx.SigOps(),
+		})
+	}
+
+	coinbaseValue := 0
+	for _, out := range template.Transactions[0].Vout {
+		coinbaseValue += out.Value
+	}
+
+	rules := []string{}
+	for name := range template.Rules {
+		rules = append(rules, name)
+	}
+	sort.Strings(rules)
+
+	return BlockTemplateJSON{
+		Version:           template.Version,
+		PreviousBlockHash: hex.EncodeToString(template.PrevBlockHash),
+		Height:            template.Height,
+		CurTime:           template.Timestamp,
+		MinTime:           template.MinTime,
+		Bits:              params.PowBits,
+		PowHash:           params.PowHash,
+		CoinbaseValue:     coinbaseValue,
+		CoinbaseTxn:       txs[0],
+		Transactions:      append([]TemplateTransactionJSON{}, txs[1:]...),
+		SizeLimit:         params.MaxBlockSize,
+		SigOpLimit:        params.MaxBlockSigOps,
+		TxLimit:           params.MaxBlockTxs,
+		Rules:             rules,
+	}
+}
+
+// BalancesJSON is the result of `getbalances`
+type BalancesJSON struct {
+	Spendable int `json:"spendable"`
+	// coinbase outputs not mature yet
+	Immature int `json:"immature"`
+	// locked in stake outputs, see `stake`
+	Staked int `json:"staked,omitempty"`
+}
+
+// SupplyJSON is the result of `getsupply`
+type SupplyJSON struct {
+	Height      int `json:"height"`
+	Issued      int `json:"issued"`
+	Unspent     int `json:"unspent"`
+	Subsidy     int `json:"subsidy"`
+	NextHalving int `json:"next_halving"`
+	MaxSupply   int `json:"max_supply"`
+}
+
+// NewSupplyJSON converts a summary of the money supply
+func NewSupplyJSON(supply chain.Supply) SupplyJSON {
+	return SupplyJSON(supply)
+}
+
+// DeploymentInfoJSON is the result of `getdeploymentinfo`, the state of the
+// deployments for the block after the tip
+type DeploymentInfoJSON struct {
+	Hash        string                    `json:"hash"`
+	Height      int                       `json:"height"`
+	Deployments map[string]DeploymentJSON `json:"deployments"`
+}
+
+// DeploymentJSON is a deployment of `getdeploymentinfo`
+type DeploymentJSON struct {
+	Active    bool   `json:"active"`
+	Bit       int    `json:"bit"`
+	StartTime int64  `json:"start_time"`
+	Timeout   int64  `json:"timeout"`
+	Status    string `json:"status"`
+	Since     int    `json:"since"`
+	// the signals of the current window, while started
+	Statistics *DeploymentStatisticsJSON `json:"statistics,omitempty"`
+}
+
+// DeploymentStatisticsJSON counts the signals of the current window
+type DeploymentStatisticsJSON struct {
+	Period    int `json:"period"`
+	Threshold int `json:"threshold"`
+	Elapsed   int `json:"elapsed"`
+	Count     int `json:"count"`
+	// whether the threshold can still be reached within the window
+	Possible bool `json:"possible"`
+}
+
+// NewDeploymentInfoJSON converts the deployments of the block after the one
+// at height, of a chain with windows of the given size
+func NewDeploymentInfoJSON(hash []byte, height int, infos []chain.DeploymentInfo, window int) DeploymentInfoJSON {
+	result := DeploymentInfoJSON{
+		Hash:        hex.EncodeToString(hash),
+		Height:      height,
+		Deployments: make(map[string]DeploymentJSON),
+	}
+
+	for _, info := range infos {
+		deployment := DeploymentJSON{
+			Active:    info.State == chain.ACTIVE,
+			Bit:       info.Bit,
+			StartTime: info.Start,
+			Timeout:   info.Timeout,
+			Status:    info.State.String(),
+			Since:     info.Since,
+		}
+		if info.State == chain.STARTED {
+			deployment.Statistics = &DeploymentStatisticsJSON{
+				Period:    window,
+				Threshold: info.Threshold,
+				Elapsed:   info.Elapsed,
+				Count:     info.Count,
+				Possible:  info.Count+window-info.Elapsed >= info.Threshold,
+			}
+		}
+		result.Deployments[info.Name] = deployment
+	}
+
+	return result
+}
+
+// DumpTxOutSetJSON is the result of `dumptxoutset`
+type DumpTxOutSetJSON struct {
+	CoinsWritten int    `json:"coins_written"`
+	BaseHash     string `json:"base_hash"`
+	BaseHeight   int    `json:"base_height"`
+	Path         string `json:"path"`
+	TxOutSetHash string `json:"txoutset_hash"`
+}
@@ -1,9 +1,8 @@
-package main
+// Package merkle builds the Merkle tree committing to the transactions of a
+// block.
+package merkle
 
-import (
-	"crypto/sha256"
-	"log"
-)
+import "crypto/sha256"
 
 // MerkleTree represent a Merkle tree
 type MerkleTree struct {
@@ -21,22 +20,24 @@ type MerkleNode struct {
 func NewMerkleTree(data [][]byte) *MerkleTree {
 	var nodes []MerkleNode
 
-	if len(data)%2 != 0 {
-		// odd number of leaves
-		log.Println("copying last leaf node to have an even number")
-		data = append(data, data[len(data)-1])
+	if len(data) == 0 {
+		// still give a root to commit to
+		return &MerkleTree{NewMerkleNode(nil, nil, nil)}
 	}
 
-	log.Println("initialising tree bottom level")
 	for _, datum := range data {
 		node := NewMerkleNode(nil, nil, datum)
 		nodes = append(nodes, *node)
 	}
 
-	log.Printf("assembling the remaining %d levels\n", len(data)/2)
-	for i := 0; i < len(data)/2; i++ {
+	for len(nodes) > 1 {
 		var newLevel []MerkleNode
 
+		if len(nodes)%2 != 0 {
+			// odd number of nodes at this level
+			nodes = append(nodes, nodes[len(nodes)-1])
+		}
+
 		// for each pair of nodes, create one new at this level
 		for j := 0; j < len(nodes); j += 2 {
 			node := NewMerkleNode(&nodes[j], &nodes[j+1], nil)
@@ -62,7 +63,7 @@ func NewMerkleNode(left, right *MerkleNode, data []byte) *MerkleNode {
 		mNode.Data = hash[:]
 	} else {
 		// a new node ot of 2 leaves is the hash of their 2 respective hashes
-		prevHashes := append(left.Data, right.Data...)
+		prevHashes := append(append([]byte{}, left.Data...), right.Data...)
 		hash := sha256.Sum256(prevHashes)
 		mNode.Data = hash[:]
 	}
@@ -1,4 +1,4 @@
-package main
+package merkle
 
 import (
 	"encoding/hex"
@@ -73,3 +73,20 @@ func TestNewMerkleTree(t *testing.T) {
 
 	assert.Equal(t, rootHash, fmt.Sprintf("%x", mTree.RootNode.Data), "Merkle tree root hash is correct")
 }
+
+func TestNewMerkleTreeOddLevels(t *testing.T) {
+	var data [][]byte
+	for i := 0; i < 6; i++ {
+		data = append(data, []byte(fmt.Sprintf("node%d", i)))
+	}
+
+	// 6 leaves, then 3 nodes, padded to 4
+	l := make([]*MerkleNode, 6)
+	for i := range data {
+		l[i] = NewMerkleNode(nil, nil, data[i])
+	}
+	n01, n23, n45 := NewMerkleNode(l[0], l[1], nil), NewMerkleNode(l[2], l[3], nil), NewMerkleNode(l[4], l[5], nil)
+	root := NewMerkleNode(NewMerkleNode(n01, n23, nil), NewMerkleNode(n45, n45, nil), nil)
+
+	assert.Equal(t, root.Data, NewMerkleTree(data).RootNode.Data, "Odd levels are padded too")
+}
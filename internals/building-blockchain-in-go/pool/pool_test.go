@@ -0,0 +1,112 @@
+package pool
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+func newAddress(t *testing.T) string {
+	wallets := &wallet.Wallets{Wallets: map[string]*wallet.Wallet{}}
+	address, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+
+	return address
+}
+
+// fixedAddress returns the address of a made up public key hash starting
+// with b, for tests that need no key
+func fixedAddress(b byte) string {
+	return wallet.PubKeyHashToAddress(append([]byte{b}, bytes.Repeat([]byte{0xab}, 19)...))
+}
+
+func TestPayouts(t *testing.T) {
+	// a hash starting with a zero byte takes an extra leading 1
+	poolAddress, a, b := fixedAddress(0x01), fixedAddress(0x00), fixedAddress(0x02)
+	s, err := NewServer(nil, Config{Address: poolAddress})
+	assert.Nil(t, err)
+
+	outputs, paid, err := s.payouts(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(outputs), "No share yet, the pool takes it all")
+	assert.Equal(t, 10, outputs[0].Value)
+	assert.Empty(t, paid)
+
+	s.workers[a] = &WorkerStats{Address: a, RoundShares: 3}
+	s.workers[b] = &WorkerStats{Address: b, RoundShares: 1}
+	outputs, paid, err = s.payouts(10)
+	assert.Nil(t, err)
+	values := map[string]int{}
+	for _, out := range outputs {
+		values[wallet.PubKeyHashToAddress(out.PubKeyHash)] = out.Value
+	}
+	assert.Equal(t, map[string]int{a: 7, b: 2, poolAddress: 1}, values, "The pool gets what the rounding leaves")
+	assert.Equal(t, map[string]int{a: 3, b: 1}, paid)
+}
+
+func TestPoolMining(t *testing.T) {
+	poolAddress, a, b := newAddress(t), newAddress(t), newAddress(t)
+
+	params := chain.DefaultParams
+	params.CoinbaseMaturity = 1
+	bc, err := chain.NewBlockchain(storage.NewMemory(), poolAddress, params)
+	assert.Nil(t, err)
+	defer bc.Close()
+	assert.Nil(t, chain.UTXOSet{Blockchain: bc}.Reindex())
+
+	s, err := NewServer(bc, Config{Address: poolAddress, JobInterval: 50 * time.Millisecond})
+	assert.Nil(t, err)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go func() { _ = s.Serve(l) }()
+	defer s.Close()
+
+	stop := make(chan struct{})
+	var workers []*Worker
+	for _, address := range []string{a, b} {
+		w, err := Dial(l.Addr().String(), address)
+		assert.Nil(t, err)
+		defer w.Close()
+		workers = append(workers, w)
+		go func() { _ = w.Mine(stop) }()
+	}
+
+	// wait for blocks paying both workers
+	balance := func(address string) int {
+		pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+		assert.Nil(t, err)
+		spendable, immature, err := chain.UTXOSet{Blockchain: bc}.Balance(pubKeyHash)
+		assert.Nil(t, err)
+		return spendable + immature
+	}
+	deadline := time.Now().Add(time.Minute)
+	for balance(a) == 0 || balance(b) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no block paid both workers")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	close(stop)
+
+	stats := s.Stats()
+	assert.Equal(t, 2, len(stats))
+	blocks := 0
+	for _, worker := range stats {
+		assert.True(t, worker.Shares > 0)
+		blocks += worker.Blocks
+	}
+	assert.True(t, blocks > 0)
+	for _, w := range workers {
+		accepted, _ := w.Stats()
+		assert.True(t, accepted > 0)
+	}
+
+	_, err = bc.VerifyChain(4, 0)
+	assert.Nil(t, err)
+}
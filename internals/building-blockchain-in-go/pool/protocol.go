@@ -0,0 +1,163 @@
+// Package pool implements a mining pool speaking a simplified version of
+// Stratum, the protocol most Bitcoin pools use:
+// https://en.bitcoin.it/wiki/Stratum_mining_protocol
+//
+// Alone, a small miner may wait a very long time before finding a block. In a
+// pool, workers mine the same block, whose coinbase pays all of them. To know
+// how much work each one does, the pool asks for "shares": hashes meeting an
+// easier target than the block's. Every share is a lottery ticket, and the
+// ones also meeting the block target are blocks the pool submits to the
+// chain. The reward is split between the workers in proportion to their
+// shares.
+//
+// Messages are JSON objects, one per line, over a plain TCP connection:
+//
+//	-> {"id": 1, "method": "mining.subscribe", "params": []}
+//	<- {"id": 1, "result": ["0000002a", 4], "error": null}
+//	<- {"id": null, "method": "mining.set_difficulty", "params": [8]}
+//	<- {"id": null, "method": "mining.notify", "params": [{"job_id": "1", ...}]}
+//	-> {"id": 2, "method": "mining.authorize", "params": ["ADDRESS"]}
+//	<- {"id": 2, "result": true, "error": null}
+//	-> {"id": 3, "method": "mining.submit", "params": ["ADDRESS", "1", "00000007", 1650000000, 1234]}
+//	<- {"id": 3, "result": true, "error": null}
+//
+// Unlike Stratum, the difficulty is a number of leading zero bits, workers
+// are named after the address they want to be paid to, and jobs carry whole
+// transactions rather than the merkle branch of the coinbase, along with the
+// hash function of the chain.
+package pool
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/pow"
+)
+
+const (
+	POOL_ADDR = "127.0.0.1:3333"
+	// shares need 8 leading zero bits, 256 times less work than a block
+	SHARE_BITS = 8
+	// the pool assigns an extra nonce prefix to each connection, for workers
+	// not to mine the same blocks. Workers roll the rest.
+	EXTRANONCE1_SIZE = 4
+	EXTRANONCE2_SIZE = 4
+)
+
+// error codes of Stratum
+const (
+	ERR_OTHER          = 20
+	ERR_JOB_NOT_FOUND  = 21
+	ERR_DUPLICATE      = 22
+	ERR_LOW_DIFFICULTY = 23
+	ERR_UNAUTHORIZED   = 24
+	ERR_NOT_SUBSCRIBED = 25
+)
+
+type request struct {
+	ID     interface{}       `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type response struct {
+	ID     interface{} `json:"id"`
+	Result interface{} `json:"result"`
+	// [code, message, traceback]
+	Error []interface{} `json:"error"`
+}
+
+// notification is a request from the pool, expecting no response
+type notification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// message is whatever a worker may receive from the pool
+type message struct {
+	ID     *int              `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result"`
+	Error  []interface{}     `json:"error"`
+}
+
+// Job is the block workers are asked to mine. The coinbase gets the extra
+// nonce of the worker right after the height, before computing the merkle
+// root.
+type Job struct {
+	ID            string `json:"job_id"`
+	PrevBlockHash string `json:"prevhash"`
+	Height        int    `json:"height"`
+	// the version of the block, signalling the deployments of the chain
+	Version  int    `json:"version"`
+	Coinbase string `json:"coinbase"`
+	// the other transactions of the block
+	Transactions []string `json:"transactions"`
+	// workers may timestamp the block from MinTime, Time is the clock of
+	// the pool
+	MinTime int64 `json:"mintime"`
+	Time    int64 `json:"ntime"`
+	// the proof of work of the chain, shares commit to its bits too
+	PowHash string `json:"powhash"`
+	Bits    int    `json:"bits"`
+	// the previous jobs are stale, the tip changed
+	CleanJobs bool `json:"clean_jobs"`
+}
+
+// decodedJob is a job with its transactions decoded, ready to build blocks
+type decodedJob struct {
+	Job
+	prevBlockHash []byte
+	coinbase      *chain.Transaction
+	transactions  []*chain.Transaction
+	function      pow.Function
+}
+
+func decodeJob(job Job) (*decodedJob, error) {
+	prevBlockHash, err := hex.DecodeString(job.PrevBlockHash)
+	if err != nil {
+		return nil, err
+	}
+	coinbase, err := chain.DecodeRawTransaction(job.Coinbase)
+	if err != nil {
+		return nil, err
+	}
+	function, err := pow.NewFunction(job.PowHash, job.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	decoded := &decodedJob{Job: job, prevBlockHash: prevBlockHash, coinbase: coinbase, function: function}
+	for _, rawTx := range job.Transactions {
+		tx, err := chain.DecodeRawTransaction(rawTx)
+		if err != nil {
+			return nil, err
+		}
+		decoded.transactions = append(decoded.transactions, tx)
+	}
+
+	return decoded, nil
+}
+
+// block builds the block of the job, with the extra nonce in its coinbase
+func (j *decodedJob) block(extraNonce []byte, timestamp int64, nonce int64) *chain.Block {
+	transactions := append([]*chain.Transaction{j.coinbase.WithExtraNonce(extraNonce)}, j.transactions...)
+
+	return &chain.Block{
+		Version:       j.Version,
+		Timestamp:     timestamp,
+		Transactions:  transactions,
+		PrevBlockHash: j.prevBlockHash,
+		Hash:          []byte{},
+		Nonce:         nonce,
+		Height:        j.Height,
+	}
+}
+
+// Stratum errors are [code, message, traceback]
+func stratumError(code int, message string) []interface{} {
+	return []interface{}{code, message, nil}
+}
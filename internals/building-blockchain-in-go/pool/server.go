@@ -0,0 +1,535 @@
+package pool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/pow"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// JOB_INTERVAL is how often new jobs are sent while the tip does not change,
+// for their coinbase to pay the shares submitted since
+const JOB_INTERVAL = 30 * time.Second
+
+// Config sets up a pool
+type Config struct {
+	// Address is paid what the rounding of the payouts leaves, and the
+	// whole reward of the blocks found before any share
+	Address string
+	// leading zero bits of the shares, SHARE_BITS when 0
+	ShareBits int
+	// JOB_INTERVAL when 0
+	JobInterval time.Duration
+	// Candidates returns the transactions to mine, may be nil
+	Candidates func() []*chain.Transaction
+}
+
+// WorkerStats is the accounting of a worker, by payout address
+type WorkerStats struct {
+	Address string
+	// shares of the current round, paid by the next block the pool finds
+	RoundShares int
+	// accepted and rejected shares since the pool started
+	Shares   int
+	Rejected int
+	// blocks found by the worker
+	Blocks int
+}
+
+// Server distributes the jobs to the workers and accounts for their shares
+type Server struct {
+	bc       *chain.Blockchain
+	config   Config
+	function pow.Function
+
+	mu       sync.Mutex
+	listener net.Listener
+	sessions map[*session]struct{}
+	// the jobs still valid, by ID, current being the last one sent
+	jobs    map[string]*job
+	current *job
+	lastJob int
+	// prefix of the extra nonces of the next connection
+	lastExtraNonce uint32
+	workers        map[string]*WorkerStats
+	done           chan struct{}
+}
+
+// job is a job sent to the workers
+type job struct {
+	*decodedJob
+	// round shares of each address paid by the coinbase, deduced from the
+	// round if the job turns into a block
+	paid map[string]int
+	// shares already submitted, to reject duplicates
+	submitted map[string]bool
+}
+
+// session is a worker connection
+type session struct {
+	conn       net.Conn
+	writeMu    sync.Mutex
+	extraNonce []byte
+	subscribed bool
+	// the addresses authorized on the connection
+	authorized map[string]bool
+}
+
+// NewServer creates a pool mining on top of the chain
+func NewServer(bc *chain.Blockchain, config Config) (*Server, error) {
+	function := pow.Default
+	if bc != nil {
+		engine, ok := bc.Engine().(chain.ProofOfWork)
+		if !ok {
+			return nil, errors.New("pools only mine proof of work chains")
+		}
+		function = engine.Function
+	}
+	if !wallet.ValidateAddress(config.Address) {
+		return nil, fmt.Errorf("invalid pool address %s", config.Address)
+	}
+	if config.ShareBits == 0 {
+		config.ShareBits = SHARE_BITS
+	}
+	if config.ShareBits < 1 || config.ShareBits > function.Bits {
+		return nil, fmt.Errorf("shares need between 1 and %d bits", function.Bits)
+	}
+	if config.JobInterval == 0 {
+		config.JobInterval = JOB_INTERVAL
+	}
+
+	return &Server{
+		bc:       bc,
+		config:   config,
+		function: function,
+		sessions: map[*session]struct{}{},
+		jobs:     map[string]*job{},
+		workers:  map[string]*WorkerStats{},
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// ListenAndServe serves the workers connecting on addr, until Close
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve serves the workers connecting through l, until Close
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	s.listener = l
+	err := s.newJob(true)
+	s.mu.Unlock()
+	if err != nil {
+		l.Close()
+		return err
+	}
+
+	events := s.bc.Subscribe()
+	defer events.Unsubscribe()
+	go s.watch(events)
+
+	log.Printf("mining pool listening on %s, shares of %d bits\n", l.Addr(), s.config.ShareBits)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-s.done:
+				return nil
+			default:
+				return err
+			}
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the pool and disconnects the workers
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	default:
+		close(s.done)
+	}
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	if s.listener == nil {
+		return nil
+	}
+
+	return s.listener.Close()
+}
+
+// Stats returns the accounting of every worker, by address
+func (s *Server) Stats() []WorkerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stats []WorkerStats
+	for _, worker := range s.workers {
+		stats = append(stats, *worker)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Address < stats[j].Address })
+
+	return stats
+}
+
+// watch sends new jobs when the tip changes, and every JobInterval for the
+// coinbase to pay the latest shares
+func (s *Server) watch(events *chain.Subscription) {
+	ticker := time.NewTicker(s.config.JobInterval)
+	defer ticker.Stop()
+
+	for {
+		var clean bool
+		select {
+		case <-s.done:
+			return
+		case event, ok := <-events.C:
+			if !ok {
+				return
+			}
+			if event.Type == chain.TxAccepted {
+				continue
+			}
+			clean = true
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		if clean && bytes.Equal(s.current.prevBlockHash, s.bc.Tip()) {
+			// already mining on the tip, the pool found the block
+			s.mu.Unlock()
+			continue
+		}
+		if err := s.newJob(clean); err != nil {
+			log.Printf("pool: failed to create a job: %s\n", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// newJob builds the next block for the workers and sends it to them. Clean
+// jobs make the previous ones stale. Must be called with mu held.
+func (s *Server) newJob(clean bool) error {
+	var candidates []*chain.Transaction
+	if s.config.Candidates != nil {
+		candidates = s.config.Candidates()
+	}
+
+	template, err := s.bc.NewBlockTemplate(candidates, s.config.Address)
+	if err != nil {
+		return err
+	}
+
+	// the coinbase of the template pays the subsidy and the fees to the pool,
+	// split them between the workers instead
+	reward := 0
+	for _, out := range template.Transactions[0].Vout {
+		reward += out.Value
+	}
+	outputs, paid, err := s.payouts(reward)
+	if err != nil {
+		return err
+	}
+	coinbase := chain.NewPayoutCoinbaseTX("", template.Height, outputs)
+	coinbase = chain.CommitWitnesses(append([]*chain.Transaction{coinbase}, template.Transactions[1:]...))[0]
+
+	s.lastJob++
+	j := Job{
+		ID:            strconv.Itoa(s.lastJob),
+		Version:       template.Version,
+		PrevBlockHash: hex.EncodeToString(template.PrevBlockHash),
+		Height:        template.Height,
+		Coinbase:      chain.EncodeRawTransaction(coinbase),
+		MinTime:       template.MinTime,
+		Time:          template.Timestamp,
+		PowHash:       s.function.Hash.Name(),
+		Bits:          s.function.Bits,
+		CleanJobs:     clean,
+	}
+	for _, tx := range template.Transactions[1:] {
+		j.Transactions = append(j.Transactions, chain.EncodeRawTransaction(tx))
+	}
+
+	decoded, err := decodeJob(j)
+	if err != nil {
+		return err
+	}
+	if clean {
+		s.jobs = map[string]*job{}
+	}
+	s.current = &job{decoded, paid, map[string]bool{}}
+	s.jobs[j.ID] = s.current
+
+	for sess := range s.sessions {
+		if sess.subscribed {
+			sess.notify("mining.notify", j)
+		}
+	}
+
+	return nil
+}
+
+// payouts splits the reward between the workers, in proportion to their
+// shares of the round. It returns the outputs of the coinbase and the shares
+// they pay.
+func (s *Server) payouts(reward int) ([]chain.TXOutput, map[string]int, error) {
+	total := 0
+	for _, worker := range s.workers {
+		total += worker.RoundShares
+	}
+
+	var addresses []string
+	for address, worker := range s.workers {
+		if worker.RoundShares > 0 {
+			addresses = append(addresses, address)
+		}
+	}
+	// the same shares always give the same coinbase
+	sort.Strings(addresses)
+
+	var outputs []chain.TXOutput
+	paid := map[string]int{}
+	left := reward
+	for _, address := range addresses {
+		shares := s.workers[address].RoundShares
+		value := reward * shares / total
+		if value == 0 {
+			continue
+		}
+		out, err := chain.NewTXOutput(value, address)
+		if err != nil {
+			return nil, nil, err
+		}
+		outputs = append(outputs, *out)
+		paid[address] = shares
+		left -= value
+	}
+
+	if left > 0 {
+		out, err := chain.NewTXOutput(left, s.config.Address)
+		if err != nil {
+			return nil, nil, err
+		}
+		outputs = append(outputs, *out)
+	}
+
+	return outputs, paid, nil
+}
+
+// serveConn answers the requests of a worker until it disconnects
+func (s *Server) serveConn(conn net.Conn) {
+	s.mu.Lock()
+	s.lastExtraNonce++
+	sess := &session{conn: conn, extraNonce: make([]byte, EXTRANONCE1_SIZE), authorized: map[string]bool{}}
+	binary.BigEndian.PutUint32(sess.extraNonce, s.lastExtraNonce)
+	s.sessions[sess] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Printf("pool: malformed request from %s: %s\n", conn.RemoteAddr(), err)
+			return
+		}
+
+		result, stratumErr := s.dispatch(sess, req)
+		if err := sess.send(response{req.ID, result, stratumErr}); err != nil {
+			return
+		}
+
+		// new workers get the difficulty and the current job right away
+		if req.Method == "mining.subscribe" && stratumErr == nil {
+			s.mu.Lock()
+			job := s.current.Job
+			s.mu.Unlock()
+			job.CleanJobs = true
+			sess.notify("mining.set_difficulty", s.config.ShareBits)
+			sess.notify("mining.notify", job)
+		}
+	}
+}
+
+func (s *Server) dispatch(sess *session, req request) (interface{}, []interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch req.Method {
+	case "mining.subscribe":
+		return s.subscribe(sess)
+	case "mining.authorize":
+		return s.authorize(sess, req.Params)
+	case "mining.submit":
+		return s.submit(sess, req.Params)
+	}
+
+	return nil, stratumError(ERR_OTHER, fmt.Sprintf("Method %s not found", req.Method))
+}
+
+// mining.subscribe
+// Returns the extra nonce prefix of the connection and the size of the part
+// the worker rolls.
+func (s *Server) subscribe(sess *session) (interface{}, []interface{}) {
+	sess.subscribed = true
+
+	return []interface{}{hex.EncodeToString(sess.extraNonce), EXTRANONCE2_SIZE}, nil
+}
+
+// mining.authorize "address"
+// Workers are named after the address their shares are paid to.
+func (s *Server) authorize(sess *session, params []json.RawMessage) (interface{}, []interface{}) {
+	var address string
+	if len(params) < 1 || json.Unmarshal(params[0], &address) != nil {
+		return nil, stratumError(ERR_OTHER, "Expected the address of the worker")
+	}
+	if !wallet.ValidateAddress(address) {
+		return false, stratumError(ERR_UNAUTHORIZED, "Invalid address")
+	}
+
+	sess.authorized[address] = true
+	if _, ok := s.workers[address]; !ok {
+		s.workers[address] = &WorkerStats{Address: address}
+	}
+
+	return true, nil
+}
+
+// mining.submit "address" "jobid" "extranonce2" ntime nonce
+func (s *Server) submit(sess *session, params []json.RawMessage) (interface{}, []interface{}) {
+	var address, jobID, extraNonce2 string
+	var timestamp int64
+	var nonce int64
+	if len(params) != 5 {
+		return nil, stratumError(ERR_OTHER, "Expected 5 parameters")
+	}
+	for i, param := range []interface{}{&address, &jobID, &extraNonce2, &timestamp, &nonce} {
+		if err := json.Unmarshal(params[i], param); err != nil {
+			return nil, stratumError(ERR_OTHER, fmt.Sprintf("Invalid parameter %d: %s", i+1, err))
+		}
+	}
+
+	if !sess.subscribed {
+		return nil, stratumError(ERR_NOT_SUBSCRIBED, "Not subscribed")
+	}
+	if !sess.authorized[address] {
+		return nil, stratumError(ERR_UNAUTHORIZED, "Unauthorized worker")
+	}
+	worker := s.workers[address]
+
+	reject := func(code int, message string) (interface{}, []interface{}) {
+		worker.Rejected++
+		return nil, stratumError(code, message)
+	}
+
+	j, ok := s.jobs[jobID]
+	if !ok {
+		return reject(ERR_JOB_NOT_FOUND, "Job not found")
+	}
+	extraNonce, err := hex.DecodeString(extraNonce2)
+	if err != nil || len(extraNonce) != EXTRANONCE2_SIZE {
+		return reject(ERR_OTHER, fmt.Sprintf("Expected %d bytes of extra nonce", EXTRANONCE2_SIZE))
+	}
+	if timestamp < j.MinTime || timestamp > chain.AdjustedTime()+chain.MAX_FUTURE_BLOCK_TIME {
+		return reject(ERR_OTHER, "Time out of range")
+	}
+	if nonce < 0 || nonce > pow.MAX_NONCE {
+		return reject(ERR_OTHER, "Nonce out of range")
+	}
+
+	extraNonce = append(append([]byte{}, sess.extraNonce...), extraNonce...)
+	key := fmt.Sprintf("%x/%d/%d", extraNonce, timestamp, nonce)
+	if j.submitted[key] {
+		return reject(ERR_DUPLICATE, "Duplicate share")
+	}
+
+	block := j.block(extraNonce, timestamp, nonce)
+	hash := block.ProofOfWork(j.function).Hash()
+	if !pow.MeetsTargetBits(hash, s.config.ShareBits) {
+		return reject(ERR_LOW_DIFFICULTY, "Low difficulty share")
+	}
+
+	j.submitted[key] = true
+	worker.Shares++
+	worker.RoundShares++
+
+	if j.function.MeetsTarget(hash) {
+		s.submitBlock(worker, j, block, hash)
+	}
+
+	return true, nil
+}
+
+// submitBlock connects the block a worker found, which ends the round for the
+// shares it pays. Must be called with mu held.
+func (s *Server) submitBlock(worker *WorkerStats, j *job, block *chain.Block, hash []byte) {
+	block.Hash = hash
+	if err := s.bc.ConnectBlock(block); err != nil {
+		// another block may have extended the tip first
+		log.Printf("pool: block %d from %s rejected: %s\n", block.Height, worker.Address, err)
+		return
+	}
+
+	worker.Blocks++
+	for address, shares := range j.paid {
+		s.workers[address].RoundShares -= shares
+	}
+	log.Printf("pool: block %d %x found by %s, paying %d workers\n", block.Height, block.Hash, worker.Address, len(j.paid))
+
+	// the shares of the other jobs can no longer make a block
+	if err := s.newJob(true); err != nil {
+		log.Printf("pool: failed to create a job: %s\n", err)
+	}
+}
+
+// send writes a message on its own line
+func (sess *session) send(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	sess.writeMu.Lock()
+	defer sess.writeMu.Unlock()
+	_, err = sess.conn.Write(append(data, '\n'))
+
+	return err
+}
+
+// notify sends a notification, a disconnected worker is dropped by serveConn
+func (sess *session) notify(method string, params ...interface{}) {
+	if err := sess.send(notification{nil, method, params}); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("pool: failed to notify %s: %s\n", sess.conn.RemoteAddr(), err)
+	}
+}
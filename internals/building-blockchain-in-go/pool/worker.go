@@ -0,0 +1,308 @@
+package pool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/pow"
+)
+
+// nonces are tried by chunks, to move on to new jobs quickly
+const NONCE_CHUNK = 1 << 16
+
+// Worker mines the jobs of a pool, its shares paid to Address
+type Worker struct {
+	Address string
+
+	conn    net.Conn
+	scanner *bufio.Scanner
+	writeMu sync.Mutex
+	lastID  int
+
+	mu          sync.Mutex
+	extraNonce1 []byte
+	shareBits   int
+	job         *decodedJob
+	// signaled on new jobs
+	jobs chan struct{}
+	// closed once the connection is lost, with the error
+	closed   chan struct{}
+	err      error
+	accepted int
+	rejected int
+}
+
+// Dial connects to the pool at addr, subscribes and authorizes a worker paid to
+// address
+func Dial(addr, address string) (*Worker, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Worker{
+		Address: address,
+		conn:    conn,
+		scanner: bufio.NewScanner(conn),
+		jobs:    make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	// jobs carry whole blocks
+	w.scanner.Buffer(nil, 4*chain.MAX_BLOCK_SIZE)
+
+	var subscription []json.RawMessage
+	if err := w.call("mining.subscribe", &subscription); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	var extraNonce1 string
+	var extraNonce2Size int
+	if len(subscription) != 2 || json.Unmarshal(subscription[0], &extraNonce1) != nil || json.Unmarshal(subscription[1], &extraNonce2Size) != nil {
+		conn.Close()
+		return nil, errors.New("malformed subscription")
+	}
+	if extraNonce2Size != EXTRANONCE2_SIZE {
+		conn.Close()
+		return nil, fmt.Errorf("the pool rolls %d bytes of extra nonce, we only do %d", extraNonce2Size, EXTRANONCE2_SIZE)
+	}
+	if w.extraNonce1, err = hex.DecodeString(extraNonce1); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	var authorized bool
+	if err := w.call("mining.authorize", &authorized, address); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go w.read()
+
+	return w, nil
+}
+
+// Close disconnects from the pool
+func (w *Worker) Close() error {
+	return w.conn.Close()
+}
+
+// Stats returns the number of shares the pool accepted and rejected
+func (w *Worker) Stats() (accepted, rejected int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.accepted, w.rejected
+}
+
+// call sends a request and waits for its response, handling the
+// notifications coming first. Only used before read takes over.
+func (w *Worker) call(method string, result interface{}, params ...interface{}) error {
+	id, err := w.send(method, params...)
+	if err != nil {
+		return err
+	}
+
+	for w.scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(w.scanner.Bytes(), &msg); err != nil {
+			return err
+		}
+		if msg.ID == nil || *msg.ID != id {
+			w.handle(msg)
+			continue
+		}
+		if msg.Error != nil {
+			return fmt.Errorf("%s failed: %v", method, msg.Error)
+		}
+
+		return json.Unmarshal(msg.Result, result)
+	}
+	if err := w.scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("disconnected from the pool")
+}
+
+// send writes a request, returning its ID
+func (w *Worker) send(method string, params ...interface{}) (int, error) {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	if params == nil {
+		params = []interface{}{}
+	}
+	w.lastID++
+	data, err := json.Marshal(map[string]interface{}{"id": w.lastID, "method": method, "params": params})
+	if err != nil {
+		return 0, err
+	}
+	_, err = w.conn.Write(append(data, '\n'))
+
+	return w.lastID, err
+}
+
+// read handles the messages of the pool until the connection is lost
+func (w *Worker) read() {
+	for w.scanner.Scan() {
+		var msg message
+		if err := json.Unmarshal(w.scanner.Bytes(), &msg); err != nil {
+			w.close(err)
+			return
+		}
+		w.handle(msg)
+	}
+
+	err := w.scanner.Err()
+	if err == nil {
+		err = errors.New("disconnected from the pool")
+	}
+	w.close(err)
+}
+
+func (w *Worker) close(err error) {
+	w.mu.Lock()
+	w.err = err
+	w.mu.Unlock()
+	close(w.closed)
+}
+
+// handle processes a notification or the response to a share
+func (w *Worker) handle(msg message) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch msg.Method {
+	case "mining.set_difficulty":
+		if len(msg.Params) > 0 {
+			_ = json.Unmarshal(msg.Params[0], &w.shareBits)
+		}
+	case "mining.notify":
+		var job Job
+		if len(msg.Params) == 0 || json.Unmarshal(msg.Params[0], &job) != nil {
+			log.Println("worker: malformed job")
+			return
+		}
+		decoded, err := decodeJob(job)
+		if err != nil {
+			log.Printf("worker: malformed job %s: %s\n", job.ID, err)
+			return
+		}
+		w.job = decoded
+		select {
+		case w.jobs <- struct{}{}:
+		default:
+			// already signaled
+		}
+	case "":
+		// the response to a share
+		if msg.Error == nil {
+			w.accepted++
+			return
+		}
+		w.rejected++
+		// shares of the previous block are expected to go stale
+		if len(msg.Error) > 0 && msg.Error[0] == float64(ERR_JOB_NOT_FOUND) {
+			return
+		}
+		log.Printf("worker: share rejected: %v\n", msg.Error)
+	}
+}
+
+// current returns the job to mine and the bits its shares need
+func (w *Worker) current() (*decodedJob, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.job, w.shareBits
+}
+
+// Mine submits shares of the jobs of the pool until stop is closed or the
+// connection is lost
+func (w *Worker) Mine(stop <-chan struct{}) error {
+	var job *decodedJob
+	var extraNonce2 uint32
+
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-w.closed:
+			return w.err
+		default:
+		}
+
+		current, shareBits := w.current()
+		if current == nil || shareBits == 0 {
+			// wait for the first job
+			select {
+			case <-w.jobs:
+			case <-stop:
+				return nil
+			case <-w.closed:
+				return w.err
+			}
+			continue
+		}
+		if current != job {
+			job, extraNonce2 = current, 0
+		}
+
+		// each extra nonce gives a new coinbase, so a whole new range of nonces
+		extraNonce2++
+		extraNonce := make([]byte, EXTRANONCE2_SIZE)
+		binary.BigEndian.PutUint32(extraNonce, extraNonce2)
+		if err := w.mineRange(job, shareBits, extraNonce, stop); err != nil {
+			return err
+		}
+	}
+}
+
+// mineRange submits the shares found over the nonces of the block with the
+// extra nonce, until they run out or the job changes
+func (w *Worker) mineRange(job *decodedJob, shareBits int, extraNonce2 []byte, stop <-chan struct{}) error {
+	timestamp := job.Time
+	if timestamp < job.MinTime {
+		timestamp = job.MinTime
+	}
+	extraNonce := append(append([]byte{}, w.extraNonce1...), extraNonce2...)
+	proof := job.block(extraNonce, timestamp, 0).ShareProofOfWork(job.function, shareBits)
+
+	for first := int64(0); first <= pow.MAX_NONCE; {
+		select {
+		case <-stop:
+			return nil
+		case <-w.closed:
+			return nil
+		default:
+		}
+		if current, _ := w.current(); current != job {
+			return nil
+		}
+
+		last := first + NONCE_CHUNK - 1
+		if last > pow.MAX_NONCE {
+			last = pow.MAX_NONCE
+		}
+		nonce, _, err := proof.MineRange(first, last)
+		if err != nil {
+			first = last + 1
+			continue
+		}
+
+		if _, err := w.send("mining.submit", w.Address, job.ID, hex.EncodeToString(extraNonce2), timestamp, nonce); err != nil {
+			return err
+		}
+		first = nonce + 1
+	}
+
+	return nil
+}
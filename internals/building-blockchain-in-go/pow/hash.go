@@ -0,0 +1,180 @@
+package pow
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// A single SHA-256 takes a few hundred nanoseconds and no memory, so it is
+// cheap to compute on thousands of cores at once: GPUs, then ASICs, leave
+// regular computers far behind. Memory-hard functions need some memory for
+// every hash, and memory is slower to scale than cores. Litecoin uses scrypt,
+// Monero and Zcash have used similar functions.
+//
+// The difficulty is encoded the same way whatever the function: the hash
+// has 256 bits, and needs Bits leading zeros. A slower function simply calls
+// for fewer bits, for blocks to take about as long to mine.
+
+const (
+	// HASH_SHA256 is the Hashcash-like function of Bitcoin
+	HASH_SHA256 = "sha256"
+	// HASH_SCRYPT uses the parameters of Litecoin, 128 KiB per hash
+	HASH_SCRYPT = "scrypt"
+	// HASH_ARGON2 is Argon2id, 256 KiB per hash
+	HASH_ARGON2 = "argon2"
+
+	SCRYPT_N = 1024
+	SCRYPT_R = 1
+	SCRYPT_P = 1
+	// in KiB
+	ARGON2_MEMORY = 256
+	ARGON2_TIME   = 1
+
+	// the size of a hash, in bytes
+	HASH_SIZE = 32
+)
+
+// HashFunction hashes the data of a header into 256 bits
+type HashFunction interface {
+	Name() string
+	Sum(data []byte) []byte
+}
+
+type sha256Hash struct{}
+
+func (sha256Hash) Name() string {
+	return HASH_SHA256
+}
+
+func (sha256Hash) Sum(data []byte) []byte {
+	hash := sha256.Sum256(data)
+
+	return hash[:]
+}
+
+// scryptHash hashes the header with itself as the salt, like Litecoin
+type scryptHash struct{}
+
+func (scryptHash) Name() string {
+	return HASH_SCRYPT
+}
+
+func (scryptHash) Sum(data []byte) []byte {
+	// only fails on invalid parameters
+	hash, err := scrypt.Key(data, data, SCRYPT_N, SCRYPT_R, SCRYPT_P, HASH_SIZE)
+	if err != nil {
+		panic(err)
+	}
+
+	return hash
+}
+
+type argon2Hash struct{}
+
+func (argon2Hash) Name() string {
+	return HASH_ARGON2
+}
+
+func (argon2Hash) Sum(data []byte) []byte {
+	return argon2.IDKey(data, data, ARGON2_TIME, ARGON2_MEMORY, 1, HASH_SIZE)
+}
+
+// HashFunctions returns the names of the hash functions
+func HashFunctions() []string {
+	return []string{HASH_SHA256, HASH_SCRYPT, HASH_ARGON2}
+}
+
+// NewHashFunction returns the hash function called name
+func NewHashFunction(name string) (HashFunction, error) {
+	switch name {
+	case HASH_SHA256:
+		return sha256Hash{}, nil
+	case HASH_SCRYPT:
+		return scryptHash{}, nil
+	case HASH_ARGON2:
+		return argon2Hash{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown proof of work hash function %q", name)
+}
+
+// Function is the proof of work of a network: the hash function, and the
+// leading zero bits the hash of a block needs
+type Function struct {
+	Hash HashFunction
+	Bits int
+}
+
+// Default is SHA-256 at TargetBits
+var Default = Function{sha256Hash{}, TargetBits}
+
+// NewFunction returns the proof of work hashing with the function called
+// hash, at the given difficulty
+func NewFunction(hash string, bits int) (Function, error) {
+	hashFunction, err := NewHashFunction(hash)
+	if err != nil {
+		return Function{}, err
+	}
+	if bits < 1 || bits >= 8*HASH_SIZE {
+		return Function{}, fmt.Errorf("the proof of work needs between 1 and %d bits", 8*HASH_SIZE-1)
+	}
+
+	return Function{hashFunction, bits}, nil
+}
+
+// ProofOfWork returns the proof of work of a header
+func (f Function) ProofOfWork(h Header) *ProofOfWork {
+	return &ProofOfWork{h, f, target(f.Bits)}
+}
+
+// ShareProofOfWork works against an easier target of shareBits leading
+// zeros. Mining pools ask their workers for such "shares": they prove how
+// much work each worker does, while the hash still commits to the bits of the
+// function and is a valid block whenever it happens to meet its target too.
+func (f Function) ShareProofOfWork(h Header, shareBits int) *ProofOfWork {
+	return &ProofOfWork{h, f, target(shareBits)}
+}
+
+// MeetsTarget tells whether a hash is below the difficulty target, without
+// recomputing it
+func (f Function) MeetsTarget(hash []byte) bool {
+	return MeetsTargetBits(hash, f.Bits)
+}
+
+// Work is the number of hashes it takes on average to meet the target
+func (f Function) Work() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(f.Bits))
+}
+
+// Benchmark hashes headers with the function on threads goroutines for
+// duration, and returns the number of hashes per second
+func Benchmark(hash HashFunction, duration time.Duration, threads int) float64 {
+	var hashes int64
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	deadline := start.Add(duration)
+	for thread := 0; thread < threads; thread++ {
+		wg.Add(1)
+		go func(thread int) {
+			defer wg.Done()
+
+			// each thread has its own header, like miners of a pool
+			proof := Function{hash, TargetBits}.ProofOfWork(Header{PrevBlockHash: []byte{byte(thread)}})
+			for nonce := int64(0); time.Now().Before(deadline); nonce++ {
+				hash.Sum(proof.prepareData(nonce))
+				atomic.AddInt64(&hashes, 1)
+			}
+		}(thread)
+	}
+	wg.Wait()
+
+	return float64(hashes) / time.Since(start).Seconds()
+}
@@ -0,0 +1,143 @@
+// Package pow implements the Hashcash-like proof of work securing the chain,
+// with SHA-256 or a memory-hard hash function. It only knows about block
+// headers, so it can be used without loading the rest of the chain.
+package pow
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/big"
+
+	"github.com/xav-b/blockchain/encoding"
+)
+
+const (
+	// Mining difficulty
+	// We will need to find a hash value lower than a set target. This target is
+	// computed as a 256bits hash, with the first `24 / 8` bits set to 0.
+	//
+	// So we can increase the difficulty by asking for more leading zeros, i.e.
+	// by increasing the `TargetBits` value by steps of 8. And vice-e-versa:
+	// TargetBits=16 will only need the PoW to figure out a hash with 2 leading
+	// zeros.
+	//
+	// In Bitcoin, "target bits" is the block header storing the difficulty at which
+	// the block was mined. Unlike bitcoin though, this is not dynamically adjusted
+	// to miners capacity
+	// TargetBits = 24
+	TargetBits = 16 // FIXME: it's too easy, only for dev
+	// like Bitcoin's 32 bits nonce, at higher difficulties no nonce of the
+	// range may work and the miner has to change something else in the block.
+	// Nonces are int64, an int would not hold it on 32 bits platforms.
+	MAX_NONCE int64 = math.MaxUint32
+)
+
+// ErrNonceExhausted is returned when no nonce of the range meets the target
+var ErrNonceExhausted = errors.New("no nonce meets the target")
+
+// Header holds the fields of a block committed to by the proof of work
+type Header struct {
+	// a zero version is left out, like before headers committed to it
+	Version       int
+	PrevBlockHash []byte
+	MerkleRoot    []byte
+	Timestamp     int64
+	Nonce         int64
+}
+
+type ProofOfWork struct {
+	header   Header
+	function Function
+
+	// our proof of work consists of finding a hash from block's data +
+	// something, which is lower than the target
+	target *big.Int
+}
+
+func target(bits int) *big.Int {
+	// initialise to 1 and shift it left by `256 - bits` bits
+	target := big.NewInt(1)
+	target.Lsh(target, uint(256-bits))
+
+	return target
+}
+
+// MeetsTargetBits tells whether a hash has at least bits leading zeros
+func MeetsTargetBits(hash []byte, bits int) bool {
+	return new(big.Int).SetBytes(hash).Cmp(target(bits)) == -1
+}
+
+func (pow *ProofOfWork) prepareData(nonce int64) []byte {
+	var version [][]byte
+	if pow.header.Version != 0 {
+		version = append(version, encoding.IntToHex(int64(pow.header.Version)))
+	}
+
+	data := bytes.Join(
+		append(version,
+			// block data
+			pow.header.PrevBlockHash,
+			pow.header.MerkleRoot,
+			encoding.IntToHex(pow.header.Timestamp),
+			// pow properties
+			encoding.IntToHex(int64(pow.function.Bits)),
+			// nonce here is the counter from the Hashcash algo
+			encoding.IntToHex(nonce),
+		),
+		[]byte{},
+	)
+
+	return data
+}
+
+// Mine looks for a nonce, up to MAX_NONCE, giving a hash below the target
+func (pow *ProofOfWork) Mine() (int64, []byte, error) {
+	return pow.MineUpTo(MAX_NONCE)
+}
+
+// MineUpTo looks for a nonce up to maxNonce, included, giving a hash below
+// the target
+func (pow *ProofOfWork) MineUpTo(maxNonce int64) (int64, []byte, error) {
+	return pow.MineRange(0, maxNonce)
+}
+
+// MineRange looks for a nonce between first and last, included, giving a hash
+// below the target
+func (pow *ProofOfWork) MineRange(first, last int64) (int64, []byte, error) {
+	var hashInt big.Int
+
+	for nonce := first; nonce <= last; nonce++ {
+		// create a byte representation of block's data, nonce and POW target
+		data := pow.prepareData(nonce)
+		hash := pow.function.Hash.Sum(data)
+		// convert hash to bigint
+		hashInt.SetBytes(hash)
+
+		// validate POW
+		if hashInt.Cmp(pow.target) == -1 {
+			// valid! return nonce and hash winners
+			return nonce, hash, nil
+		}
+	}
+
+	return 0, nil, ErrNonceExhausted
+}
+
+// Hash recomputes the hash of the block from its nonce
+func (pow *ProofOfWork) Hash() []byte {
+	return pow.function.Hash.Sum(pow.prepareData(pow.header.Nonce))
+}
+
+// Validate takes a newly minted block and check that its nonce and hash pass
+// the PoW test
+func (pow *ProofOfWork) Validate() bool {
+	var hashInt big.Int
+
+	data := pow.prepareData(pow.header.Nonce)
+	hashInt.SetBytes(pow.function.Hash.Sum(data))
+
+	isValid := hashInt.Cmp(pow.target) == -1
+
+	return isValid
+}
@@ -0,0 +1,51 @@
+package pow
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashFunctions(t *testing.T) {
+	header := Header{PrevBlockHash: []byte("prev"), MerkleRoot: []byte("root"), Timestamp: 1650000000}
+
+	var hashes [][]byte
+	for _, name := range HashFunctions() {
+		function, err := NewFunction(name, 4)
+		assert.Nil(t, err)
+
+		header.Nonce, _, err = function.ProofOfWork(header).MineRange(0, MAX_NONCE)
+		assert.Nil(t, err)
+		proof := function.ProofOfWork(header)
+		assert.True(t, proof.Validate(), name)
+		assert.True(t, function.MeetsTarget(proof.Hash()), name)
+		assert.Equal(t, HASH_SIZE, len(proof.Hash()))
+
+		// the bits are part of the hash, whatever the target
+		easier, _ := NewFunction(name, 2)
+		assert.False(t, bytes.Equal(easier.ProofOfWork(header).Hash(), proof.Hash()), name)
+
+		hashes = append(hashes, function.ProofOfWork(Header{}).Hash())
+	}
+	assert.False(t, bytes.Equal(hashes[0], hashes[1]))
+	assert.False(t, bytes.Equal(hashes[1], hashes[2]))
+
+	_, err := NewFunction("md5", TargetBits)
+	assert.NotNil(t, err)
+	_, err = NewFunction(HASH_SCRYPT, 0)
+	assert.NotNil(t, err)
+}
+
+func BenchmarkHashFunctions(b *testing.B) {
+	for _, name := range HashFunctions() {
+		hash, _ := NewHashFunction(name)
+		proof := Default.ProofOfWork(Header{})
+
+		b.Run(name, func(b *testing.B) {
+			for nonce := int64(0); nonce < int64(b.N); nonce++ {
+				hash.Sum(proof.prepareData(nonce))
+			}
+		})
+	}
+}
@@ -0,0 +1,815 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// The node can run as a long-lived daemon answering JSON-RPC calls over HTTP,
+// modelled on bitcoind: https://developer.bitcoin.org/reference/rpc/
+//
+// A request looks like:
+//     {"jsonrpc": "1.0", "id": 1, "method": "getblockhash", "params": [0]}
+
+const (
+	RPC_ADDR = "127.0.0.1:8332"
+	// Like bitcoind, when no user/password is configured we generate random
+	// credentials and write them to a cookie file only readable by us. Its
+	// presence also tells the CLI that a daemon is running.
+	COOKIE_FILE = ".cookie"
+	COOKIE_USER = "__cookie__"
+)
+
+// error codes reused from bitcoind
+const (
+	RPC_MISC_ERROR                = -1
+	RPC_INVALID_ADDRESS_OR_KEY    = -5
+	RPC_WALLET_ERROR              = -4
+	RPC_WALLET_INSUFFICIENT_FUNDS = -6
+	RPC_INVALID_PARAMETER         = -8
+	RPC_DESERIALIZATION_ERROR     = -22
+	RPC_VERIFY_REJECTED           = -26
+	RPC_METHOD_NOT_FOUND          = -32601
+	RPC_INVALID_PARAMS            = -32602
+	RPC_PARSE_ERROR               = -32700
+)
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      interface{}       `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	Result interface{} `json:"result"`
+	Error  *RPCError   `json:"error"`
+	ID     interface{} `json:"id"`
+}
+
+// RPCError is the error object of a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+type rpcHandler func(params []json.RawMessage) (interface{}, error)
+
+// RPCServer exposes a Blockchain over JSON-RPC
+type RPCServer struct {
+	Blockchain *chain.Blockchain
+	// where the wallet file is, and the cookie is written
+	dataDir  string
+	user     string
+	password string
+	methods  map[string]rpcHandler
+	// commands writing to the chain or to the wallet file are run one at a
+	// time
+	mu sync.Mutex
+	// transactions sent without a reward address, waiting for an external
+	// miner to include them
+	queue []*chain.Transaction
+}
+
+// NewRPCServer creates a server for the given chain, using the wallet file of
+// dataDir. An empty user makes it fall back to cookie authentication.
+func NewRPCServer(bc *chain.Blockchain, dataDir, user, password string) *RPCServer {
+	s := &RPCServer{Blockchain: bc, dataDir: dataDir, user: user, password: password}
+
+	s.methods = map[string]rpcHandler{
+		"getblockchaininfo": s.getBlockchainInfo,
+		"getblock":          s.getBlock,
+		"getblockhash":      s.getBlockHash,
+		"getrawtransaction": s.getRawTransaction,
+		"getbalance":        s.getBalance,
+		"getbalances":       s.getBalances,
+		"listunspent":       s.listUnspent,
+		"sendtoaddress":     s.sendToAddress,
+		"getnewaddress":     s.getNewAddress,
+		"gettxoutsetinfo":   s.getTxOutSetInfo,
+		"getsupply":         s.getSupply,
+		"getdeploymentinfo": s.getDeploymentInfo,
+		"dumptxoutset":      s.dumpTxOutSet,
+		"verifychain":       s.verifyChain,
+
+		"createrawtransaction": s.createRawTransaction,
+		"decoderawtransaction": s.decodeRawTransaction,
+		"sendrawtransaction":   s.sendRawTransaction,
+
+		"getblocktemplate": s.getBlockTemplate,
+		"submitblock":      s.submitBlock,
+	}
+
+	return s
+}
+
+// ListenAndServe serves RPC requests on addr until the process is interrupted
+func (s *RPCServer) ListenAndServe(addr string) error {
+	if s.user == "" {
+		if err := s.writeCookie(); err != nil {
+			return err
+		}
+		defer os.Remove(filepath.Join(s.dataDir, COOKIE_FILE))
+	}
+
+	srv := &http.Server{Addr: addr, Handler: s}
+
+	// shutdown cleanly so the cookie is removed and the db released
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		log.Println("shutting down RPC server")
+		_ = srv.Shutdown(context.Background())
+	}()
+
+	log.Printf("RPC server listening on %s\n", addr)
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+func (s *RPCServer) writeCookie() error {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+
+	s.user = COOKIE_USER
+	s.password = hex.EncodeToString(secret)
+	cookie := fmt.Sprintf("%s:%s", s.user, s.password)
+
+	return ioutil.WriteFile(filepath.Join(s.dataDir, COOKIE_FILE), []byte(cookie), 0600)
+}
+
+func (s *RPCServer) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(s.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+
+	return userOK && passwordOK
+}
+
+// ServeHTTP implements http.Handler
+func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req rpcRequest
+	var resp rpcResponse
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		resp.Error = &RPCError{RPC_PARSE_ERROR, "Parse error"}
+	} else {
+		resp.ID = req.ID
+		result, err := s.dispatch(req)
+		if err != nil {
+			rpcErr, ok := err.(*RPCError)
+			if !ok {
+				rpcErr = &RPCError{RPC_MISC_ERROR, err.Error()}
+			}
+			resp.Error = rpcErr
+		} else {
+			resp.Result = result
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *RPCServer) dispatch(req rpcRequest) (result interface{}, err error) {
+	handler, ok := s.methods[req.Method]
+	if !ok {
+		return nil, &RPCError{RPC_METHOD_NOT_FOUND, "Method not found"}
+	}
+
+	// the chain code returns errors, but a bug in a handler should still not
+	// take the whole daemon down
+	defer func() {
+		if r := recover(); r != nil {
+			err = &RPCError{RPC_MISC_ERROR, fmt.Sprint(r)}
+		}
+	}()
+
+	log.Printf("rpc: %s\n", req.Method)
+	return handler(req.Params)
+}
+
+// parseParams decodes positional params into dest. Only the first `required`
+// ones are mandatory, the others keep their value when missing.
+func parseParams(params []json.RawMessage, required int, dest ...interface{}) error {
+	if len(params) < required || len(params) > len(dest) {
+		return &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("expected between %d and %d params", required, len(dest))}
+	}
+
+	for i, param := range params {
+		if err := json.Unmarshal(param, dest[i]); err != nil {
+			return &RPCError{RPC_INVALID_PARAMS, fmt.Sprintf("param %d: %s", i, err)}
+		}
+	}
+
+	return nil
+}
+
+func decodeHash(h string) ([]byte, error) {
+	hash, err := hex.DecodeString(h)
+	if err != nil || len(hash) != 32 {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, "hash must be of length 64 (hex)"}
+	}
+
+	return hash, nil
+}
+
+func checkAddress(address string) error {
+	if !wallet.ValidateAddress(address) {
+		return &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "Invalid address"}
+	}
+
+	return nil
+}
+
+func (s *RPCServer) getBlockchainInfo(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	bestHeight, err := s.Blockchain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+
+	chainParams := s.Blockchain.Params()
+	info := BlockchainInfo{
+		Blocks:        bestHeight,
+		BestBlockHash: hex.EncodeToString(s.Blockchain.Tip()),
+		Pruned:        s.Blockchain.PruneDepth() > 0,
+		Consensus:     chainParams.Consensus,
+		Signers:       chainParams.Signers,
+	}
+	switch chainParams.Consensus {
+	case chain.CONSENSUS_POW:
+		info.Difficulty = chainParams.PowBits
+		info.PowHash = chainParams.PowHash
+	case chain.CONSENSUS_POS:
+		info.StakeLock = chainParams.StakeLock
+	}
+	chainWork, err := s.Blockchain.ChainWork()
+	if err != nil {
+		return nil, err
+	}
+	info.ChainWork = fmt.Sprintf("%064x", chainWork)
+	if info.MedianTime, err = s.Blockchain.MedianTimePast(); err != nil {
+		return nil, err
+	}
+	if info.Pruned {
+		if info.PruneHeight, err = s.Blockchain.PruneHeight(); err != nil {
+			return nil, err
+		}
+	}
+
+	return info, nil
+}
+
+// getblock "blockhash" ( verbosity )
+// verbosity 0 returns the serialized block, 1 the block with its txids and 2
+// the block with its decoded transactions
+func (s *RPCServer) getBlock(params []json.RawMessage) (interface{}, error) {
+	var blockHash string
+	verbosity := 1
+	if err := parseParams(params, 1, &blockHash, &verbosity); err != nil {
+		return nil, err
+	}
+
+	hash, err := decodeHash(blockHash)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := s.Blockchain.GetBlock(hash)
+	if err == chain.ErrBlockPruned && verbosity == 1 {
+		// the header is all we need
+		return NewBlockJSON(&block, false), nil
+	} else if err == chain.ErrBlockPruned {
+		return nil, &RPCError{RPC_MISC_ERROR, "Block not available (pruned data)"}
+	} else if err != nil {
+		return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, err.Error()}
+	}
+
+	switch verbosity {
+	case 0:
+		return hex.EncodeToString(block.Serialize()), nil
+	case 1:
+		return NewBlockJSON(&block, false), nil
+	case 2:
+		return NewBlockJSON(&block, true), nil
+	default:
+		return nil, &RPCError{RPC_INVALID_PARAMETER, "verbosity must be 0, 1 or 2"}
+	}
+}
+
+// getblockhash height
+func (s *RPCServer) getBlockHash(params []json.RawMessage) (interface{}, error) {
+	var height int
+	if err := parseParams(params, 1, &height); err != nil {
+		return nil, err
+	}
+
+	hash, err := s.Blockchain.GetBlockHash(height)
+	if err != nil {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, err.Error()}
+	}
+
+	return hex.EncodeToString(hash), nil
+}
+
+// getrawtransaction "txid" ( verbose )
+func (s *RPCServer) getRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var txid string
+	verbose := false
+	if err := parseParams(params, 1, &txid, &verbose); err != nil {
+		return nil, err
+	}
+
+	hash, err := decodeHash(txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.Blockchain.FindTransaction(hash)
+	if err != nil {
+		return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, err.Error()}
+	}
+
+	if verbose {
+		return NewTransactionJSON(&tx), nil
+	}
+
+	return hex.EncodeToString(tx.Serialize()), nil
+}
+
+// walletAddresses returns the given address, or all the addresses of the
+// wallet file when empty
+func (s *RPCServer) walletAddresses(address string) ([]string, error) {
+	if address != "" {
+		return []string{address}, checkAddress(address)
+	}
+
+	wallets, err := wallet.NewWallets(walletFile(s.dataDir))
+	if err != nil {
+		return nil, &RPCError{RPC_WALLET_ERROR, err.Error()}
+	}
+
+	return wallets.GetAddresses(), nil
+}
+
+// getbalance ( "address" ), the spendable coins only
+func (s *RPCServer) getBalance(params []json.RawMessage) (interface{}, error) {
+	balances, err := s.balances(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return balances.Spendable, nil
+}
+
+// getbalances ( "address" )
+func (s *RPCServer) getBalances(params []json.RawMessage) (interface{}, error) {
+	return s.balances(params)
+}
+
+func (s *RPCServer) balances(params []json.RawMessage) (*BalancesJSON, error) {
+	var address string
+	if err := parseParams(params, 0, &address); err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.walletAddresses(address)
+	if err != nil {
+		return nil, err
+	}
+
+	UTXOSet := chain.UTXOSet{Blockchain: s.Blockchain}
+	balances := &BalancesJSON{}
+	for _, address := range addresses {
+		pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+		if err != nil {
+			return nil, err
+		}
+		spendable, immature, err := UTXOSet.Balance(pubKeyHash)
+		if err != nil {
+			return nil, err
+		}
+		balances.Spendable += spendable
+		balances.Immature += immature
+
+		spendable, immature, err = UTXOSet.Balance(chain.StakePubKeyHash(pubKeyHash))
+		if err != nil {
+			return nil, err
+		}
+		balances.Staked += spendable + immature
+	}
+
+	return balances, nil
+}
+
+// listunspent ( "address" )
+func (s *RPCServer) listUnspent(params []json.RawMessage) (interface{}, error) {
+	var address string
+	if err := parseParams(params, 0, &address); err != nil {
+		return nil, err
+	}
+
+	addresses, err := s.walletAddresses(address)
+	if err != nil {
+		return nil, err
+	}
+
+	UTXOSet := chain.UTXOSet{Blockchain: s.Blockchain}
+	unspent := []UnspentJSON{}
+	for _, address := range addresses {
+		pubKeyHash, err := wallet.AddressToPubKeyHash(address)
+		if err != nil {
+			return nil, err
+		}
+		UTXOs, err := UTXOSet.FindUnspent(pubKeyHash)
+		if err != nil {
+			return nil, err
+		}
+		for _, utxo := range UTXOs {
+			unspent = append(unspent, UnspentJSON{
+				Txid:    hex.EncodeToString(utxo.Txid),
+				Vout:    utxo.Vout,
+				Address: address,
+				Amount:  utxo.Output.Value,
+			})
+		}
+	}
+
+	return unspent, nil
+}
+
+// sendtoaddress "address" amount "fromaddress"
+// Unlike bitcoind, coins are taken from a single address of the wallet, which
+// also receives the reward of the block mined for the transaction.
+func (s *RPCServer) sendToAddress(params []json.RawMessage) (interface{}, error) {
+	var to, from string
+	var amount int
+	if err := parseParams(params, 3, &to, &amount, &from); err != nil {
+		return nil, err
+	}
+
+	if err := checkAddress(to); err != nil {
+		return nil, err
+	}
+	if err := checkAddress(from); err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, "Amount must be positive"}
+	}
+
+	wallets, err := wallet.NewWallets(walletFile(s.dataDir))
+	if err != nil {
+		return nil, &RPCError{RPC_WALLET_ERROR, err.Error()}
+	}
+	sender, err := wallets.GetWallet(from)
+	if err != nil {
+		return nil, &RPCError{RPC_WALLET_ERROR, err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	UTXOSet := chain.UTXOSet{Blockchain: s.Blockchain}
+	cbTx, err := s.Blockchain.NewCoinbase(from)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := chain.NewUTXOTransaction(sender, to, amount, &UTXOSet)
+	if errors.Is(err, chain.ErrNotEnoughFunds) {
+		return nil, &RPCError{RPC_WALLET_INSUFFICIENT_FUNDS, "Insufficient funds"}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.Blockchain.AddBlock([]*chain.Transaction{cbTx, tx}); err != nil {
+		return nil, err
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// getnewaddress
+func (s *RPCServer) getNewAddress(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wallets, err := wallet.NewWallets(walletFile(s.dataDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, &RPCError{RPC_WALLET_ERROR, err.Error()}
+	}
+	address, err := wallets.CreateWallet()
+	if err != nil {
+		return nil, &RPCError{RPC_WALLET_ERROR, err.Error()}
+	}
+	if err := wallets.SaveToFile(); err != nil {
+		return nil, &RPCError{RPC_WALLET_ERROR, err.Error()}
+	}
+
+	return address, nil
+}
+
+// createrawtransaction [{"txid": "id", "vout": n}, ...] [{"address": amount}, ...]
+func (s *RPCServer) createRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var inputs []struct {
+		Txid string `json:"txid"`
+		Vout int    `json:"vout"`
+	}
+	var outputs []map[string]int
+	if err := parseParams(params, 2, &inputs, &outputs); err != nil {
+		return nil, err
+	}
+
+	var vin []chain.TXInput
+	for _, input := range inputs {
+		txid, err := decodeHash(input.Txid)
+		if err != nil {
+			return nil, err
+		}
+		vin = append(vin, chain.TXInput{Txid: txid, Vout: input.Vout})
+	}
+
+	var vout []chain.TXOutput
+	for _, output := range outputs {
+		if len(output) != 1 {
+			return nil, &RPCError{RPC_INVALID_PARAMETER, "each output is a single {\"address\": amount} pair"}
+		}
+		for address, amount := range output {
+			output, err := chain.NewTXOutput(amount, address)
+			if err != nil {
+				return nil, &RPCError{RPC_INVALID_ADDRESS_OR_KEY, "Invalid address"}
+			}
+			vout = append(vout, *output)
+		}
+	}
+
+	return chain.EncodeRawTransaction(chain.NewRawTransaction(vin, vout)), nil
+}
+
+// decoderawtransaction "hexstring"
+func (s *RPCServer) decodeRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var rawTx string
+	if err := parseParams(params, 1, &rawTx); err != nil {
+		return nil, err
+	}
+
+	tx, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		return nil, &RPCError{RPC_DESERIALIZATION_ERROR, err.Error()}
+	}
+
+	return NewTransactionJSON(tx), nil
+}
+
+// sendrawtransaction "hexstring" ( "rewardaddress" )
+// With no mempool the transaction is mined right away, the block reward going
+// to rewardaddress. Without one, it is queued for the block templates of
+// external miners.
+func (s *RPCServer) sendRawTransaction(params []json.RawMessage) (interface{}, error) {
+	var rawTx, rewardAddress string
+	if err := parseParams(params, 1, &rawTx, &rewardAddress); err != nil {
+		return nil, err
+	}
+
+	if rewardAddress != "" {
+		if err := checkAddress(rewardAddress); err != nil {
+			return nil, err
+		}
+	}
+	tx, err := chain.DecodeRawTransaction(rawTx)
+	if err != nil {
+		return nil, &RPCError{RPC_DESERIALIZATION_ERROR, err.Error()}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	UTXOSet := chain.UTXOSet{Blockchain: s.Blockchain}
+	if rewardAddress == "" {
+		// it may spend the outputs of queued transactions
+		if err := s.Blockchain.CheckPendingTransaction(s.queue, tx); err != nil {
+			return nil, &RPCError{RPC_VERIFY_REJECTED, err.Error()}
+		}
+		s.queue = append(s.queue, tx)
+
+		return hex.EncodeToString(tx.ID), nil
+	}
+
+	if _, err := UTXOSet.SendRawTransaction(tx, rewardAddress); err != nil {
+		return nil, &RPCError{RPC_VERIFY_REJECTED, err.Error()}
+	}
+
+	return hex.EncodeToString(tx.ID), nil
+}
+
+// getblocktemplate "address"
+// The coinbase of the template pays address, the miner only has to roll its
+// extra nonce. Queued transactions that became invalid, because a block
+// included them or spent their inputs, are dropped.
+func (s *RPCServer) getBlockTemplate(params []json.RawMessage) (interface{}, error) {
+	var address string
+	if err := parseParams(params, 1, &address); err != nil {
+		return nil, err
+	}
+	if err := checkAddress(address); err != nil {
+		return nil, err
+	}
+	if s.Blockchain.Params().Consensus != chain.CONSENSUS_POW {
+		return nil, &RPCError{RPC_MISC_ERROR, "Blocks of this chain are signed, not mined"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	template, err := s.Blockchain.NewBlockTemplate(s.queue, address)
+	if err != nil {
+		return nil, err
+	}
+
+	var queue []*chain.Transaction
+	for _, tx := range s.queue {
+		err, rejected := template.Rejected[hex.EncodeToString(tx.ID)]
+		var limitErr *chain.BlockLimitError
+		if !rejected || errors.As(err, &limitErr) {
+			// waiting for room in a next block
+			queue = append(queue, tx)
+		}
+	}
+	s.queue = queue
+
+	return NewBlockTemplateJSON(template, s.Blockchain.Params()), nil
+}
+
+// Pending returns the transactions queued for external miners
+func (s *RPCServer) Pending() []*chain.Transaction {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*chain.Transaction{}, s.queue...)
+}
+
+// submitblock "hexdata"
+// The block is checked like the ones we mine ourselves, and becomes the new
+// tip.
+func (s *RPCServer) submitBlock(params []json.RawMessage) (interface{}, error) {
+	var rawBlock string
+	if err := parseParams(params, 1, &rawBlock); err != nil {
+		return nil, err
+	}
+
+	data, err := hex.DecodeString(rawBlock)
+	if err != nil {
+		return nil, &RPCError{RPC_DESERIALIZATION_ERROR, "Block decode failed"}
+	}
+	block, err := chain.DeserializeBlock(data)
+	if err != nil {
+		return nil, &RPCError{RPC_DESERIALIZATION_ERROR, "Block decode failed"}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.Blockchain.ConnectBlock(block); err != nil {
+		return nil, &RPCError{RPC_VERIFY_REJECTED, err.Error()}
+	}
+	log.Printf("accepted block %d %x\n", block.Height, block.Hash)
+
+	return nil, nil
+}
+
+// getsupply, bitcoind has no equivalent
+func (s *RPCServer) getSupply(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	supply, err := s.Blockchain.Supply()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSupplyJSON(supply), nil
+}
+
+// getdeploymentinfo, of the tip only
+func (s *RPCServer) getDeploymentInfo(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	tip := s.Blockchain.Tip()
+	height, err := s.Blockchain.GetBestHeight()
+	if err != nil {
+		return nil, err
+	}
+	infos, err := s.Blockchain.Deployments()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDeploymentInfoJSON(tip, height, infos, s.Blockchain.Params().DeploymentWindow), nil
+}
+
+func (s *RPCServer) getTxOutSetInfo(params []json.RawMessage) (interface{}, error) {
+	if err := parseParams(params, 0); err != nil {
+		return nil, err
+	}
+
+	info, err := chain.UTXOSet{Blockchain: s.Blockchain}.Info()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTxOutSetInfoJSON(info), nil
+}
+
+// dumptxoutset "path"
+// The path is relative to the working directory of the daemon
+func (s *RPCServer) dumpTxOutSet(params []json.RawMessage) (interface{}, error) {
+	var path string
+	if err := parseParams(params, 1, &path); err != nil {
+		return nil, err
+	}
+
+	info, err := chain.DumpTxOutSetFile(s.Blockchain, path)
+	if err != nil {
+		return nil, &RPCError{RPC_INVALID_PARAMETER, err.Error()}
+	}
+
+	return DumpTxOutSetJSON{
+		CoinsWritten: info.TxOuts,
+		BaseHash:     hex.EncodeToString(info.BestBlock),
+		BaseHeight:   info.Height,
+		Path:         path,
+		TxOutSetHash: hex.EncodeToString(info.Hash),
+	}, nil
+}
+
+// verifychain ( checklevel nblocks )
+// Unlike bitcoind, which returns false, the first failure is returned as an
+// error
+func (s *RPCServer) verifyChain(params []json.RawMessage) (interface{}, error) {
+	level, depth := chain.DEFAULT_CHECK_LEVEL, chain.DEFAULT_CHECK_BLOCKS
+	if err := parseParams(params, 0, &level, &depth); err != nil {
+		return nil, err
+	}
+
+	// the UTXO set must not change while it is compared to the blocks
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.Blockchain.VerifyChain(level, depth); err != nil {
+		return nil, &RPCError{RPC_MISC_ERROR, err.Error()}
+	}
+
+	return true, nil
+}
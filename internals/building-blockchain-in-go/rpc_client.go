@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNoDaemon is returned when no running daemon could be reached
+var ErrNoDaemon = errors.New("no daemon running")
+
+// RPCClient calls the methods of a running `bc serve` daemon
+type RPCClient struct {
+	URL      string
+	user     string
+	password string
+	client   *http.Client
+	nextID   int
+}
+
+// NewRPCClient creates a client for the daemon listening on addr
+func NewRPCClient(addr, user, password string) *RPCClient {
+	return &RPCClient{
+		URL:      fmt.Sprintf("http://%s/", addr),
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// DialDaemon looks for a running daemon, using credentials from the
+// environment (BC_RPCADDR, BC_RPCUSER, BC_RPCPASSWORD) or else from the
+// cookie file the daemon wrote in dataDir.
+func DialDaemon(dataDir string) (*RPCClient, error) {
+	addr := os.Getenv("BC_RPCADDR")
+	if addr == "" {
+		addr = RPC_ADDR
+	}
+
+	user, password := os.Getenv("BC_RPCUSER"), os.Getenv("BC_RPCPASSWORD")
+	if user == "" {
+		cookieFile := filepath.Join(dataDir, COOKIE_FILE)
+		cookie, err := ioutil.ReadFile(cookieFile)
+		if err != nil {
+			return nil, ErrNoDaemon
+		}
+
+		credentials := strings.SplitN(strings.TrimSpace(string(cookie)), ":", 2)
+		if len(credentials) != 2 {
+			return nil, fmt.Errorf("malformed cookie file %s", cookieFile)
+		}
+		user, password = credentials[0], credentials[1]
+	}
+
+	client := NewRPCClient(addr, user, password)
+
+	// a stale cookie is left behind when the daemon crashes, so make sure
+	// someone actually answers
+	var info BlockchainInfo
+	if err := client.Call("getblockchaininfo", &info); err != nil {
+		if _, isRPCError := err.(*RPCError); !isRPCError {
+			return nil, ErrNoDaemon
+		}
+	}
+
+	return client, nil
+}
+
+// Call invokes method with the given positional params and decodes its result
+// into result, unless nil
+func (c *RPCClient) Call(method string, result interface{}, params ...interface{}) error {
+	c.nextID++
+	if params == nil {
+		params = []interface{}{}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "1.0",
+		"id":      c.nextID,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.user, c.password)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("RPC authentication failed, check the credentials")
+	}
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return err
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
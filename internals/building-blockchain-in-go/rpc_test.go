@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xav-b/blockchain/chain"
+	"github.com/xav-b/blockchain/storage"
+	"github.com/xav-b/blockchain/wallet"
+)
+
+// newTestNode starts an RPC server on a new chain whose genesis reward goes
+// to the returned address, of the wallet file of the data directory
+func newTestNode(t *testing.T) (*chain.Blockchain, *httptest.Server, string) {
+	dataDir := t.TempDir()
+	wallets, err := wallet.NewWallets(walletFile(dataDir))
+	assert.True(t, os.IsNotExist(err))
+	address, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+	assert.Nil(t, wallets.SaveToFile())
+
+	bc, err := chain.NewBlockchain(storage.NewMemory(), address, chain.DefaultParams)
+	assert.Nil(t, err)
+	assert.Nil(t, chain.UTXOSet{Blockchain: bc}.Reindex())
+	server := httptest.NewServer(NewRPCServer(bc, dataDir, "user", "password"))
+	t.Cleanup(func() {
+		server.Close()
+		bc.Close()
+	})
+
+	return bc, server, address
+}
+
+func newTestClient(server *httptest.Server, password string) *RPCClient {
+	return NewRPCClient(strings.TrimPrefix(server.URL, "http://"), "user", password)
+}
+
+func TestRPCAuthentication(t *testing.T) {
+	_, server, _ := newTestNode(t)
+
+	var info BlockchainInfo
+	err := newTestClient(server, "wrong").Call("getblockchaininfo", &info)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "authentication failed")
+
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(`{"id": 1, "method": "getblockchaininfo", "params": []}`))
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "No credentials")
+}
+
+func TestRPCUnknownMethod(t *testing.T) {
+	_, server, _ := newTestNode(t)
+
+	err := newTestClient(server, "password").Call("getnothing", nil)
+	rpcErr, ok := err.(*RPCError)
+	assert.True(t, ok, "Answered by the server")
+	assert.Equal(t, RPC_METHOD_NOT_FOUND, rpcErr.Code)
+}
+
+func TestRPCRead(t *testing.T) {
+	bc, server, _ := newTestNode(t)
+	client := newTestClient(server, "password")
+
+	var info BlockchainInfo
+	assert.Nil(t, client.Call("getblockchaininfo", &info))
+	assert.Equal(t, 0, info.Blocks)
+	assert.Equal(t, hex.EncodeToString(bc.Tip()), info.BestBlockHash)
+	assert.Equal(t, chain.CONSENSUS_POW, info.Consensus)
+
+	var hash string
+	assert.Nil(t, client.Call("getblockhash", &hash, 0))
+	assert.Equal(t, info.BestBlockHash, hash)
+
+	err := client.Call("getblockhash", &hash, 1)
+	assert.NotNil(t, err, "Out of range")
+	err = client.Call("getblockhash", &hash, "zero")
+	rpcErr, ok := err.(*RPCError)
+	assert.True(t, ok)
+	assert.Equal(t, RPC_INVALID_PARAMS, rpcErr.Code)
+}
+
+func TestRPCWrite(t *testing.T) {
+	bc, server, address := newTestNode(t)
+	client := newTestClient(server, "password")
+
+	var to string
+	assert.Nil(t, client.Call("getnewaddress", &to))
+	assert.True(t, wallet.ValidateAddress(to))
+
+	var txid string
+	assert.Nil(t, client.Call("sendtoaddress", &txid, to, 3, address))
+	bestHeight, err := bc.GetBestHeight()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, bestHeight, "Mined right away")
+
+	var balance int
+	assert.Nil(t, client.Call("getbalance", &balance, to))
+	assert.Equal(t, 3, balance)
+	assert.Nil(t, client.Call("getbalance", &balance, address))
+	assert.Equal(t, 2*chain.INITIAL_SUBSIDY-3, balance, "Change and block reward")
+
+	err = client.Call("sendtoaddress", &txid, to, 1000, address)
+	rpcErr, ok := err.(*RPCError)
+	assert.True(t, ok)
+	assert.Equal(t, RPC_WALLET_INSUFFICIENT_FUNDS, rpcErr.Code)
+}
@@ -0,0 +1,301 @@
+package schnorr
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// MuSig2 lets n signers produce a single signature for the aggregate of
+// their public keys, which looks like any other: nobody can tell it from a
+// signature by a single key.
+//
+//   1. key aggregation: Q is the sum of the keys, each weighted by a hash of
+//      all of them, so that a signer cannot pick a key cancelling the others
+//   2. nonces: each signer draws two secret nonces k1 and k2, and shares
+//      their points R1 = k1*G and R2 = k2*G. They can be exchanged before the
+//      message is even known.
+//   3. partial signatures: with all the nonces, each signer computes the
+//      nonce point R = sum(R1) + b*sum(R2), b hashing the nonces, the key and
+//      the message, and signs its share s_i = k1 + b*k2 + e*a_i*d_i
+//   4. aggregation: anyone sums the partial signatures into (R, s)
+//
+// A secret nonce must never be used twice: two signatures with the same
+// nonce give the private key away.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0327.mediawiki
+
+const (
+	// PUBNONCE_SIZE is the size of a public nonce, two compressed points
+	PUBNONCE_SIZE = 2 * 33
+	// SECNONCE_SIZE is the size of a secret nonce, k1 and k2
+	SECNONCE_SIZE = 2 * 32
+	// PARTIAL_SIGNATURE_SIZE is the size of a partial signature, s_i
+	PARTIAL_SIGNATURE_SIZE = 32
+)
+
+func (p point) neg() point {
+	if p.infinity() {
+		return p
+	}
+
+	return point{p.x, new(big.Int).Sub(curve.Params().P, p.y)}
+}
+
+func (p point) compressed() []byte {
+	return elliptic.MarshalCompressed(curve, p.x, p.y)
+}
+
+func decompress(data []byte) (point, error) {
+	x, y := elliptic.UnmarshalCompressed(curve, data)
+	if x == nil {
+		return point{}, errors.New("invalid point")
+	}
+
+	return point{x, y}, nil
+}
+
+// keyAggContext is the outcome of the key aggregation
+type keyAggContext struct {
+	// the aggregate key, with the y it actually has
+	q      point
+	points []point
+	coefs  []*big.Int
+}
+
+func aggregateKeys(pubKeys [][]byte) (keyAggContext, error) {
+	var ctx keyAggContext
+	if len(pubKeys) == 0 {
+		return ctx, errors.New("no key to aggregate")
+	}
+
+	L := taggedHash("KeyAgg list", pubKeys...)
+	ctx.q = point{new(big.Int), new(big.Int)}
+	seen := make(map[string]bool)
+	for _, pubKey := range pubKeys {
+		if seen[string(pubKey)] {
+			return ctx, errors.New("keys must be distinct")
+		}
+		seen[string(pubKey)] = true
+
+		p, err := liftX(pubKey)
+		if err != nil {
+			return ctx, err
+		}
+		a := hashToScalar("KeyAgg coefficient", L, pubKey)
+
+		ctx.points = append(ctx.points, p)
+		ctx.coefs = append(ctx.coefs, a)
+		ctx.q = ctx.q.add(p.mult(a))
+	}
+	if ctx.q.infinity() {
+		return ctx, errors.New("keys cancel each other out")
+	}
+
+	return ctx, nil
+}
+
+// AggregateKeys returns the x-only aggregate of the x-only public keys, in
+// the order given: the signatures of its signers verify against it
+func AggregateKeys(pubKeys [][]byte) ([]byte, error) {
+	ctx, err := aggregateKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.q.bytes(), nil
+}
+
+// NewNonce draws the secret nonce of a signer for a signing session, and
+// returns it along with the public nonce to share with the other signers
+func NewNonce() ([]byte, []byte, error) {
+	var secNonce, pubNonce []byte
+
+	for i := 0; i < 2; i++ {
+		k, err := rand.Int(rand.Reader, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+		if err != nil {
+			return nil, nil, err
+		}
+		k.Add(k, big.NewInt(1))
+
+		secNonce = append(secNonce, scalarBytes(k)...)
+		pubNonce = append(pubNonce, baseMult(k).compressed()...)
+	}
+
+	return secNonce, pubNonce, nil
+}
+
+// publicNonce returns the public nonce of a secret nonce
+func publicNonce(secNonce []byte) []byte {
+	k1 := new(big.Int).SetBytes(secNonce[:32])
+	k2 := new(big.Int).SetBytes(secNonce[32:])
+
+	return append(baseMult(k1).compressed(), baseMult(k2).compressed()...)
+}
+
+// session holds what every signer computes the same out of the keys, the
+// nonces and the message
+type session struct {
+	keyAggContext
+	// the coefficient of the second nonces, and the final nonce point
+	b *big.Int
+	r point
+	e *big.Int
+}
+
+func newSession(pubKeys, pubNonces [][]byte, msg []byte) (*session, error) {
+	if len(pubNonces) != len(pubKeys) {
+		return nil, fmt.Errorf("%d nonces for %d keys", len(pubNonces), len(pubKeys))
+	}
+	ctx, err := aggregateKeys(pubKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	r1 := point{new(big.Int), new(big.Int)}
+	r2 := point{new(big.Int), new(big.Int)}
+	for i, pubNonce := range pubNonces {
+		if len(pubNonce) != PUBNONCE_SIZE {
+			return nil, fmt.Errorf("invalid nonce of signer %d", i)
+		}
+		p1, err := decompress(pubNonce[:33])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nonce of signer %d", i)
+		}
+		p2, err := decompress(pubNonce[33:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid nonce of signer %d", i)
+		}
+		r1, r2 = r1.add(p1), r2.add(p2)
+	}
+	if r1.infinity() || r2.infinity() {
+		return nil, errors.New("nonces cancel each other out")
+	}
+
+	s := &session{keyAggContext: ctx}
+	s.b = hashToScalar("MuSig/noncecoef", r1.compressed(), r2.compressed(), ctx.q.bytes(), msg)
+	s.r = r1.add(r2.mult(s.b))
+	if s.r.infinity() {
+		s.r = baseMult(big.NewInt(1))
+	}
+	s.e = challenge(s.r.bytes(), ctx.q.bytes(), msg)
+
+	return s, nil
+}
+
+// signer returns the index of a key among those of the session
+func (s *session) signer(pubKey []byte) (int, error) {
+	for i, p := range s.points {
+		if bytes.Equal(p.bytes(), pubKey) {
+			return i, nil
+		}
+	}
+
+	return 0, errors.New("not a signer of the session")
+}
+
+// PartialSign computes the share of the signature of the message of the
+// signer holding priv, with its secret nonce. pubKeys and pubNonces are those
+// of all the signers, in the same order, the signer's included.
+func PartialSign(priv *ecdsa.PrivateKey, secNonce []byte, pubKeys, pubNonces [][]byte, msg []byte) ([]byte, error) {
+	n := curve.Params().N
+	if len(secNonce) != SECNONCE_SIZE {
+		return nil, errors.New("invalid secret nonce")
+	}
+	s, err := newSession(pubKeys, pubNonces, msg)
+	if err != nil {
+		return nil, err
+	}
+	i, err := s.signer(PublicKey(priv))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(publicNonce(secNonce), pubNonces[i]) {
+		return nil, errors.New("the secret nonce is not the one of the signer")
+	}
+
+	// keys and nonces are negated like Sign does, for the points to have an
+	// even y: those of the signer as it was lifted, and the aggregate ones
+	k1 := new(big.Int).SetBytes(secNonce[:32])
+	k2 := new(big.Int).SetBytes(secNonce[32:])
+	if !s.r.hasEvenY() {
+		k1, k2 = negate(k1), negate(k2)
+	}
+	d, _ := evenKey(priv.D)
+	if !s.q.hasEvenY() {
+		d = negate(d)
+	}
+
+	partialSig := new(big.Int).Mul(s.e, s.coefs[i])
+	partialSig.Mul(partialSig, d)
+	partialSig.Add(partialSig, k1)
+	partialSig.Add(partialSig, new(big.Int).Mul(s.b, k2))
+	partialSig.Mod(partialSig, n)
+
+	return scalarBytes(partialSig), nil
+}
+
+// PartialVerify checks the partial signature of the signer of pubKey, so that
+// a wrong one can be blamed on its author rather than fail the aggregate
+func PartialVerify(partialSig, pubKey []byte, pubKeys, pubNonces [][]byte, msg []byte) bool {
+	if len(partialSig) != PARTIAL_SIGNATURE_SIZE {
+		return false
+	}
+	s, err := newSession(pubKeys, pubNonces, msg)
+	if err != nil {
+		return false
+	}
+	i, err := s.signer(pubKey)
+	if err != nil {
+		return false
+	}
+	sig := new(big.Int).SetBytes(partialSig)
+	if sig.Cmp(curve.Params().N) >= 0 {
+		return false
+	}
+
+	// s_i*G = R1_i + b*R2_i + e*a_i*P_i, with the signs of PartialSign
+	r1, _ := decompress(pubNonces[i][:33])
+	r2, _ := decompress(pubNonces[i][33:])
+	r := r1.add(r2.mult(s.b))
+	if !s.r.hasEvenY() {
+		r = r.neg()
+	}
+	p := s.points[i]
+	if !s.q.hasEvenY() {
+		p = p.neg()
+	}
+	ea := new(big.Int).Mul(s.e, s.coefs[i])
+	expected := r.add(p.mult(ea.Mod(ea, curve.Params().N)))
+	actual := baseMult(sig)
+
+	return actual.x.Cmp(expected.x) == 0 && actual.y.Cmp(expected.y) == 0
+}
+
+// AggregateSignatures sums the partial signatures of all the signers, in the
+// order of their keys, into a signature by the aggregate key
+func AggregateSignatures(partialSigs, pubKeys, pubNonces [][]byte, msg []byte) ([]byte, error) {
+	n := curve.Params().N
+	if len(partialSigs) != len(pubKeys) {
+		return nil, fmt.Errorf("%d partial signatures for %d keys", len(partialSigs), len(pubKeys))
+	}
+	s, err := newSession(pubKeys, pubNonces, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := new(big.Int)
+	for i, partialSig := range partialSigs {
+		if !PartialVerify(partialSig, pubKeys[i], pubKeys, pubNonces, msg) {
+			return nil, fmt.Errorf("invalid partial signature of signer %d", i)
+		}
+		sum.Add(sum, new(big.Int).SetBytes(partialSig))
+	}
+	sum.Mod(sum, n)
+
+	return append(s.r.bytes(), scalarBytes(sum)...), nil
+}
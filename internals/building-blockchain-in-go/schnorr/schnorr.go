@@ -0,0 +1,214 @@
+// Package schnorr implements Schnorr signatures as specified by BIP340, and
+// MuSig2 to aggregate the keys of several signers into one.
+//
+// A Schnorr signature proves the knowledge of the private key d of the public
+// key P = d*G with a nonce point R = k*G and s = k + e*d, e hashing R, P and
+// the message. Unlike ECDSA, signatures and keys add up: that is what lets
+// MuSig2 signers produce one signature for the sum of their keys.
+//
+// Bitcoin uses the secp256k1 curve, we stick to the P-256 curve of our wallets
+// so that their keys sign both ways. Like in BIP340, public keys are their x
+// coordinate only, the point with an even y being implied.
+//
+// https://github.com/bitcoin/bips/blob/master/bip-0340.mediawiki
+package schnorr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const (
+	// PUBKEY_SIZE is the size of an x-only public key
+	PUBKEY_SIZE = 32
+	// SIGNATURE_SIZE is the size of a signature, the x of R and s
+	SIGNATURE_SIZE = 64
+)
+
+// ErrInvalidPubKey is returned for a public key which is not on the curve
+var ErrInvalidPubKey = errors.New("invalid public key")
+
+var curve = elliptic.P256()
+
+// point is a point of the curve, (0, 0) being the point at infinity
+type point struct {
+	x, y *big.Int
+}
+
+func baseMult(k *big.Int) point {
+	x, y := curve.ScalarBaseMult(scalarBytes(k))
+
+	return point{x, y}
+}
+
+func (p point) mult(k *big.Int) point {
+	x, y := curve.ScalarMult(p.x, p.y, scalarBytes(k))
+
+	return point{x, y}
+}
+
+func (p point) add(q point) point {
+	if p.infinity() {
+		return q
+	}
+	if q.infinity() {
+		return p
+	}
+	x, y := curve.Add(p.x, p.y, q.x, q.y)
+
+	return point{x, y}
+}
+
+func (p point) infinity() bool {
+	return p.x.Sign() == 0 && p.y.Sign() == 0
+}
+
+func (p point) hasEvenY() bool {
+	return p.y.Bit(0) == 0
+}
+
+// bytes returns the x-only encoding of the point
+func (p point) bytes() []byte {
+	return scalarBytes(p.x)
+}
+
+// scalarBytes encodes a number on 32 bytes
+func scalarBytes(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
+
+// liftX returns the point of x coordinate x with an even y, if any
+func liftX(x []byte) (point, error) {
+	params := curve.Params()
+	if len(x) != PUBKEY_SIZE {
+		return point{}, ErrInvalidPubKey
+	}
+	px := new(big.Int).SetBytes(x)
+	if px.Cmp(params.P) >= 0 {
+		return point{}, ErrInvalidPubKey
+	}
+
+	// y² = x³ - 3x + b, and since p = 3 mod 4 the square root of c is
+	// c^((p+1)/4) when there is one
+	c := new(big.Int).Exp(px, big.NewInt(3), params.P)
+	c.Sub(c, new(big.Int).Mul(px, big.NewInt(3)))
+	c.Add(c, params.B)
+	c.Mod(c, params.P)
+	exp := new(big.Int).Add(params.P, big.NewInt(1))
+	exp.Rsh(exp, 2)
+	y := new(big.Int).Exp(c, exp, params.P)
+	if new(big.Int).Exp(y, big.NewInt(2), params.P).Cmp(c) != 0 {
+		return point{}, ErrInvalidPubKey
+	}
+	if y.Bit(0) != 0 {
+		y.Sub(params.P, y)
+	}
+
+	return point{px, y}, nil
+}
+
+// taggedHash hashes the data for a single purpose, the tag, so that a hash
+// computed for one cannot be reused for another
+func taggedHash(tag string, data ...[]byte) []byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+
+	return h.Sum(nil)
+}
+
+// hashToScalar reduces a tagged hash modulo the order of the curve
+func hashToScalar(tag string, data ...[]byte) *big.Int {
+	e := new(big.Int).SetBytes(taggedHash(tag, data...))
+
+	return e.Mod(e, curve.Params().N)
+}
+
+// negate returns n - k, the opposite of k modulo the order of the curve
+func negate(k *big.Int) *big.Int {
+	n := curve.Params().N
+	neg := new(big.Int).Sub(n, k)
+
+	return neg.Mod(neg, n)
+}
+
+// evenKey returns the private key of the point with an even y sharing the x
+// of the public key of d
+func evenKey(d *big.Int) (*big.Int, point) {
+	p := baseMult(d)
+	if !p.hasEvenY() {
+		return negate(d), point{p.x, new(big.Int).Sub(curve.Params().P, p.y)}
+	}
+
+	return d, p
+}
+
+// PublicKey returns the x-only public key of a private key
+func PublicKey(priv *ecdsa.PrivateKey) []byte {
+	return baseMult(priv.D).bytes()
+}
+
+// challenge is e, the hash of the nonce point, the public key and the message
+func challenge(r, pubKey, msg []byte) *big.Int {
+	return hashToScalar("BIP0340/challenge", r, pubKey, msg)
+}
+
+// Sign signs the message with the private key
+func Sign(priv *ecdsa.PrivateKey, msg []byte) ([]byte, error) {
+	n := curve.Params().N
+	d, p := evenKey(priv.D)
+
+	// the nonce mixes fresh randomness with the key and the message, so that a
+	// weak random source alone cannot leak the key
+	aux := make([]byte, 32)
+	if _, err := rand.Read(aux); err != nil {
+		return nil, err
+	}
+	t := scalarBytes(d)
+	for i, b := range taggedHash("BIP0340/aux", aux) {
+		t[i] ^= b
+	}
+	k := hashToScalar("BIP0340/nonce", t, p.bytes(), msg)
+	if k.Sign() == 0 {
+		return nil, errors.New("nonce is zero")
+	}
+	k, r := evenKey(k)
+
+	e := challenge(r.bytes(), p.bytes(), msg)
+	s := new(big.Int).Mul(e, d)
+	s.Add(s, k)
+	s.Mod(s, n)
+
+	return append(r.bytes(), scalarBytes(s)...), nil
+}
+
+// Verify checks the signature of the message by the x-only public key:
+// s*G - e*P must be the nonce point R
+func Verify(pubKey, msg, signature []byte) bool {
+	params := curve.Params()
+	if len(signature) != SIGNATURE_SIZE {
+		return false
+	}
+	p, err := liftX(pubKey)
+	if err != nil {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+	if r.Cmp(params.P) >= 0 || s.Cmp(params.N) >= 0 {
+		return false
+	}
+
+	e := challenge(signature[:32], pubKey, msg)
+	R := baseMult(s).add(p.mult(negate(e)))
+
+	return !R.infinity() && R.hasEvenY() && R.x.Cmp(r) == 0
+}
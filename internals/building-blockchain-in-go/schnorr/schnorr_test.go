@@ -0,0 +1,87 @@
+package schnorr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	return priv
+}
+
+func TestSchnorr(t *testing.T) {
+	msg := sha256.Sum256([]byte("message"))
+
+	// half of the keys have an odd y, which must not matter
+	for i := 0; i < 8; i++ {
+		priv := newKey(t)
+		pubKey := PublicKey(priv)
+		assert.Equal(t, PUBKEY_SIZE, len(pubKey))
+
+		signature, err := Sign(priv, msg[:])
+		assert.Nil(t, err)
+		assert.Equal(t, SIGNATURE_SIZE, len(signature))
+		assert.True(t, Verify(pubKey, msg[:], signature))
+
+		other := sha256.Sum256([]byte("other message"))
+		assert.False(t, Verify(pubKey, other[:], signature))
+		assert.False(t, Verify(PublicKey(newKey(t)), msg[:], signature))
+		signature[40] ^= 1
+		assert.False(t, Verify(pubKey, msg[:], signature))
+	}
+
+	assert.False(t, Verify(make([]byte, PUBKEY_SIZE), msg[:], make([]byte, SIGNATURE_SIZE)))
+}
+
+func TestMuSig2(t *testing.T) {
+	msg := sha256.Sum256([]byte("spend"))
+
+	var keys []*ecdsa.PrivateKey
+	var pubKeys, secNonces, pubNonces [][]byte
+	for i := 0; i < 3; i++ {
+		keys = append(keys, newKey(t))
+		pubKeys = append(pubKeys, PublicKey(keys[i]))
+
+		secNonce, pubNonce, err := NewNonce()
+		assert.Nil(t, err)
+		secNonces = append(secNonces, secNonce)
+		pubNonces = append(pubNonces, pubNonce)
+	}
+	aggregate, err := AggregateKeys(pubKeys)
+	assert.Nil(t, err)
+
+	var partialSigs [][]byte
+	for i, key := range keys {
+		partialSig, err := PartialSign(key, secNonces[i], pubKeys, pubNonces, msg[:])
+		assert.Nil(t, err)
+		assert.True(t, PartialVerify(partialSig, pubKeys[i], pubKeys, pubNonces, msg[:]))
+		partialSigs = append(partialSigs, partialSig)
+	}
+
+	// one plain Schnorr signature for the aggregate key
+	signature, err := AggregateSignatures(partialSigs, pubKeys, pubNonces, msg[:])
+	assert.Nil(t, err)
+	assert.True(t, Verify(aggregate, msg[:], signature))
+
+	// the key depends on the order of the keys
+	reversed, err := AggregateKeys([][]byte{pubKeys[2], pubKeys[1], pubKeys[0]})
+	assert.Nil(t, err)
+	assert.NotEqual(t, aggregate, reversed)
+
+	// a signer's nonce has to be its own, and a partial signature is blamed
+	_, err = PartialSign(keys[0], secNonces[1], pubKeys, pubNonces, msg[:])
+	assert.NotNil(t, err)
+	_, err = PartialSign(newKey(t), secNonces[0], pubKeys, pubNonces, msg[:])
+	assert.NotNil(t, err)
+	partialSigs[1] = partialSigs[2]
+	_, err = AggregateSignatures(partialSigs, pubKeys, pubNonces, msg[:])
+	assert.EqualError(t, err, "invalid partial signature of signer 1")
+}
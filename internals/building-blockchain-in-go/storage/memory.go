@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// Memory is a Store living in memory only, gone once closed. It is meant for
+// tests and short lived chains rather than big ones: a bucket is copied the
+// first time a transaction writes to it.
+//
+// The committed buckets are never modified, so a read-only transaction just
+// holds on to the ones current when it started. A read-write transaction
+// works on copies and swaps them in on commit, or drops them on rollback.
+type Memory struct {
+	// writers are serialized, like bolt does
+	writer sync.Mutex
+	mu     sync.RWMutex
+	// committed state
+	buckets map[string]*memBucket
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*memBucket)}
+}
+
+// View implements Store
+func (s *Memory) View(fn func(Tx) error) error {
+	s.mu.RLock()
+	buckets := s.buckets
+	s.mu.RUnlock()
+
+	return fn(&memTx{buckets: buckets})
+}
+
+// Update implements Store
+func (s *Memory) Update(fn func(Tx) error) error {
+	s.writer.Lock()
+	defer s.writer.Unlock()
+
+	s.mu.RLock()
+	tx := &memTx{buckets: make(map[string]*memBucket), writable: true, owned: make(map[string]bool)}
+	for name, b := range s.buckets {
+		tx.buckets[name] = b
+	}
+	s.mu.RUnlock()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.buckets = tx.buckets
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Close implements Store
+func (s *Memory) Close() error {
+	return nil
+}
+
+// memBucket holds the pairs of a bucket along with the sorted keys, for the
+// cursors
+type memBucket struct {
+	keys   []string
+	values map[string][]byte
+}
+
+func newMemBucket() *memBucket {
+	return &memBucket{values: make(map[string][]byte)}
+}
+
+func (b *memBucket) clone() *memBucket {
+	c := &memBucket{keys: append([]string{}, b.keys...), values: make(map[string][]byte, len(b.values))}
+	for k, v := range b.values {
+		c.values[k] = v
+	}
+
+	return c
+}
+
+// search returns the position of the first key >= key
+func (b *memBucket) search(key string) int {
+	return sort.SearchStrings(b.keys, key)
+}
+
+type memTx struct {
+	buckets  map[string]*memBucket
+	writable bool
+	// the buckets copied or created by this transaction, safe to modify
+	owned map[string]bool
+}
+
+func (t *memTx) Bucket(name []byte) Bucket {
+	if _, ok := t.buckets[string(name)]; !ok {
+		return nil
+	}
+
+	return &memBucketHandle{t, string(name)}
+}
+
+func (t *memTx) CreateBucket(name []byte) (Bucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+	if _, ok := t.buckets[string(name)]; ok {
+		return nil, ErrBucketExists
+	}
+
+	t.buckets[string(name)] = newMemBucket()
+	t.owned[string(name)] = true
+
+	return &memBucketHandle{t, string(name)}, nil
+}
+
+func (t *memTx) CreateBucketIfNotExists(name []byte) (Bucket, error) {
+	if b := t.Bucket(name); b != nil {
+		return b, nil
+	}
+
+	return t.CreateBucket(name)
+}
+
+func (t *memTx) DeleteBucket(name []byte) error {
+	if !t.writable {
+		return ErrTxNotWritable
+	}
+	if _, ok := t.buckets[string(name)]; !ok {
+		return ErrBucketNotFound
+	}
+
+	delete(t.buckets, string(name))
+	delete(t.owned, string(name))
+
+	return nil
+}
+
+// mutable returns a copy of the bucket this transaction can write to
+func (t *memTx) mutable(name string) (*memBucket, error) {
+	if !t.writable {
+		return nil, ErrTxNotWritable
+	}
+
+	b, ok := t.buckets[name]
+	if !ok {
+		return nil, ErrBucketNotFound
+	}
+	if !t.owned[name] {
+		b = b.clone()
+		t.buckets[name] = b
+		t.owned[name] = true
+	}
+
+	return b, nil
+}
+
+// memBucketHandle looks the bucket up on every call, as it is replaced by a
+// copy on the first write
+type memBucketHandle struct {
+	tx   *memTx
+	name string
+}
+
+func (h *memBucketHandle) bucket() *memBucket {
+	if b, ok := h.tx.buckets[h.name]; ok {
+		return b
+	}
+
+	// deleted meanwhile
+	return newMemBucket()
+}
+
+func (h *memBucketHandle) Get(key []byte) []byte {
+	return h.bucket().values[string(key)]
+}
+
+func (h *memBucketHandle) Put(key, value []byte) error {
+	b, err := h.tx.mutable(h.name)
+	if err != nil {
+		return err
+	}
+
+	k := string(key)
+	if _, ok := b.values[k]; !ok {
+		i := b.search(k)
+		b.keys = append(b.keys, "")
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = k
+	}
+	// the caller may reuse its buffer, bolt copies it too
+	b.values[k] = append([]byte{}, value...)
+
+	return nil
+}
+
+func (h *memBucketHandle) Delete(key []byte) error {
+	b, err := h.tx.mutable(h.name)
+	if err != nil {
+		return err
+	}
+
+	k := string(key)
+	if _, ok := b.values[k]; !ok {
+		return nil
+	}
+	i := b.search(k)
+	b.keys = append(b.keys[:i], b.keys[i+1:]...)
+	delete(b.values, k)
+
+	return nil
+}
+
+func (h *memBucketHandle) Cursor() Cursor {
+	return &memCursor{bucket: h}
+}
+
+func (h *memBucketHandle) ForEach(fn func(k, v []byte) error) error {
+	c := h.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// memCursor remembers the key it is at rather than a position, so it keeps
+// working when the bucket changes under it
+type memCursor struct {
+	bucket *memBucketHandle
+	key    []byte
+}
+
+func (c *memCursor) First() ([]byte, []byte) {
+	return c.at(0)
+}
+
+func (c *memCursor) Next() ([]byte, []byte) {
+	if c.key == nil {
+		return nil, nil
+	}
+
+	b := c.bucket.bucket()
+	i := b.search(string(c.key))
+	if i < len(b.keys) && b.keys[i] == string(c.key) {
+		i++
+	}
+
+	return c.at(i)
+}
+
+func (c *memCursor) Seek(seek []byte) ([]byte, []byte) {
+	return c.at(c.bucket.bucket().search(string(seek)))
+}
+
+func (c *memCursor) at(i int) ([]byte, []byte) {
+	b := c.bucket.bucket()
+	if i >= len(b.keys) {
+		c.key = nil
+		return nil, nil
+	}
+
+	c.key = []byte(b.keys[i])
+
+	return c.key, b.values[b.keys[i]]
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func put(t *testing.T, s Store, pairs ...string) {
+	err := s.Update(func(tx Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("b"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(pairs); i += 2 {
+			if err := b.Put([]byte(pairs[i]), []byte(pairs[i+1])); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	assert.Nil(t, err)
+}
+
+func keys(s Store) []string {
+	var keys []string
+	_ = s.View(func(tx Tx) error {
+		return tx.Bucket([]byte("b")).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+
+	return keys
+}
+
+func TestMemoryCursor(t *testing.T) {
+	s := NewMemory()
+	put(t, s, "c", "3", "a", "1", "d", "4", "b", "2")
+
+	assert.Equal(t, []string{"a", "b", "c", "d"}, keys(s), "Keys are sorted")
+
+	_ = s.View(func(tx Tx) error {
+		c := tx.Bucket([]byte("b")).Cursor()
+		k, v := c.Seek([]byte("bb"))
+		assert.Equal(t, "c", string(k), "Seek lands on the next key")
+		assert.Equal(t, "3", string(v))
+		k, _ = c.Next()
+		assert.Equal(t, "d", string(k))
+		k, _ = c.Next()
+		assert.Nil(t, k, "Past the last key")
+
+		assert.Nil(t, tx.Bucket([]byte("missing")))
+		return nil
+	})
+
+	// deleting while walking, as when pruning
+	err := s.Update(func(tx Tx) error {
+		b := tx.Bucket([]byte("b"))
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if k[0] != 'c' {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c"}, keys(s))
+}
+
+func TestMemoryTransactions(t *testing.T) {
+	s := NewMemory()
+	put(t, s, "a", "1")
+
+	// a failed update leaves nothing behind
+	failed := errors.New("failed")
+	err := s.Update(func(tx Tx) error {
+		_ = tx.Bucket([]byte("b")).Put([]byte("b"), []byte("2"))
+		_, _ = tx.CreateBucket([]byte("other"))
+		return failed
+	})
+	assert.Equal(t, failed, err)
+	assert.Equal(t, []string{"a"}, keys(s))
+
+	// a reader keeps seeing the store as it was when it started
+	_ = s.View(func(tx Tx) error {
+		put(t, s, "b", "2")
+		assert.Nil(t, tx.Bucket([]byte("b")).Get([]byte("b")))
+		assert.Equal(t, ErrTxNotWritable, tx.Bucket([]byte("b")).Put([]byte("c"), nil))
+		return nil
+	})
+	assert.Equal(t, []string{"a", "b"}, keys(s))
+
+	err = s.Update(func(tx Tx) error {
+		_, err := tx.CreateBucket([]byte("b"))
+		assert.Equal(t, ErrBucketExists, err)
+		assert.Nil(t, tx.DeleteBucket([]byte("b")))
+		return tx.DeleteBucket([]byte("b"))
+	})
+	assert.Equal(t, ErrBucketNotFound, err)
+}
@@ -0,0 +1,68 @@
+// Package storage abstracts the key/value store the chain is persisted in.
+//
+// The model is the one of bolt, which the chain was first written against:
+// values live in named buckets, sorted by key, and are only accessed within
+// transactions. Read-only transactions see a consistent snapshot of the
+// store, and read-write ones are atomic: either all their writes are applied
+// or none of them. That is what lets a block and its changes to the UTXO set
+// be written as one batch.
+//
+// Two implementations are provided: Bolt, a file on disk, and Memory, for
+// tests and throwaway chains.
+package storage
+
+import "errors"
+
+var (
+	// ErrBucketNotFound is returned when deleting a bucket that does not exist
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists is returned when creating a bucket that already exists
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrTxNotWritable is returned when writing within a read-only transaction
+	ErrTxNotWritable = errors.New("tx not writable")
+	// ErrLocked is returned when another process holds the store
+	ErrLocked = errors.New("store is locked by another process")
+)
+
+// Store is a transactional key/value store
+type Store interface {
+	// View runs fn within a read-only transaction
+	View(fn func(Tx) error) error
+	// Update runs fn within a read-write transaction, committed if fn returns
+	// nil and rolled back otherwise. Only one runs at a time.
+	Update(fn func(Tx) error) error
+	// Close releases the store
+	Close() error
+}
+
+// Tx is a transaction, only valid until the function it was given to returns
+type Tx interface {
+	// Bucket returns the bucket with the given name, nil if it doesn't exist
+	Bucket(name []byte) Bucket
+	CreateBucket(name []byte) (Bucket, error)
+	CreateBucketIfNotExists(name []byte) (Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
+// Bucket is a collection of key/value pairs, sorted by key. The slices it
+// returns are only valid during the transaction and must not be modified.
+type Bucket interface {
+	// Get returns the value of key, nil if it doesn't exist
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	// Delete removes key, if it exists
+	Delete(key []byte) error
+	Cursor() Cursor
+	// ForEach calls fn for each pair in key order, stopping at the first
+	// error
+	ForEach(fn func(k, v []byte) error) error
+}
+
+// Cursor walks the pairs of a bucket in key order. Every method returns a nil
+// key once past the last pair.
+type Cursor interface {
+	First() (key, value []byte)
+	Next() (key, value []byte)
+	// Seek moves to key, or the next key when it doesn't exist
+	Seek(seek []byte) (key, value []byte)
+}
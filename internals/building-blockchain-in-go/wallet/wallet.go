@@ -0,0 +1,172 @@
+// Package wallet manages the key pairs of a user and the addresses derived from
+// them.
+package wallet
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"math/big"
+
+	"github.com/xav-b/blockchain/encoding"
+	"golang.org/x/crypto/ripemd160"
+)
+
+const version = byte(0x00)
+
+// COORD_SIZE is the size of a coordinate of a P256 public key
+const COORD_SIZE = 32
+
+// WALLET_FILE is the name of the wallet file in the data directory
+const WALLET_FILE = "wallet.dat"
+
+// ErrInvalidAddress is returned when decoding a malformed address
+var ErrInvalidAddress = errors.New("invalid address")
+
+// Wallet stores private and public keys
+type Wallet struct {
+	PrivateKey ecdsa.PrivateKey
+	PublicKey  []byte
+}
+
+// NewWallet creates and returns a Wallet
+func NewWallet() (*Wallet, error) {
+	private, public, err := newKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Wallet{private, public}, nil
+}
+
+// walletGob is what is actually stored in the wallet file. The curve of an
+// ecdsa.PrivateKey has no exported fields for gob to encode, so we keep the
+// private scalar only and derive the rest back from it.
+type walletGob struct {
+	D         []byte
+	PublicKey []byte
+}
+
+// GobEncode implements gob.GobEncoder
+func (w Wallet) GobEncode() ([]byte, error) {
+	var content bytes.Buffer
+
+	encoder := gob.NewEncoder(&content)
+	err := encoder.Encode(walletGob{w.PrivateKey.D.Bytes(), w.PublicKey})
+
+	return content.Bytes(), err
+}
+
+// GobDecode implements gob.GobDecoder
+func (w *Wallet) GobDecode(data []byte) error {
+	var raw walletGob
+
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&raw); err != nil {
+		return err
+	}
+
+	curve := elliptic.P256()
+	w.PrivateKey.D = new(big.Int).SetBytes(raw.D)
+	w.PrivateKey.PublicKey.Curve = curve
+	w.PrivateKey.PublicKey.X, w.PrivateKey.PublicKey.Y = curve.ScalarBaseMult(raw.D)
+	// wallets saved before the coordinates were padded hold a shorter key,
+	// unspendable when its first coordinate is the short one: it is encoded
+	// again, its address does not change
+	w.PublicKey = encodePubKey(w.PrivateKey.PublicKey)
+
+	return nil
+}
+
+func newKeyPair() (ecdsa.PrivateKey, []byte, error) {
+	// Bitcoin uses Secp256k1 as its elliptic curve's parameters
+	// https://en.bitcoin.it/wiki/Secp256k1
+	curve := elliptic.P256()
+	private, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		return ecdsa.PrivateKey{}, nil, err
+	}
+
+	return *private, encodePubKey(private.PublicKey), nil
+}
+
+// encodePubKey concatenates the coordinates of a public key, each padded to
+// COORD_SIZE bytes so that the key is split back in two halves
+func encodePubKey(key ecdsa.PublicKey) []byte {
+	pubKey := make([]byte, 2*COORD_SIZE)
+	key.X.FillBytes(pubKey[:COORD_SIZE])
+	key.Y.FillBytes(pubKey[COORD_SIZE:])
+
+	return pubKey
+}
+
+// Address returns wallet address
+// It is built concatanating hash of the pb + version + checksum
+func (w Wallet) Address() []byte {
+	pubKeyHash := HashPubKey(w.PublicKey)
+
+	return []byte(PubKeyHashToAddress(pubKeyHash))
+}
+
+// PubKeyHashToAddress encodes a public key hash as a (version + hash +
+// checksum) Base58 address
+func PubKeyHashToAddress(pubKeyHash []byte) string {
+	versionedPayload := append([]byte{version}, pubKeyHash...)
+	checksum := encoding.Checksum(versionedPayload)
+
+	fullPayload := append(versionedPayload, checksum...)
+	address := encoding.Base58Encode(fullPayload)
+
+	return string(address)
+}
+
+// AddressToPubKeyHash strips the version and checksum of an address
+func AddressToPubKeyHash(address string) ([]byte, error) {
+	if !ValidateAddress(address) {
+		return nil, ErrInvalidAddress
+	}
+	pubKeyHash := encoding.Base58Decode([]byte(address))
+
+	return pubKeyHash[1 : len(pubKeyHash)-encoding.CHECKSUM_LEN], nil
+}
+
+// HashPubKey hashes public key using Bitcoin approahc: SHA256(RIPEMD160(pubkey))
+// The coordinates of a key are hashed without their padding, the way wallets
+// encoded them at first, for the addresses of those keys not to change.
+func HashPubKey(pubKey []byte) []byte {
+	if len(pubKey) == 2*COORD_SIZE {
+		x := new(big.Int).SetBytes(pubKey[:COORD_SIZE])
+		y := new(big.Int).SetBytes(pubKey[COORD_SIZE:])
+		pubKey = append(x.Bytes(), y.Bytes()...)
+	}
+
+	publicSHA256 := sha256.Sum256(pubKey)
+
+	RIPEMD160Hasher := ripemd160.New()
+	// writing to a hash never fails
+	_, _ = RIPEMD160Hasher.Write(publicSHA256[:])
+	publicRIPEMD160 := RIPEMD160Hasher.Sum(nil)
+
+	return publicRIPEMD160
+}
+
+// ValidateAddress check if address if valid
+func ValidateAddress(address string) bool {
+	pubKeyHash := encoding.Base58Decode([]byte(address))
+	// too short to even hold the version and checksum
+	if len(pubKeyHash) <= 1+encoding.CHECKSUM_LEN {
+		return false
+	}
+	// pubKeyHash is the concatanation of version + hash + checksum
+	// so we extract accordingly
+	actualChecksum := pubKeyHash[len(pubKeyHash)-encoding.CHECKSUM_LEN:]
+	version := pubKeyHash[0] // first byte
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-encoding.CHECKSUM_LEN]
+	targetChecksum := encoding.Checksum(append([]byte{version}, pubKeyHash...))
+
+	return bytes.Equal(actualChecksum, targetChecksum)
+}
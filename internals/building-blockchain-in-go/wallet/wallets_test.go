@@ -0,0 +1,48 @@
+package wallet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetWallet(t *testing.T) {
+	wallets := Wallets{Wallets: map[string]*Wallet{}}
+	address, err := wallets.CreateWallet()
+	assert.Nil(t, err)
+
+	w, err := wallets.GetWallet(address)
+	assert.Nil(t, err)
+	assert.Equal(t, address, string(w.Address()))
+
+	other, _ := (&Wallets{Wallets: map[string]*Wallet{}}).CreateWallet()
+	_, err = wallets.GetWallet(other)
+	assert.True(t, errors.Is(err, ErrUnknownWallet), "Unknown address is an error")
+}
+
+func TestValidateAddress(t *testing.T) {
+	wallets := Wallets{Wallets: map[string]*Wallet{}}
+	address, _ := wallets.CreateWallet()
+
+	assert.True(t, ValidateAddress(address))
+	last := "z"
+	if address[len(address)-1] == 'z' {
+		last = "y"
+	}
+	assert.False(t, ValidateAddress(address[:len(address)-1]+last), "Checksum mismatch")
+	assert.False(t, ValidateAddress(""), "Too short for a checksum")
+	assert.False(t, ValidateAddress("1"), "Too short for a checksum")
+
+	_, err := AddressToPubKeyHash("nope")
+	assert.Equal(t, ErrInvalidAddress, err)
+
+	// the leading zero bytes of a hash survive the round trip
+	pubKeyHash := append([]byte{0x00, 0x00}, bytes.Repeat([]byte{0xab}, 18)...)
+	address = PubKeyHashToAddress(pubKeyHash)
+	assert.Equal(t, "111obQpVkjvXQdVpmdXWuvVHRuUi43VcX", address)
+	decoded, err := AddressToPubKeyHash(address)
+	assert.Nil(t, err)
+	assert.Equal(t, pubKeyHash, decoded)
+}